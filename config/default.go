@@ -2,10 +2,37 @@ package config
 
 import "github.com/spf13/viper"
 
+// Storage backends selectable with the STORAGE variable.
+const (
+	StorageMongo  = "mongo"
+	StorageMemory = "memory"
+	StorageSQLite = "sqlite"
+)
+
 type Config struct {
-	DBUri    string `mapstructure:"MONGO_URI"`
-	RedisUri string `mapstructure:"REDIS_URI"`
-	Port     string `mapstructure:"PORT"`
+	DBUri      string `mapstructure:"MONGO_URI"`
+	RedisUri   string `mapstructure:"REDIS_URI"`
+	Port       string `mapstructure:"PORT"`
+	Storage    string `mapstructure:"STORAGE"`
+	SQLitePath string `mapstructure:"SQLITE_PATH"`
+
+	// Key used to sign the session cookies, keep it secret and the same across restarts
+	SessionSecret string `mapstructure:"SESSION_SECRET"`
+
+	// How long a session cookie lasts before the player has to join the room again
+	SessionHours int `mapstructure:"SESSION_HOURS"`
+
+	// Whether browsers only send the session cookie over HTTPS, turn it on behind TLS
+	SessionSecure bool `mapstructure:"SESSION_SECURE"`
+
+	// How long a room stays loaded once everybody left, so players who reconnect find it as they left it
+	RoomIdleSeconds int `mapstructure:"ROOM_IDLE_SECONDS"`
+
+	// What to do with players whose connection cannot keep up, "resync" or "disconnect"
+	SlowClientPolicy string `mapstructure:"SLOW_CLIENT_POLICY"`
+
+	// Bearer token for the websocket metrics, which are not served without one
+	MetricsToken string `mapstructure:"METRICS_TOKEN"`
 }
 
 func LoadConfig(path string) (config Config, err error) {
@@ -14,6 +41,18 @@ func LoadConfig(path string) (config Config, err error) {
 	viper.SetConfigType("env") // name of the config file (without extension)
 	viper.SetConfigName("app") // REQUIRED if the config file does not have the extension in the name
 
+	viper.SetDefault("STORAGE", StorageMongo)
+	viper.SetDefault("SQLITE_PATH", "poker-chips.db")
+	viper.SetDefault("SESSION_SECRET", "")
+	viper.SetDefault("SESSION_HOURS", 72)
+	viper.SetDefault("SESSION_SECURE", false)
+	viper.SetDefault("ROOM_IDLE_SECONDS", 120)
+
+	// Without Redis the hub runs on a single instance
+	viper.SetDefault("REDIS_URI", "")
+	viper.SetDefault("SLOW_CLIENT_POLICY", "resync")
+	viper.SetDefault("METRICS_TOKEN", "")
+
 	viper.AutomaticEnv()
 
 	err = viper.ReadInConfig() // Find and read the config file
@@ -0,0 +1,25 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"github.com/redis/go-redis/v9"
+)
+
+// InitRedis to connect to the Redis server the instances share room broadcasts and leases through
+func InitRedis(cfg Config, ctx context.Context) *redis.Client {
+	options, err := redis.ParseURL(cfg.RedisUri)
+	if err != nil {
+		panic(err)
+	}
+
+	client := redis.NewClient(options)
+
+	if err = client.Ping(ctx).Err(); err != nil {
+		panic(err)
+	}
+
+	fmt.Println("Redis successfully connected.")
+
+	return client
+}
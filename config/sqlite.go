@@ -0,0 +1,29 @@
+package config
+
+import (
+	"database/sql"
+	"fmt"
+	_ "modernc.org/sqlite"
+)
+
+// InitSQLite to open the embedded SQLite database
+func InitSQLite(cfg Config) *sql.DB {
+	// Wait for locks instead of failing, and enforce the foreign keys declared in the schema
+	dsn := fmt.Sprintf("file:%s?_pragma=busy_timeout(5000)&_pragma=foreign_keys(1)", cfg.SQLitePath)
+
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		panic(err)
+	}
+
+	// SQLite allows a single writer, so funnel every query through one connection
+	db.SetMaxOpenConns(1)
+
+	if err = db.Ping(); err != nil {
+		panic(err)
+	}
+
+	fmt.Printf("SQLite database %v successfully opened.\n", cfg.SQLitePath)
+
+	return db
+}
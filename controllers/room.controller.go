@@ -1,38 +1,52 @@
 package controllers
 
 import (
-	"encoding/json"
 	"fmt"
 	"github.com/gin-gonic/gin"
 	"go-pokerchips/models"
 	"go-pokerchips/services"
-	"log"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 )
 
-const DefaultChipsPerUser = 1000
+const SessionCookie = "session"
+
+const (
+	DefaultLedgerPageSize = 50
+	MaxLedgerPageSize     = 200
+)
+
+const (
+	DefaultInviteMinutes = 60
+	MaxInviteMinutes     = 7 * 24 * 60
+)
+
+// RoomModerator applies host commands. The hub implements it, so the players in the room see them live.
+type RoomModerator interface {
+	Moderate(uri string, host string, input *models.ModerateRoomInput) error
+}
 
 type RoomController struct {
-	roomService services.RoomService
+	roomService    services.RoomService
+	sessionService *services.SessionService
+	moderator      RoomModerator
+
+	// Whether the session cookie is only sent over HTTPS
+	secureCookie bool
 }
 
-func NewRoomController(roomService services.RoomService) RoomController {
-	return RoomController{roomService}
+func NewRoomController(roomService services.RoomService, sessionService *services.SessionService, moderator RoomModerator, secureCookie bool) RoomController {
+	return RoomController{roomService, sessionService, moderator, secureCookie}
 }
 
 func (rc *RoomController) GetRoom(c *gin.Context) {
 
-	var session string
-	session, err := c.Cookie("session")
-
+	roomUser, err := rc.session(c)
 	if err != nil {
-		fmt.Println(err)
-	}
-
-	var roomUser *models.JoinRoomInput
-	if err = json.Unmarshal([]byte(session), &roomUser); err != nil {
-		log.Println(err)
+		c.JSON(http.StatusUnauthorized, gin.H{"status": "fail", "message": err.Error()})
+		return
 	}
 
 	if c.Param("uri") != roomUser.Uri {
@@ -59,32 +73,26 @@ func (rc *RoomController) CreateRoom(c *gin.Context) {
 		return
 	}
 
-	room.Record = make(map[string]int)
-	room.Record[room.Creator] = DefaultChipsPerUser
-
 	newRoom, err := rc.roomService.CreateRoom(room)
 
 	if err != nil {
 		if strings.Contains(err.Error(), "room already exists") {
 			c.JSON(http.StatusConflict, gin.H{"status": "fail", "message": err.Error()})
+		} else if strings.Contains(err.Error(), "invalid username") ||
+			err == services.ErrInvalidRoomType || err == services.ErrInvalidLevels || err == services.ErrInvalidSettings ||
+			err == services.ErrInvalidPassword {
+			c.JSON(http.StatusBadRequest, gin.H{"status": "fail", "message": err.Error()})
 		} else {
 			c.JSON(http.StatusBadGateway, gin.H{"status": "fail", "message": err.Error()})
 		}
 		return
 	}
 
-	userSession := map[string]string{
-		"uri":  room.Uri,
-		"name": room.Creator,
-	}
-
-	encodedStr, err := json.Marshal(userSession)
-
-	if err != nil {
-		log.Println(err)
+	if err = rc.setSession(c, &models.JoinRoomInput{User: room.Creator, Uri: room.Uri}); err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"status": "fail", "message": err.Error()})
+		return
 	}
 
-	c.SetCookie("session", string(encodedStr), 60*60*3600, "/", "localhost", false, false)
 	c.JSON(http.StatusCreated, gin.H{"status": "success", "data": newRoom})
 }
 
@@ -104,22 +112,176 @@ func (rc *RoomController) JoinRoom(c *gin.Context) {
 		return
 	}
 
-	if err = rc.roomService.RegisterUserInRoom(room.Id.Hex(), roomUser.User); err != nil {
+	if err = rc.roomService.RegisterUserInRoom(room.Id.Hex(), roomUser.User, roomUser.Password, roomUser.Invite); err != nil {
+		if err == services.ErrPasswordRequired || err == services.ErrWrongPassword || err == services.ErrInvalidInvite {
+			c.JSON(http.StatusUnauthorized, gin.H{"status": "fail", "message": err.Error()})
+		} else if err == services.ErrRoomFull || err == services.ErrLateJoin || err == services.ErrTournamentOver ||
+			err == services.ErrRoomLocked || err == services.ErrRoomClosed || err == services.ErrUserKicked {
+			c.JSON(http.StatusForbidden, gin.H{"status": "fail", "message": err.Error()})
+		} else {
+			c.JSON(http.StatusNotFound, gin.H{"status": "fail", "message": err.Error()})
+		}
+		return
+	}
+
+	if err = rc.setSession(c, &models.JoinRoomInput{User: roomUser.User, Uri: room.Uri}); err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"status": "fail", "message": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "success", "data": room})
+}
+
+func (rc *RoomController) GetLedger(c *gin.Context) {
+
+	roomUser, err := rc.session(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"status": "fail", "message": err.Error()})
+		return
+	}
+
+	if c.Param("uri") != roomUser.Uri {
+		c.JSON(http.StatusNotFound, gin.H{"status": "fail", "message": "room uri does not match with session"})
+		return
+	}
+
+	page, err := strconv.ParseInt(c.DefaultQuery("page", "1"), 10, 64)
+	if err != nil || page < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"status": "fail", "message": "page must be a positive number"})
+		return
+	}
+
+	limit, err := strconv.ParseInt(c.DefaultQuery("limit", strconv.Itoa(DefaultLedgerPageSize)), 10, 64)
+	if err != nil || limit < 1 || limit > MaxLedgerPageSize {
+		c.JSON(http.StatusBadRequest, gin.H{"status": "fail", "message": fmt.Sprintf("limit must be between 1 and %v", MaxLedgerPageSize)})
+		return
+	}
+
+	entries, err := rc.roomService.FindLedgerByUri(roomUser.Uri, page, limit)
+
+	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"status": "fail", "message": err.Error()})
 		return
 	}
 
-	userSession := map[string]string{
-		"uri":  room.Uri,
-		"name": roomUser.User,
+	c.JSON(http.StatusOK, gin.H{"status": "success", "page": page, "limit": limit, "results": len(entries), "data": entries})
+}
+
+// Moderate runs a host command (kick, transfer-host, adjust-stack, lock-room, unlock-room, close-room
+// or swap-seats) as the player of the session.
+func (rc *RoomController) Moderate(c *gin.Context) {
+
+	roomUser, err := rc.session(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"status": "fail", "message": err.Error()})
+		return
+	}
+
+	if c.Param("uri") != roomUser.Uri {
+		c.JSON(http.StatusNotFound, gin.H{"status": "fail", "message": "room uri does not match with session"})
+		return
 	}
 
-	encodedStr, err := json.Marshal(userSession)
+	var input *models.ModerateRoomInput
+
+	if err = c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err = rc.moderator.Moderate(roomUser.Uri, roomUser.User, input); err != nil {
+		if err == services.ErrNotHost {
+			c.JSON(http.StatusForbidden, gin.H{"status": "fail", "message": err.Error()})
+		} else if err == services.ErrRoomNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"status": "fail", "message": err.Error()})
+		} else {
+			c.JSON(http.StatusBadRequest, gin.H{"status": "fail", "message": err.Error()})
+		}
+		return
+	}
+
+	room, err := rc.roomService.FindRoomByUri(roomUser.Uri)
 
 	if err != nil {
-		log.Println(err)
+		c.JSON(http.StatusNotFound, gin.H{"status": "fail", "message": err.Error()})
+		return
 	}
 
-	c.SetCookie("session", string(encodedStr), 60*60*3600, "/", "localhost", false, false)
 	c.JSON(http.StatusOK, gin.H{"status": "success", "data": room})
 }
+
+// CreateInvite gives the host a single-use token that lets a player into the room without its password.
+func (rc *RoomController) CreateInvite(c *gin.Context) {
+
+	roomUser, err := rc.session(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"status": "fail", "message": err.Error()})
+		return
+	}
+
+	if c.Param("uri") != roomUser.Uri {
+		c.JSON(http.StatusNotFound, gin.H{"status": "fail", "message": "room uri does not match with session"})
+		return
+	}
+
+	input := &models.CreateInviteInput{}
+	if c.Request.ContentLength > 0 {
+		if err = c.ShouldBindJSON(input); err != nil {
+			c.JSON(http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	if input.Minutes == 0 {
+		input.Minutes = DefaultInviteMinutes
+	}
+
+	if input.Minutes < 1 || input.Minutes > MaxInviteMinutes {
+		c.JSON(http.StatusBadRequest, gin.H{"status": "fail", "message": fmt.Sprintf("minutes must be between 1 and %v", MaxInviteMinutes)})
+		return
+	}
+
+	room, err := rc.roomService.FindRoomByUri(roomUser.Uri)
+
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"status": "fail", "message": err.Error()})
+		return
+	}
+
+	token, expiresAt, err := rc.roomService.CreateInvite(room.Id.Hex(), roomUser.User, time.Duration(input.Minutes)*time.Minute)
+
+	if err != nil {
+		if err == services.ErrNotHost {
+			c.JSON(http.StatusForbidden, gin.H{"status": "fail", "message": err.Error()})
+		} else {
+			c.JSON(http.StatusBadRequest, gin.H{"status": "fail", "message": err.Error()})
+		}
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"status": "success", "data": gin.H{"uri": room.Uri, "invite": token, "expiresAt": expiresAt}})
+}
+
+// session returns the player and room of the signed session cookie.
+func (rc *RoomController) session(c *gin.Context) (*models.JoinRoomInput, error) {
+
+	cookie, err := c.Cookie(SessionCookie)
+	if err != nil {
+		return nil, services.ErrInvalidSession
+	}
+
+	return rc.sessionService.Verify(cookie)
+}
+
+func (rc *RoomController) setSession(c *gin.Context, session *models.JoinRoomInput) error {
+
+	cookie, err := rc.sessionService.Sign(session)
+	if err != nil {
+		return err
+	}
+
+	// Scripts have no use for the session, so keep it out of their reach
+	c.SetCookie(SessionCookie, cookie, int(rc.sessionService.TTL().Seconds()), "/", "localhost", rc.secureCookie, true)
+
+	return nil
+}
@@ -0,0 +1,255 @@
+package controllers
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"go-pokerchips/models"
+	"go-pokerchips/services"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// testServer serves the room routes over an in-memory room service.
+type testServer struct {
+	router         *gin.Engine
+	roomService    services.RoomService
+	sessionService *services.SessionService
+}
+
+func newTestServer(moderator RoomModerator) *testServer {
+
+	gin.SetMode(gin.TestMode)
+
+	server := &testServer{
+		router:         gin.New(),
+		roomService:    services.NewMemoryRoomService(),
+		sessionService: services.NewSessionService([]byte("test secret"), time.Hour),
+	}
+
+	rc := NewRoomController(server.roomService, server.sessionService, moderator, false)
+	router := server.router.Group("/api/room")
+	router.GET("/get/:uri", rc.GetRoom)
+	router.GET("/:uri/ledger", rc.GetLedger)
+	router.POST("/:uri/moderate", rc.Moderate)
+	router.POST("/:uri/invite", rc.CreateInvite)
+	router.POST("/join", rc.JoinRoom)
+	router.POST("/create", rc.CreateRoom)
+
+	return server
+}
+
+// session signs a session cookie for the player in the room.
+func (server *testServer) session(t *testing.T, user string, uri string) *http.Cookie {
+
+	t.Helper()
+
+	value, err := server.sessionService.Sign(&models.JoinRoomInput{User: user, Uri: uri})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &http.Cookie{Name: SessionCookie, Value: value}
+}
+
+// request sends the body as JSON, with the session cookie when there is one.
+func (server *testServer) request(t *testing.T, method string, path string, body any, session *http.Cookie) *httptest.ResponseRecorder {
+
+	t.Helper()
+
+	var data []byte
+	if body != nil {
+		var err error
+		if data, err = json.Marshal(body); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	request := httptest.NewRequest(method, path, bytes.NewReader(data))
+	request.Header.Set("Content-Type", "application/json")
+	if session != nil {
+		request.AddCookie(session)
+	}
+
+	recorder := httptest.NewRecorder()
+	server.router.ServeHTTP(recorder, request)
+
+	return recorder
+}
+
+func TestLedgerLimit(t *testing.T) {
+
+	server := newTestServer(nil)
+
+	room, err := server.roomService.CreateRoom(&models.CreateRoomInput{Creator: "ann"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	session := server.session(t, "ann", room.Uri)
+
+	tests := []struct {
+		query string
+		want  int
+	}{
+		{"", http.StatusOK},
+		{"?limit=1", http.StatusOK},
+		{"?limit=200", http.StatusOK},
+		{"?limit=201", http.StatusBadRequest},
+		{"?limit=0", http.StatusBadRequest},
+		{"?limit=ten", http.StatusBadRequest},
+		{"?page=0", http.StatusBadRequest},
+		{"?page=2&limit=200", http.StatusOK},
+	}
+
+	for _, test := range tests {
+		response := server.request(t, http.MethodGet, "/api/room/"+room.Uri+"/ledger"+test.query, nil, session)
+		if response.Code != test.want {
+			t.Errorf("ledger%v answered %v, want %v", test.query, response.Code, test.want)
+		}
+	}
+
+	var body struct {
+		Limit   int                   `json:"limit"`
+		Results int                   `json:"results"`
+		Data    []*models.LedgerEntry `json:"data"`
+	}
+	response := server.request(t, http.MethodGet, "/api/room/"+room.Uri+"/ledger", nil, session)
+	if err = json.Unmarshal(response.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if body.Limit != DefaultLedgerPageSize || body.Results != 1 || len(body.Data) != 1 {
+		t.Errorf("got limit %v and %v results, want limit %v and the register of ann", body.Limit, body.Results, DefaultLedgerPageSize)
+	}
+}
+
+func TestSessionCookie(t *testing.T) {
+
+	server := newTestServer(nil)
+
+	response := server.request(t, http.MethodPost, "/api/room/create", &models.CreateRoomInput{Creator: "ann"}, nil)
+	if response.Code != http.StatusCreated {
+		t.Fatalf("create answered %v: %v", response.Code, response.Body)
+	}
+
+	var body struct {
+		Data *models.DBRoom `json:"data"`
+	}
+	if err := json.Unmarshal(response.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	uri := body.Data.Uri
+
+	cookies := response.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != SessionCookie {
+		t.Fatalf("got cookies %v, want the session", cookies)
+	}
+	session := cookies[0]
+	if !session.HttpOnly || session.Secure {
+		t.Errorf("session cookie is httpOnly %v and secure %v, want httpOnly only", session.HttpOnly, session.Secure)
+	}
+	if session.MaxAge != int(time.Hour.Seconds()) {
+		t.Errorf("session cookie lasts %v seconds, want an hour", session.MaxAge)
+	}
+
+	if response = server.request(t, http.MethodGet, "/api/room/get/"+uri, nil, session); response.Code != http.StatusOK {
+		t.Errorf("get with the session answered %v, want %v", response.Code, http.StatusOK)
+	}
+
+	expired, err := services.NewSessionService([]byte("test secret"), -time.Second).Sign(&models.JoinRoomInput{User: "ann", Uri: uri})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		session *http.Cookie
+	}{
+		{"no session", nil},
+		{"tampered", &http.Cookie{Name: SessionCookie, Value: "x" + session.Value}},
+		{"expired", &http.Cookie{Name: SessionCookie, Value: expired}},
+	}
+
+	for _, test := range tests {
+		if response = server.request(t, http.MethodGet, "/api/room/get/"+uri, nil, test.session); response.Code != http.StatusUnauthorized {
+			t.Errorf("get with %v answered %v, want %v", test.name, response.Code, http.StatusUnauthorized)
+		}
+	}
+}
+
+func TestJoinRoomStatus(t *testing.T) {
+
+	server := newTestServer(nil)
+
+	room, err := server.roomService.CreateRoom(&models.CreateRoomInput{
+		Creator:  "ann",
+		Password: "secret",
+		Settings: models.RoomSettings{MaxPlayers: 4},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := room.Id.Hex()
+
+	// Invites are created over REST by the host only
+	invitePath := "/api/room/" + room.Uri + "/invite"
+	if response := server.request(t, http.MethodPost, invitePath, nil, server.session(t, "bob", room.Uri)); response.Code != http.StatusForbidden {
+		t.Errorf("invite by a player answered %v, want %v", response.Code, http.StatusForbidden)
+	}
+
+	response := server.request(t, http.MethodPost, invitePath, &models.CreateInviteInput{Minutes: 5}, server.session(t, "ann", room.Uri))
+	if response.Code != http.StatusCreated {
+		t.Fatalf("invite answered %v: %v", response.Code, response.Body)
+	}
+	var body struct {
+		Data struct {
+			Invite string `json:"invite"`
+		} `json:"data"`
+	}
+	if err = json.Unmarshal(response.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	invite := body.Data.Invite
+
+	steps := []struct {
+		name  string
+		input models.JoinRoomInput
+		setup func()
+		want  int
+	}{
+		{"unknown room", models.JoinRoomInput{User: "bob", Uri: "nope"}, nil, http.StatusNotFound},
+		{"no password", models.JoinRoomInput{User: "bob", Uri: room.Uri}, nil, http.StatusUnauthorized},
+		{"wrong password", models.JoinRoomInput{User: "bob", Uri: room.Uri, Password: "guess"}, nil, http.StatusUnauthorized},
+		{"unknown invite", models.JoinRoomInput{User: "bob", Uri: room.Uri, Invite: "nope"}, nil, http.StatusUnauthorized},
+		{"password", models.JoinRoomInput{User: "bob", Uri: room.Uri, Password: "secret"}, nil, http.StatusOK},
+		{"invite", models.JoinRoomInput{User: "cat", Uri: room.Uri, Invite: invite}, nil, http.StatusOK},
+		{"invite used again", models.JoinRoomInput{User: "dan", Uri: room.Uri, Invite: invite}, nil, http.StatusUnauthorized},
+		{"last seat", models.JoinRoomInput{User: "dan", Uri: room.Uri, Password: "secret"}, nil, http.StatusOK},
+		{"full", models.JoinRoomInput{User: "eve", Uri: room.Uri, Password: "secret"}, nil, http.StatusForbidden},
+		{"kicked", models.JoinRoomInput{User: "cat", Uri: room.Uri, Password: "secret"}, func() {
+			if err := server.roomService.KickUser(id, "ann", "cat", ""); err != nil {
+				t.Fatal(err)
+			}
+		}, http.StatusForbidden},
+		{"locked", models.JoinRoomInput{User: "eve", Uri: room.Uri, Password: "secret"}, func() {
+			if err := server.roomService.LockRoom(id, "ann", true); err != nil {
+				t.Fatal(err)
+			}
+		}, http.StatusForbidden},
+		{"closed", models.JoinRoomInput{User: "eve", Uri: room.Uri, Password: "secret"}, func() {
+			if err := server.roomService.CloseRoom(id, "ann"); err != nil {
+				t.Fatal(err)
+			}
+		}, http.StatusForbidden},
+	}
+
+	for _, step := range steps {
+		if step.setup != nil {
+			step.setup()
+		}
+		if response := server.request(t, http.MethodPost, "/api/room/join", &step.input, nil); response.Code != step.want {
+			t.Errorf("join %v answered %v, want %v", step.name, response.Code, step.want)
+		}
+	}
+}
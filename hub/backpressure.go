@@ -0,0 +1,88 @@
+package hub
+
+import "log"
+
+// What a room does with a client whose send buffer is full, because its connection cannot keep up.
+// Either way the room goroutine never waits for a client.
+const (
+	// Drop the messages until the buffer is half empty again, then send a room-state snapshot with
+	// the next message or, when the room is quiet, on the next tick of the room
+	SlowClientResync = "resync"
+
+	// Disconnect the client, it gets the room state again when it reconnects
+	SlowClientDisconnect = "disconnect"
+)
+
+// Messages buffered for a client before it counts as slow
+const sendBufferSize = 256
+
+// sendTo queues a message for a client without ever blocking the room goroutine, see the slow client policies.
+// Messages for a client that is no longer in the room are dropped.
+func (room *Room) sendTo(client *Client, data []byte) {
+
+	if _, ok := room.clients[client]; !ok {
+		return
+	}
+
+	// What a lagging client misses is made up for by the snapshot it gets once it caught up
+	if client.lagging {
+		client.dropped++
+		room.resync(client)
+		return
+	}
+
+	select {
+	case client.send <- data:
+		client.sent++
+		if queued := len(client.send); queued > client.highWater {
+			client.highWater = queued
+		}
+	default:
+		room.slowClient(client)
+	}
+}
+
+// slowClient applies the slow client policy of the hub to a client whose buffer is full.
+func (room *Room) slowClient(client *Client) {
+
+	client.dropped++
+
+	if room.hub.slowClients == SlowClientDisconnect {
+		log.Printf("Disconnecting %v from room %v, it is not keeping up\n", client.name, room.Uri)
+		room.dropClient(client)
+		return
+	}
+
+	if !client.lagging {
+		log.Printf("%v is not keeping up in room %v, dropping messages until it catches up\n", client.name, room.Uri)
+		client.lagging = true
+	}
+}
+
+// resync sends a lagging client the room state once it has caught up with half of its buffer.
+// The snapshot is taken after the message being dropped was broadcast, so it includes it.
+func (room *Room) resync(client *Client) {
+
+	if len(client.send) > cap(client.send)/2 {
+		return
+	}
+
+	client.lagging = false
+	client.resyncs++
+
+	if err := room.sendState(client); err != nil {
+		log.Printf("Could not take a snapshot of room %v: %v\n", room.Uri, err)
+		client.lagging = true
+	}
+}
+
+// resyncLagging sends the room state to the lagging clients that caught up, so a client is not left
+// behind until the next message when the room goes quiet.
+func (room *Room) resyncLagging() {
+
+	for client := range room.clients {
+		if client.lagging {
+			room.resync(client)
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package hub
+
+import (
+	"go-pokerchips/services"
+	"testing"
+	"time"
+)
+
+// clientMetrics returns the metrics the hub reports for a client, false when it is not connected.
+func clientMetrics(t *testing.T, hub *Hub, uri string, name string) (ClientMetrics, bool) {
+
+	t.Helper()
+
+	for _, room := range hub.Metrics() {
+		if room.Uri != uri {
+			continue
+		}
+		for _, client := range room.Clients {
+			if client.Name == name {
+				return client, true
+			}
+		}
+	}
+
+	return ClientMetrics{}, false
+}
+
+// chat has the sender say something and waits until every listener heard it.
+func chat(t *testing.T, sender *Client, text string, listeners ...*Client) {
+
+	t.Helper()
+
+	send(sender, Message{Action: SendMessageAction, Message: text})
+	for _, listener := range listeners {
+		for expect(t, listener, SendMessageAction).Message != text {
+		}
+	}
+}
+
+func TestStuckClientIsResynced(t *testing.T) {
+
+	roomService := services.NewMemoryRoomService()
+	hub := newTestHub(t, roomService, nil, time.Minute, SlowClientResync)
+	room := hub.GetOrCreateRoom(createTestRoom(t, roomService, "ann", "bob"))
+
+	ann := connect(t, room, "ann")
+	stuck := connect(t, room, "bob")
+
+	// Ann hears every message although the buffer of the other client filled up long ago
+	for i := 0; i < 2*sendBufferSize; i++ {
+		chat(t, ann, "hello", ann)
+	}
+
+	metrics, ok := clientMetrics(t, hub, room.Uri, "bob")
+	if !ok {
+		t.Fatal("bob is no longer connected")
+	}
+	if !metrics.Lagging {
+		t.Error("bob is not lagging")
+	}
+	if metrics.Dropped == 0 {
+		t.Error("no message was dropped for bob")
+	}
+	if metrics.HighWater != sendBufferSize || metrics.Queued != sendBufferSize {
+		t.Errorf("bob has %v queued and a high water of %v, want both at %v", metrics.Queued, metrics.HighWater, sendBufferSize)
+	}
+	if metrics.Sent+metrics.Dropped < 2*sendBufferSize {
+		t.Errorf("bob was sent %v and dropped %v messages, want %v at least", metrics.Sent, metrics.Dropped, 2*sendBufferSize)
+	}
+
+	if metrics, _ = clientMetrics(t, hub, room.Uri, "ann"); metrics.Dropped != 0 || metrics.Lagging {
+		t.Errorf("ann dropped %v messages, lagging %v", metrics.Dropped, metrics.Lagging)
+	}
+
+	// Once the client caught up, the next message is replaced by a snapshot
+	for len(stuck.send) > 0 {
+		<-stuck.send
+	}
+	chat(t, ann, "welcome back", ann)
+
+	if state := next(t, stuck); state.Action != RoomStateAction {
+		t.Errorf("bob got %v after catching up, want %v", state.Action, RoomStateAction)
+	}
+
+	if metrics, _ = clientMetrics(t, hub, room.Uri, "bob"); metrics.Lagging || metrics.Resyncs != 1 {
+		t.Errorf("bob is lagging %v after %v resyncs, want caught up after 1", metrics.Lagging, metrics.Resyncs)
+	}
+
+	chat(t, ann, "hello again", ann, stuck)
+}
+
+func TestStuckClientIsDisconnected(t *testing.T) {
+
+	roomService := services.NewMemoryRoomService()
+	hub := newTestHub(t, roomService, nil, time.Minute, SlowClientDisconnect)
+	room := hub.GetOrCreateRoom(createTestRoom(t, roomService, "ann", "bob", "cat"))
+
+	ann := connect(t, room, "ann")
+	stuck := connect(t, room, "bob")
+	cat := connect(t, room, "cat")
+
+	for i := 0; i < 2*sendBufferSize; i++ {
+		chat(t, ann, "hello", ann, cat)
+	}
+
+	if !isClosed(stuck.send) {
+		t.Fatal("bob is still connected")
+	}
+
+	if _, ok := clientMetrics(t, hub, room.Uri, "bob"); ok {
+		t.Error("the metrics still report bob")
+	}
+
+	for _, name := range []string{"ann", "cat"} {
+		metrics, ok := clientMetrics(t, hub, room.Uri, name)
+		if !ok {
+			t.Fatalf("%v was disconnected", name)
+		}
+		if metrics.Dropped != 0 || metrics.Lagging {
+			t.Errorf("%v dropped %v messages, lagging %v", name, metrics.Dropped, metrics.Lagging)
+		}
+		if metrics.Sent < 2*sendBufferSize {
+			t.Errorf("%v was sent %v messages, want %v at least", name, metrics.Sent, 2*sendBufferSize)
+		}
+	}
+}
+
+func TestDrainedClientIsResyncedInAQuietRoom(t *testing.T) {
+
+	roomService := services.NewMemoryRoomService()
+	hub := newTestHub(t, roomService, nil, time.Minute, SlowClientResync)
+	room := hub.GetOrCreateRoom(createTestRoom(t, roomService, "ann", "bob"))
+
+	ann := connect(t, room, "ann")
+	stuck := connect(t, room, "bob")
+
+	for i := 0; i < 2*sendBufferSize; i++ {
+		chat(t, ann, "hello", ann)
+	}
+
+	// Nobody says anything once the client caught up, the room resyncs it on its own
+	for len(stuck.send) > 0 {
+		<-stuck.send
+	}
+
+	if state := next(t, stuck); state.Action != RoomStateAction {
+		t.Errorf("bob got %v after catching up, want %v", state.Action, RoomStateAction)
+	}
+
+	if metrics, _ := clientMetrics(t, hub, room.Uri, "bob"); metrics.Lagging || metrics.Resyncs != 1 {
+		t.Errorf("bob is lagging %v after %v resyncs, want caught up after 1", metrics.Lagging, metrics.Resyncs)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
+	"go-pokerchips/models"
 	"log"
 	"net/http"
 	"time"
@@ -50,16 +51,29 @@ type Client struct {
 	send chan []byte
 
 	room *Room
+
+	// Version of the protocol negotiated on connect
+	protocol int
+
+	// Whether messages are being dropped until the client catches up, see Room.sendTo
+	lagging bool
+
+	// Queue metrics, only touched by the room goroutine
+	sent      int64
+	dropped   int64
+	resyncs   int64
+	highWater int
 }
 
-func newClient(conn *websocket.Conn, hub *Hub, room *Room, name string) *Client {
+func newClient(conn *websocket.Conn, hub *Hub, room *Room, name string, protocol int) *Client {
 
 	return &Client{
-		conn: conn,
-		hub:  hub,
-		room: room,
-		name: name,
-		send: make(chan []byte, 256),
+		conn:     conn,
+		hub:      hub,
+		room:     room,
+		name:     name,
+		send:     make(chan []byte, sendBufferSize),
+		protocol: protocol,
 	}
 }
 
@@ -139,94 +153,146 @@ func (client *Client) readPump() {
 	}
 }
 
-func ServeWS(hub *Hub, room *Room, name string, c *gin.Context) {
+// ServeWS connects a player to the stored room, loading it in the hub when nobody is playing in it.
+func ServeWS(hub *Hub, dbRoom *models.DBRoom, name string, c *gin.Context) {
+
+	subprotocol, protocol, err := negotiateProtocol(c.Request)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"status": "fail", "message": err.Error()})
+		return
+	}
+
+	header := http.Header{}
+	if subprotocol != "" {
+		header.Set("Sec-WebSocket-Protocol", subprotocol)
+	}
 
 	// Upgrade the HTTP server connection to the websocket
-	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	conn, err := upgrader.Upgrade(c.Writer, c.Request, header)
 	if err != nil {
 		log.Println(err)
 		return
 	}
 
-	client := newClient(conn, hub, room, name)
+	room := hub.GetOrCreateRoom(dbRoom)
+	client := newClient(conn, hub, room, name, protocol)
+
+	// The room can shut down between finding it and registering, the player then joins it again once
+	// it is reloaded with the state it saved
+	for !deliver(room, room.register, client) {
+		if dbRoom, err = hub.roomService.FindRoomByUri(dbRoom.Uri); err != nil || dbRoom.Closed {
+			closing := websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "the room was shut down, please reconnect")
+			conn.WriteControl(websocket.CloseMessage, closing, time.Now().Add(writeWait))
+			conn.Close()
+			return
+		}
+		room = hub.GetOrCreateRoom(dbRoom)
+		client.room = room
+	}
 
 	go client.writePump()
 	go client.readPump()
-
-	room.register <- client
 }
 
 func (client *Client) disconnect() {
 
 	fmt.Printf("%v disconnected from the room \n", client.name)
-	client.room.unregister <- client
+	deliver(client.room, client.room.unregister, client)
 	client.conn.Close()
 }
 
 func (client *Client) handleNewMessage(message []byte) {
 
-	fmt.Printf("handleNewMessage from %v: %v \n", client, string(message))
+	fmt.Printf("handleNewMessage from %v: %v \n", client.name, string(message))
 	var msg Message
-	if err := json.Unmarshal(message, &msg); err != nil {
-		log.Printf("Error on unmarshal JSON message %s", err)
+
+	if client.protocol == ProtocolV1 {
+		// Messages of protocol version 1 that cannot be read are only refused when the client waits
+		// for the answer, the ones without a request id were always ignored
+		if err := json.Unmarshal(message, &msg); err != nil {
+			log.Printf("Error on unmarshal JSON message %s", err)
+			if msg.RequestId != "" {
+				deliver(client.room, client.room.actions, &clientAction{client, msg, errInvalidMessage})
+			}
+			return
+		}
+	} else {
+		var err error
+		if msg, err = decodeEnvelope(message); err != nil {
+			deliver(client.room, client.room.actions, &clientAction{client, msg, err})
+			return
+		}
 	}
 
-	switch msg.Action {
-	case SendMessageAction:
-		fmt.Println("SendMessageAction")
-		client.room.broadcast <- &msg
-	//case JoinRoomAction:
-	//	fmt.Println("JoinRoomAction")
-	//	client.room.register <- client
-	case AddPot:
-		client.addPot(msg)
-	case TakePot:
-		client.takePot(msg)
-	case LeaveRoomAction:
-		fmt.Println("LeaveRoomAction")
-		client.room.unregister <- client
+	// Clients send the actions that have a payload type, the others are the server's
+	if _, ok := clientPayloads[msg.Action]; !ok {
+		if msg.RequestId != "" {
+			deliver(client.room, client.room.actions, &clientAction{client, msg, errUnknownAction})
+		}
+		return
 	}
+
+	deliver(client.room, client.room.actions, &clientAction{client, msg, nil})
 }
 
-func (client *Client) addPot(message Message) {
+// addPot runs on the room goroutine, see Room.handleAction.
+func (client *Client) addPot(message Message) error {
 
 	fmt.Printf("%v trying to add pot %v\n", client.name, message.Pot)
 	pot := message.Pot
 
+	// A failed bet changes nothing, so only the sender hears about it
 	updatePotResp, err := client.hub.roomService.AddPot(client.room.Id, client.name, pot)
 	if err != nil {
 		fmt.Println(err)
+		return err
 	}
+
 	message.Message = fmt.Sprintf("%v bet %v.", client.name, pot)
 	message.Action = UpdatePot
+	message.Amount = pot
 	message.Pot = updatePotResp.Pot
 	message.CurrentChips = updatePotResp.CurrentChips
 	message.Sender = client.name
+	client.room.Pot = updatePotResp.Pot
 
-	if err != nil {
-		message.Message = "You do not have enough chips to bet."
-	}
+	// A bet outside of a hand merges the side pots back into one undivided pot
+	client.room.Pots = []models.Pot{}
+	message.Pots = client.room.Pots
 
-	client.room.broadcast <- &message
+	client.room.broadcastClientsInRoom(&message)
+
+	return nil
 }
 
-func (client *Client) takePot(message Message) {
+// takePot runs on the room goroutine, see Room.handleAction.
+func (client *Client) takePot(message Message) error {
 
 	pot := message.Pot
-	fmt.Printf("%v trying to retrive pot with amount : %v \n", client.name, pot)
 
 	updatePotResp, err := client.hub.roomService.TakePot(client.room.Id, client.name, pot)
-	message.Message = fmt.Sprintf("%v take %v.", client.name, pot)
+	if err != nil {
+		return err
+	}
+
+	message.Message = fmt.Sprintf("%v took %v from the pot.", client.name, pot)
 	message.Action = UpdatePot
+	message.Amount = pot
 	message.Pot = updatePotResp.Pot
-
-	fmt.Println(message.Pot)
 	message.CurrentChips = updatePotResp.CurrentChips
 	message.Sender = client.name
+	client.room.Pot = updatePotResp.Pot
 
-	if err != nil {
-		message.Message = "You do not have enough pot to retrieve."
-	}
+	// Like a bet, taking chips leaves a single undivided pot
+	client.room.Pots = []models.Pot{}
+	message.Pots = client.room.Pots
+
+	client.room.broadcastClientsInRoom(&message)
+
+	return nil
+}
 
-	client.room.broadcast <- &message
+// sendMessage sends a message to this client only, encoded for its protocol version.
+func (client *Client) sendMessage(message *Message) {
+	client.room.sendTo(client, message.encodeFor(client.protocol))
 }
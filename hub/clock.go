@@ -0,0 +1,171 @@
+package hub
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+// Seconds left on the action clock when the player to act is warned, if the clock is any longer
+const actionWarningSeconds = 10
+
+var (
+	errNoActionClock = newError(CodeInvalidState, "this room has no action clock")
+	errNoTimeBank    = newError(CodeNotAllowed, "your time bank is used up")
+)
+
+// ActionClock is how long the player to act has left before the server checks or folds for them.
+type ActionClock struct {
+	Player  string    `json:"player"`
+	Seconds int       `json:"seconds"`
+	EndsAt  time.Time `json:"endsAt"`
+
+	// Seconds left in the time bank of the player
+	TimeBank int `json:"timeBank"`
+}
+
+// actionClock is the turn being timed, only known to the room goroutine.
+type actionClock struct {
+	player string
+	endsAt time.Time
+	warned bool
+}
+
+// startClock gives the player to act the action time of the room and tells the room.
+// It runs after every move on the owner of the room, the other instances adopt the clock it starts.
+func (room *Room) startClock() {
+
+	room.clock = nil
+
+	if room.Settings.ActionSeconds == 0 || !room.handInProgress() {
+		return
+	}
+
+	player := room.hand.toAct()
+	room.clock = &actionClock{
+		player: player,
+		endsAt: time.Now().Add(time.Duration(room.Settings.ActionSeconds) * time.Second),
+	}
+
+	room.broadcastClientsInRoom(room.clockMessage("", fmt.Sprintf("%v has %v seconds to act.", player, room.Settings.ActionSeconds)))
+}
+
+// extendClock adds time from the time bank of the player to act to their clock.
+// Each request draws up to the action time of the room.
+func (room *Room) extendClock(client *Client) error {
+
+	if room.Settings.ActionSeconds == 0 {
+		return errNoActionClock
+	}
+
+	if room.clock == nil || room.clock.player != client.name {
+		return errNotYourTurn
+	}
+
+	bank := room.timeBank(client.name)
+	if bank == 0 {
+		return errNoTimeBank
+	}
+
+	seconds := minInt(bank, room.Settings.ActionSeconds)
+	room.timeBanks[client.name] = bank - seconds
+	room.clock.endsAt = room.clock.endsAt.Add(time.Duration(seconds) * time.Second)
+	room.clock.warned = false
+
+	text := fmt.Sprintf("%v used %v seconds of their time bank.", client.name, seconds)
+	room.broadcastClientsInRoom(room.clockMessage(client.name, text))
+
+	return nil
+}
+
+// adoptClock takes over the clock the owner of the room started or extended, so it is in the snapshots
+// of this instance and carries on should this instance take the room over.
+func (room *Room) adoptClock(clock *ActionClock) {
+
+	room.timeBanks[clock.Player] = clock.TimeBank
+
+	if room.clock == nil || room.clock.player != clock.Player || !room.clock.endsAt.Equal(clock.EndsAt) {
+		room.clock = &actionClock{player: clock.Player, endsAt: clock.EndsAt}
+	}
+}
+
+// tickClock runs every second on the room goroutine. On the owner of the room it warns the player to
+// act when their time is nearly up and acts for them once it is.
+func (room *Room) tickClock() {
+
+	clock := room.clock
+	if clock == nil {
+		return
+	}
+
+	if !room.handInProgress() || room.hand.toAct() != clock.player {
+		room.clock = nil
+		return
+	}
+
+	if !room.isOwner() {
+		return
+	}
+
+	if left := room.clockState().Seconds; left > 0 {
+		if left <= actionWarningSeconds && room.Settings.ActionSeconds > actionWarningSeconds && !clock.warned {
+			clock.warned = true
+			room.broadcastClientsInRoom(room.clockMessage("", fmt.Sprintf("%v has %v seconds left to act.", clock.player, left)))
+		}
+		return
+	}
+
+	room.clock = nil
+
+	action := FoldAction
+	if room.hand.canCheck(clock.player) {
+		action = CheckAction
+	}
+
+	log.Printf("%v ran out of time in room %v, the server will %v for them\n", clock.player, room.Uri, action)
+
+	note := fmt.Sprintf("%v ran out of time.", clock.player)
+	if err := room.playHand(clock.player, Message{Action: action}, note); err != nil {
+		log.Printf("Could not %v for %v in room %v: %v\n", action, clock.player, room.Uri, err)
+	}
+}
+
+// timeBank returns the seconds left in the time bank of a player, which is full until they first use it.
+func (room *Room) timeBank(name string) int {
+
+	if bank, ok := room.timeBanks[name]; ok {
+		return bank
+	}
+
+	return room.Settings.TimeBankSeconds
+}
+
+// clockState is the turn being timed as sent to the clients, nil when there is none.
+func (room *Room) clockState() *ActionClock {
+
+	if room.clock == nil {
+		return nil
+	}
+
+	seconds := int(time.Until(room.clock.endsAt).Round(time.Second).Seconds())
+	if seconds < 0 {
+		seconds = 0
+	}
+
+	return &ActionClock{
+		Player:   room.clock.player,
+		Seconds:  seconds,
+		EndsAt:   room.clock.endsAt,
+		TimeBank: room.timeBank(room.clock.player),
+	}
+}
+
+func (room *Room) clockMessage(sender string, text string) *Message {
+	return &Message{
+		Action:  ActionClockAction,
+		Message: text,
+		Pot:     room.Pot,
+		Sender:  sender,
+		Clock:   room.clockState(),
+	}
+}
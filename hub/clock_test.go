@@ -0,0 +1,151 @@
+package hub
+
+import (
+	"go-pokerchips/models"
+	"go-pokerchips/services"
+	"testing"
+	"time"
+)
+
+// newTestClockRoom loads a room with an action clock that is not run, the test calls it from its own
+// goroutine. The players are connected so they are dealt in.
+func newTestClockRoom(t *testing.T, settings models.RoomSettings, players ...string) (*Room, map[string]*Client) {
+
+	t.Helper()
+
+	roomService := services.NewMemoryRoomService()
+	dbRoom, err := roomService.CreateRoom(&models.CreateRoomInput{Creator: players[0], Settings: settings})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range players[1:] {
+		if err = roomService.RegisterUserInRoom(dbRoom.Id.Hex(), name, "", ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if dbRoom, err = roomService.FindRoomByUri(dbRoom.Uri); err != nil {
+		t.Fatal(err)
+	}
+
+	room := NewRoom(NewHub(roomService, nil, time.Minute, SlowClientResync), dbRoom)
+	clients := make(map[string]*Client, len(players))
+	for _, name := range players {
+		clients[name] = newClient(nil, room.hub, room, name, ProtocolV1)
+		room.clients[clients[name]] = true
+	}
+
+	return room, clients
+}
+
+func TestActionClockActsForThePlayer(t *testing.T) {
+
+	room, clients := newTestClockRoom(t, models.RoomSettings{
+		Blinds:        models.Blinds{SmallBlind: 5, BigBlind: 10},
+		ActionSeconds: 30,
+	}, "ann", "bob", "cat")
+
+	if err := room.startHand(clients["ann"]); err != nil {
+		t.Fatal(err)
+	}
+
+	// Ann is first to act, facing the big blind of cat
+	clock := room.clockState()
+	if clock == nil || clock.Player != "ann" || clock.Seconds != 30 {
+		t.Fatalf("got clock %+v, want 30 seconds for ann", clock)
+	}
+
+	// Warned once when the time is nearly up, nothing is played for her yet
+	room.clock.endsAt = time.Now().Add(actionWarningSeconds * time.Second)
+	room.tickClock()
+	if !room.clock.warned || room.hand.toAct() != "ann" {
+		t.Errorf("warned %v with %v to act, want ann warned", room.clock.warned, room.hand.toAct())
+	}
+	if message := expect(t, clients["bob"], ActionClockAction); message.Clock == nil || message.Clock.Player != "ann" {
+		t.Errorf("got %+v, want the clock of ann", message.Clock)
+	}
+
+	// There is a bet to call, so she folds
+	room.clock.endsAt = time.Now().Add(-time.Second)
+	room.tickClock()
+	if !room.hand.folded["ann"] {
+		t.Error("ann did not fold when her time ran out")
+	}
+	if clock = room.clockState(); clock == nil || clock.Player != "bob" {
+		t.Fatalf("got clock %+v after the fold, want bob on the clock", clock)
+	}
+
+	if err := room.playHand("bob", Message{Action: CallAction}, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	// The big blind has nothing to call, so cat checks and the flop is dealt
+	if clock = room.clockState(); clock == nil || clock.Player != "cat" {
+		t.Fatalf("got clock %+v after the call, want cat on the clock", clock)
+	}
+	room.clock.endsAt = time.Now().Add(-time.Second)
+	room.tickClock()
+	if room.hand.folded["cat"] || room.hand.street != 1 {
+		t.Errorf("cat folded %v and the hand is on street %v, want a check to the flop", room.hand.folded["cat"], room.hand.street)
+	}
+}
+
+func TestTimeBank(t *testing.T) {
+
+	room, clients := newTestClockRoom(t, models.RoomSettings{ActionSeconds: 30, TimeBankSeconds: 45}, "ann", "bob")
+
+	if err := room.extendClock(clients["ann"]); err != errNotYourTurn {
+		t.Fatalf("time bank without a hand got error %v, want %v", err, errNotYourTurn)
+	}
+
+	if err := room.startHand(clients["ann"]); err != nil {
+		t.Fatal(err)
+	}
+	player := room.hand.toAct()
+	other := "ann"
+	if player == "ann" {
+		other = "bob"
+	}
+	endsAt := room.clock.endsAt
+
+	steps := []struct {
+		name   string
+		client *Client
+		err    error
+		added  time.Duration
+		bank   int
+	}{
+		{"not their turn", clients[other], errNotYourTurn, 0, 45},
+		{"first extension draws the action time", clients[player], nil, 30 * time.Second, 15},
+		{"second extension draws the rest", clients[player], nil, 45 * time.Second, 0},
+		{"used up", clients[player], errNoTimeBank, 45 * time.Second, 0},
+	}
+
+	for _, step := range steps {
+		if err := room.extendClock(step.client); err != step.err {
+			t.Fatalf("%v: got error %v, want %v", step.name, err, step.err)
+		}
+		if added := room.clock.endsAt.Sub(endsAt); added != step.added {
+			t.Errorf("%v: clock extended by %v, want %v", step.name, added, step.added)
+		}
+		if bank := room.timeBank(player); bank != step.bank {
+			t.Errorf("%v: %v seconds left in the time bank, want %v", step.name, bank, step.bank)
+		}
+	}
+
+	// The extension is sent to everybody with what is left in the bank
+	if message := expect(t, clients[other], ActionClockAction); message.Sender != "" {
+		t.Errorf("got clock message from %q, want the start of the clock first", message.Sender)
+	}
+	if message := expect(t, clients[other], ActionClockAction); message.Sender != player || message.Clock.TimeBank != 15 {
+		t.Errorf("got clock of %v with %v seconds in the bank, want %v with 15", message.Sender, message.Clock.TimeBank, player)
+	}
+
+	// Rooms without a clock have no time bank either
+	room, clients = newTestClockRoom(t, models.RoomSettings{TimeBankSeconds: 45}, "ann", "bob")
+	if err := room.startHand(clients["ann"]); err != nil {
+		t.Fatal(err)
+	}
+	if err := room.extendClock(clients[room.hand.toAct()]); err != errNoActionClock {
+		t.Errorf("time bank without an action clock got error %v, want %v", err, errNoActionClock)
+	}
+}
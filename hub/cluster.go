@@ -0,0 +1,318 @@
+package hub
+
+import (
+	"encoding/json"
+	"go-pokerchips/models"
+	"log"
+	"time"
+)
+
+// Every instance tells the others which players are connected to it this often while it has any,
+// and forgets the players of an instance it has not heard of for presenceTimeout.
+const (
+	presenceInterval = 10 * time.Second
+	presenceTimeout  = 3 * presenceInterval
+)
+
+// remoteEvent is a broadcast published for the other instances that have the room loaded. It carries
+// the room as it was after the broadcast, which they take over when it comes from the owner of the room.
+// An event without a message only refreshes the players online on the instance, or carries a request
+// forwarded to the owner or its reply, see owner.go.
+type remoteEvent struct {
+	Instance string      `json:"instance"`
+	Owner    bool        `json:"owner"`
+	Online   []string    `json:"online"`
+	Message  *Message    `json:"message,omitempty"`
+	Room     *roomRecord `json:"room,omitempty"`
+
+	// The host command broadcast, for the instances to drop kicked players or close the room
+	Command string `json:"command,omitempty"`
+
+	Request *forwardedRequest `json:"request,omitempty"`
+	Reply   *forwardedReply   `json:"reply,omitempty"`
+}
+
+// roomRecord is the part of the room only known to the room goroutine.
+type roomRecord struct {
+	Pot        int                `json:"pot"`
+	Pots       []models.Pot       `json:"pots"`
+	Blinds     models.Blinds      `json:"blinds"`
+	Dealer     string             `json:"dealer"`
+	Host       string             `json:"host"`
+	Locked     bool               `json:"locked"`
+	Tournament *models.Tournament `json:"tournament,omitempty"`
+
+	// Only sent when the hand changed, the others keep theirs
+	Hand       *handRecord    `json:"hand,omitempty"`
+	HandStacks map[string]int `json:"handStacks,omitempty"`
+}
+
+// handRecord is a Hand with everything needed to carry on betting where it was left.
+type handRecord struct {
+	Players    []string        `json:"players"`
+	Street     int             `json:"street"`
+	Stacks     map[string]int  `json:"stacks"`
+	StreetBets map[string]int  `json:"streetBets"`
+	HandBets   map[string]int  `json:"handBets"`
+	Folded     map[string]bool `json:"folded"`
+	AllIn      map[string]bool `json:"allIn"`
+	Acted      map[string]bool `json:"acted"`
+	CurrentBet int             `json:"currentBet"`
+	MinRaise   int             `json:"minRaise"`
+	MinBet     int             `json:"minBet"`
+	Turn       int             `json:"turn"`
+	Carry      int             `json:"carry"`
+	Blinds     models.Blinds   `json:"blinds"`
+	Dealer     int             `json:"dealer"`
+	SmallBlind int             `json:"smallBlind"`
+	BigBlind   int             `json:"bigBlind"`
+}
+
+// remotePresence is who is connected to another instance, as last heard.
+type remotePresence struct {
+	names []string
+	seen  time.Time
+}
+
+func (hand *Hand) record() *handRecord {
+	return &handRecord{
+		Players:    hand.players,
+		Street:     hand.street,
+		Stacks:     hand.stacks,
+		StreetBets: hand.streetBets,
+		HandBets:   hand.handBets,
+		Folded:     hand.folded,
+		AllIn:      hand.allIn,
+		Acted:      hand.acted,
+		CurrentBet: hand.currentBet,
+		MinRaise:   hand.minRaise,
+		MinBet:     hand.minBet,
+		Turn:       hand.turn,
+		Carry:      hand.carry,
+		Blinds:     hand.blinds,
+		Dealer:     hand.dealer,
+		SmallBlind: hand.smallBlind,
+		BigBlind:   hand.bigBlind,
+	}
+}
+
+func (record *handRecord) hand() *Hand {
+
+	hand := &Hand{
+		players:    record.Players,
+		street:     record.Street,
+		stacks:     record.Stacks,
+		streetBets: record.StreetBets,
+		handBets:   record.HandBets,
+		folded:     record.Folded,
+		allIn:      record.AllIn,
+		acted:      record.Acted,
+		currentBet: record.CurrentBet,
+		minRaise:   record.MinRaise,
+		minBet:     record.MinBet,
+		turn:       record.Turn,
+		carry:      record.Carry,
+		blinds:     record.Blinds,
+		dealer:     record.Dealer,
+		smallBlind: record.SmallBlind,
+		bigBlind:   record.BigBlind,
+	}
+
+	// Empty maps are left out of the JSON
+	for _, m := range []*map[string]int{&hand.stacks, &hand.streetBets, &hand.handBets} {
+		if *m == nil {
+			*m = make(map[string]int)
+		}
+	}
+	for _, m := range []*map[string]bool{&hand.folded, &hand.allIn, &hand.acted} {
+		if *m == nil {
+			*m = make(map[string]bool)
+		}
+	}
+
+	return hand
+}
+
+func (room *Room) channel() string {
+	return "pokerchips:room:" + room.Uri
+}
+
+// subscribe forwards the events other instances publish for the room to the room goroutine.
+func (room *Room) subscribe() {
+
+	if room.hub.pubsub == nil {
+		return
+	}
+
+	subscription, err := room.hub.pubsub.Subscribe(room.channel())
+	if err != nil {
+		log.Printf("Could not subscribe to room %v, players on other instances will not be seen: %v\n", room.Uri, err)
+		return
+	}
+	room.subscription = subscription
+
+	go func() {
+		for data := range subscription.Messages() {
+			var event *remoteEvent
+			if err := json.Unmarshal(data, &event); err != nil || event == nil {
+				log.Printf("Could not read an event of room %v: %v\n", room.Uri, err)
+				continue
+			}
+
+			// Keep reading after the room stopped, until the subscription is closed
+			deliver(room, room.remote, event)
+		}
+	}()
+}
+
+func (room *Room) unsubscribe() {
+
+	if room.subscription == nil {
+		return
+	}
+
+	if err := room.subscription.Close(); err != nil {
+		log.Printf("Could not unsubscribe from room %v: %v\n", room.Uri, err)
+	}
+}
+
+// publish shares a broadcast with the other instances, along with the room as it is now.
+func (room *Room) publish(event *remoteEvent) {
+
+	if room.hub.pubsub == nil {
+		return
+	}
+
+	event.Instance = room.hub.instance
+	event.Owner = room.isOwner()
+	event.Online = room.localPlayers()
+
+	if event.Message != nil {
+		event.Room = &roomRecord{
+			Pot:        room.Pot,
+			Pots:       room.Pots,
+			Blinds:     room.Blinds,
+			Dealer:     room.Dealer,
+			Host:       room.Host,
+			Locked:     room.Locked,
+			Tournament: room.Tournament,
+		}
+
+		if room.hand != nil && (event.Message.Action == UpdateHandAction || event.Message.Action == PotAwardedAction) {
+			event.Room.Hand = room.hand.record()
+			event.Room.HandStacks = room.handStacks
+		}
+	}
+
+	data, err := json.Marshal(event)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	if err = room.hub.pubsub.Publish(room.channel(), data); err != nil {
+		log.Printf("Could not publish an event of room %v: %v\n", room.Uri, err)
+	}
+}
+
+// applyRemote takes over what the owner of the room did to it and tells the players connected here.
+// The broadcasts of the other instances are only passed on to the players.
+func (room *Room) applyRemote(event *remoteEvent) {
+
+	if event.Instance == room.hub.instance {
+		return
+	}
+
+	room.presence[event.Instance] = remotePresence{event.Online, time.Now()}
+
+	if event.Request != nil {
+		room.handleForwarded(event.Instance, event.Request)
+	}
+	if event.Reply != nil {
+		room.handleReply(event.Reply)
+	}
+
+	if event.Message == nil {
+		return
+	}
+
+	if record := event.Room; record != nil && event.Owner {
+		room.Pot = record.Pot
+		room.Pots = record.Pots
+		room.Blinds = record.Blinds
+		room.Dealer = record.Dealer
+		room.Host = record.Host
+		room.Locked = record.Locked
+
+		if record.Tournament != nil {
+			room.Tournament = record.Tournament
+			room.levelEndsAt = time.Now().Add(time.Duration(record.Tournament.Remaining) * time.Second)
+		}
+
+		if record.Hand != nil {
+			room.hand = record.Hand.hand()
+			room.handStacks = record.HandStacks
+		}
+
+		if event.Message.Action == ActionClockAction && event.Message.Clock != nil {
+			room.adoptClock(event.Message.Clock)
+		}
+	}
+
+	room.broadcastLocal(event.Message)
+
+	switch event.Command {
+	case KickAction:
+		for client := range room.clients {
+			if client.name == event.Message.Name {
+				room.dropClient(client)
+			}
+		}
+	case CloseRoomAction:
+		room.stop()
+	}
+
+	// A player connected to another instance may have left on their turn
+	if event.Message.Action == UpdateSeatsAction {
+		room.skipAbsent()
+	}
+}
+
+// announcePresence tells the other instances who is connected here, see presenceInterval.
+func (room *Room) announcePresence() {
+
+	if len(room.clients) == 0 || time.Since(room.presenceSent) < presenceInterval {
+		return
+	}
+
+	room.presenceSent = time.Now()
+	room.publish(&remoteEvent{})
+}
+
+// localPlayers are the names of the players connected to this instance.
+func (room *Room) localPlayers() []string {
+
+	names := make([]string, 0, len(room.clients))
+	for client := range room.clients {
+		if indexOf(names, client.name) < 0 {
+			names = append(names, client.name)
+		}
+	}
+
+	return names
+}
+
+func (room *Room) isOnlineElsewhere(name string) bool {
+
+	for instance, presence := range room.presence {
+		if time.Since(presence.seen) > presenceTimeout {
+			delete(room.presence, instance)
+			continue
+		}
+		if indexOf(presence.names, name) >= 0 {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,167 @@
+package hub
+
+import (
+	"go-pokerchips/models"
+	"go-pokerchips/services"
+	"testing"
+	"time"
+)
+
+// createClusterRoom loads a room with 5/10 blinds on two hubs sharing the room service and an in-process
+// pub/sub, ann connected to the first one and bob to the second. The first hub to load the room owns it.
+func createClusterRoom(t *testing.T) (services.RoomService, *Hub, *Hub, *models.DBRoom, *Client, *Client) {
+
+	t.Helper()
+
+	roomService := services.NewMemoryRoomService()
+	pubsub := NewMemoryPubSub()
+	owner := newTestHub(t, roomService, pubsub, time.Minute, SlowClientResync)
+	other := newTestHub(t, roomService, pubsub, time.Minute, SlowClientResync)
+
+	dbRoom := createTestRoom(t, roomService, "ann", "bob")
+	if err := roomService.UpdateBlinds(dbRoom.Id.Hex(), models.Blinds{SmallBlind: 5, BigBlind: 10}); err != nil {
+		t.Fatal(err)
+	}
+
+	ann := connect(t, owner.GetOrCreateRoom(dbRoom), "ann")
+	bob := connect(t, other.GetOrCreateRoom(dbRoom), "bob")
+
+	// The owner knows bob is online once it heard bob joined
+	for expect(t, ann, SendMessageAction).Message != "> bob joined the room." {
+	}
+
+	return roomService, owner, other, dbRoom, ann, bob
+}
+
+// replyTo reads the messages sent to the client up to the reply to the request, and returns the
+// reply along with the hand updates that came before it.
+func replyTo(t *testing.T, client *Client, requestId string) (Message, []Message) {
+
+	t.Helper()
+
+	var hands []Message
+	for {
+		message := next(t, client)
+		switch {
+		case message.Action == UpdateHandAction:
+			hands = append(hands, message)
+		case (message.Action == AckAction || message.Action == NackAction) && message.RequestId == requestId:
+			return message, hands
+		}
+	}
+}
+
+func potOf(t *testing.T, roomService services.RoomService, uri string) int {
+
+	t.Helper()
+
+	dbRoom, err := roomService.FindRoomByUri(uri)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return dbRoom.Pot
+}
+
+func TestOwnerPlaysEveryHandOnce(t *testing.T) {
+
+	roomService, _, _, dbRoom, ann, bob := createClusterRoom(t)
+
+	// Both instances get a start-hand at the same time, only one hand may be dealt
+	go send(ann, Message{Action: StartHandAction, RequestId: "ann-start"})
+	go send(bob, Message{Action: StartHandAction, RequestId: "bob-start"})
+
+	annReply, annHands := replyTo(t, ann, "ann-start")
+	bobReply, bobHands := replyTo(t, bob, "bob-start")
+
+	if (annReply.Action == AckAction) == (bobReply.Action == AckAction) {
+		t.Fatalf("ann got %v and bob got %v, want a single hand", annReply.Action, bobReply.Action)
+	}
+
+	if len(annHands) != 1 || len(bobHands) != 1 {
+		t.Fatalf("ann saw %v hands dealt and bob %v, want 1", len(annHands), len(bobHands))
+	}
+
+	if pot := potOf(t, roomService, dbRoom.Uri); pot != 15 {
+		t.Errorf("the pot is %v, want the blinds posted once for 15", pot)
+	}
+
+	annHand, bobHand := annHands[0].Hand, bobHands[0].Hand
+	if annHand.Turn != "ann" || bobHand.Turn != "ann" {
+		t.Fatalf("ann sees %v to act and bob sees %v, want ann on the button", annHand.Turn, bobHand.Turn)
+	}
+
+	send(ann, Message{Action: CallAction, RequestId: "ann-call"})
+	if reply, _ := replyTo(t, ann, "ann-call"); reply.Action != AckAction {
+		t.Fatalf("ann could not call: %v", reply.Message)
+	}
+
+	// Bob plays through the owner on the other instance
+	send(bob, Message{Action: CheckAction, RequestId: "bob-check"})
+	reply, hands := replyTo(t, bob, "bob-check")
+	if reply.Action != AckAction {
+		t.Fatalf("bob could not check: %v", reply.Message)
+	}
+
+	if last := hands[len(hands)-1].Hand; last.Street != StreetFlop || last.Turn != "bob" {
+		t.Errorf("bob sees the %v with %v to act, want the flop with bob to act", last.Street, last.Turn)
+	}
+
+	if pot := potOf(t, roomService, dbRoom.Uri); pot != 20 {
+		t.Errorf("the pot is %v, want 20", pot)
+	}
+
+	// A retry is answered by the owner without being applied again
+	send(bob, Message{Action: CheckAction, RequestId: "bob-check"})
+	if retry, _ := replyTo(t, bob, "bob-check"); retry.Action != AckAction {
+		t.Errorf("the retry got %v, want the ack of the first attempt", retry.Action)
+	}
+
+	send(bob, Message{Action: CheckAction, RequestId: "bob-check-flop"})
+	if reply, _ := replyTo(t, bob, "bob-check-flop"); reply.Action != AckAction {
+		t.Errorf("bob could not check on the flop: %v", reply.Message)
+	}
+}
+
+func TestOwnerAppliesHostCommands(t *testing.T) {
+
+	roomService, _, other, dbRoom, _, _ := createClusterRoom(t)
+
+	if err := other.Moderate(dbRoom.Uri, "bob", &models.ModerateRoomInput{Action: LockRoomAction}); err == nil {
+		t.Error("bob locked the room without being the host")
+	}
+
+	if err := other.Moderate(dbRoom.Uri, "ann", &models.ModerateRoomInput{Action: LockRoomAction}); err != nil {
+		t.Fatal(err)
+	}
+
+	dbRoom, err := roomService.FindRoomByUri(dbRoom.Uri)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !dbRoom.Locked {
+		t.Error("the room is not locked")
+	}
+}
+
+func TestOwnerHandsTheRoomOver(t *testing.T) {
+
+	roomService, owner, _, dbRoom, ann, bob := createClusterRoom(t)
+
+	owner.Shutdown()
+	if !isClosed(ann.send) {
+		t.Fatal("ann is still connected to the instance that shut down")
+	}
+
+	// The other instance takes the lease on its next tick
+	time.Sleep(2 * time.Second)
+
+	send(bob, Message{Action: AddPot, Pot: 10, RequestId: "bob-add-pot"})
+	if reply, _ := replyTo(t, bob, "bob-add-pot"); reply.Action != AckAction {
+		t.Fatalf("bob could not bet: %v", reply.Message)
+	}
+
+	if pot := potOf(t, roomService, dbRoom.Uri); pot != 10 {
+		t.Errorf("pot is %v, want 10", pot)
+	}
+}
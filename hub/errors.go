@@ -0,0 +1,58 @@
+package hub
+
+import (
+	"errors"
+	"go-pokerchips/services"
+)
+
+// Codes of error messages, so clients can react to an error without parsing its text.
+const (
+	CodeInsufficientChips = "insufficient_chips"
+	CodeNotYourTurn       = "not_your_turn"
+	CodeInvalidAmount     = "invalid_amount"
+	CodeNotAllowed        = "not_allowed"
+	CodeInvalidState      = "invalid_state"
+	CodeInvalidRequest    = "invalid_request"
+	CodeInternal          = "internal_error"
+)
+
+// codedError is an error of the hub that carries the code sent to the client.
+type codedError struct {
+	code string
+	text string
+}
+
+func newError(code string, text string) error {
+	return &codedError{code, text}
+}
+
+func (err *codedError) Error() string {
+	return err.text
+}
+
+// errorCode returns the code of an error of the hub or of the room service.
+// Errors nobody expected, like a database that is down, are internal errors.
+func errorCode(err error) string {
+
+	var coded *codedError
+	if errors.As(err, &coded) {
+		return coded.code
+	}
+
+	switch err {
+	case services.ErrNotEnoughChips, services.ErrNotEnoughPot:
+		return CodeInsufficientChips
+	case services.ErrInvalidAmount:
+		return CodeInvalidAmount
+	case services.ErrNotHost, services.ErrUserNotRegistered, services.ErrUserKicked, services.ErrRoomClosed,
+		services.ErrKickHost, services.ErrNotEligible, services.ErrSeatTaken:
+		return CodeNotAllowed
+	case services.ErrNotSeated, services.ErrSittingOut, services.ErrNotSittingOut:
+		return CodeInvalidState
+	case services.ErrInvalidBlinds, services.ErrInvalidUserName, services.ErrPotNotFound, services.ErrNoWinners,
+		services.ErrReasonRequired, services.ErrPotsMismatch, services.ErrInvalidSeat:
+		return CodeInvalidRequest
+	}
+
+	return CodeInternal
+}
@@ -0,0 +1,64 @@
+package hub
+
+import (
+	"errors"
+	"fmt"
+	"go-pokerchips/services"
+	"testing"
+	"time"
+)
+
+func TestErrorCode(t *testing.T) {
+
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{errNotYourTurn, CodeNotYourTurn},
+		{errHandInProgress, CodeInvalidState},
+		{errTakePotNotHost, CodeNotAllowed},
+		{errUnknownCommand, CodeInvalidRequest},
+		{fmt.Errorf("hand: %w", errNotYourTurn), CodeNotYourTurn},
+		{services.ErrNotEnoughChips, CodeInsufficientChips},
+		{services.ErrNotEnoughPot, CodeInsufficientChips},
+		{services.ErrInvalidAmount, CodeInvalidAmount},
+		{services.ErrNotHost, CodeNotAllowed},
+		{services.ErrUserKicked, CodeNotAllowed},
+		{services.ErrRoomClosed, CodeNotAllowed},
+		{services.ErrSeatTaken, CodeNotAllowed},
+		{services.ErrNotSeated, CodeInvalidState},
+		{services.ErrSittingOut, CodeInvalidState},
+		{services.ErrInvalidBlinds, CodeInvalidRequest},
+		{services.ErrPotNotFound, CodeInvalidRequest},
+		{services.ErrReasonRequired, CodeInvalidRequest},
+		{services.ErrInvalidSeat, CodeInvalidRequest},
+		{errors.New("connection refused"), CodeInternal},
+	}
+
+	for _, test := range tests {
+		if code := errorCode(test.err); code != test.want {
+			t.Errorf("errorCode(%q) = %v, want %v", test.err, code, test.want)
+		}
+	}
+}
+
+func TestErrorsCarryTheirCode(t *testing.T) {
+
+	roomService := services.NewMemoryRoomService()
+	hub := newTestHub(t, roomService, nil, time.Minute, SlowClientResync)
+	dbRoom := createTestRoom(t, roomService, "ann", "bob")
+	room := hub.GetOrCreateRoom(dbRoom)
+
+	ann := connect(t, room, "ann")
+
+	// Refused with a nack when the message has a request id, with an error when it has none
+	send(ann, Message{Action: AddPot, Pot: 5000, RequestId: "bet"})
+	if nack := expect(t, ann, NackAction); nack.Code != CodeInsufficientChips || nack.RequestId != "bet" {
+		t.Errorf("got nack %q with code %v, want %v", nack.RequestId, nack.Code, CodeInsufficientChips)
+	}
+
+	send(ann, Message{Action: AddPot, Pot: -5})
+	if message := expect(t, ann, ErrorAction); message.Code != CodeInvalidAmount {
+		t.Errorf("got error %q with code %v, want %v", message.Message, message.Code, CodeInvalidAmount)
+	}
+}
@@ -0,0 +1,569 @@
+package hub
+
+import (
+	"fmt"
+	"go-pokerchips/models"
+	"sort"
+)
+
+const (
+	StreetPreflop  = "preflop"
+	StreetFlop     = "flop"
+	StreetTurn     = "turn"
+	StreetRiver    = "river"
+	StreetShowdown = "showdown"
+)
+
+var streets = []string{StreetPreflop, StreetFlop, StreetTurn, StreetRiver, StreetShowdown}
+
+// Forced bets posted by the room at the start of a hand
+const (
+	postAnte       = "ante"
+	postSmallBlind = "small-blind"
+	postBigBlind   = "big-blind"
+)
+
+var (
+	errNoHand         = newError(CodeInvalidState, "there is no hand in progress")
+	errHandInProgress = newError(CodeInvalidState, "a hand is already in progress")
+	errNotInHand      = newError(CodeNotAllowed, "you are not playing this hand")
+	errNotYourTurn    = newError(CodeNotYourTurn, "it is not your turn")
+	errCannotCheck    = newError(CodeInvalidState, "you cannot check, there is a bet to call")
+	errNothingToCall  = newError(CodeInvalidState, "there is nothing to call, check instead")
+	errNoChips        = newError(CodeInsufficientChips, "you have no chips left")
+	errNotEnoughChips = newError(CodeInsufficientChips, "you do not have enough chips")
+	errNotReopened    = newError(CodeNotAllowed, "the all-in was not a full raise, you can only call or fold")
+	errUnknownAction  = newError(CodeInvalidRequest, "unknown action")
+)
+
+// Hand tracks the betting of a single hand. It is only touched from the room goroutine.
+type Hand struct {
+	// Players dealt into the hand, in turn order
+	players []string
+
+	street int
+
+	// Chips each player has behind
+	stacks map[string]int
+
+	// Chips each player put in during the current street and during the whole hand
+	streetBets map[string]int
+	handBets   map[string]int
+
+	folded map[string]bool
+	allIn  map[string]bool
+
+	// Players who acted since the last full bet or raise. An all-in short of a full raise does not
+	// reopen the betting to them, they can only call or fold.
+	acted map[string]bool
+
+	// The amount every player has to match on this street
+	currentBet int
+
+	// The smallest allowed raise increment, the size of the last full bet or raise
+	minRaise int
+
+	// The minimum opening bet on each street
+	minBet int
+
+	// Index in players of the player to act
+	turn int
+
+	// Chips already in the pot when the hand started, they go to the main pot
+	carry int
+
+	// Forced bets and the index in players of the dealer and the blinds
+	blinds     models.Blinds
+	dealer     int
+	smallBlind int
+	bigBlind   int
+}
+
+// HandState is the public view of a hand broadcast to the room.
+type HandState struct {
+	Street        string         `json:"street"`
+	Players       []string       `json:"players"`
+	Turn          string         `json:"turn,omitempty"`
+	Dealer        string         `json:"dealer"`
+	SmallBlind    string         `json:"smallBlind,omitempty"`
+	BigBlind      string         `json:"bigBlind,omitempty"`
+	CurrentBet    int            `json:"currentBet"`
+	MinRaise      int            `json:"minRaise"`
+	Contributions map[string]int `json:"contributions"`
+	Stacks        map[string]int `json:"stacks"`
+	Folded        []string       `json:"folded"`
+	AllIn         []string       `json:"allIn"`
+}
+
+// handMove is a validated action waiting for its chips to be taken from the player's stack.
+type handMove struct {
+	player string
+	action string
+	chips  int
+}
+
+// newHand deals players, given in seat order, into a hand with the button on players[dealer].
+// The forced bets still have to be posted, see forcedMoves, before the betting is opened.
+func newHand(players []string, stacks map[string]int, carry int, dealer int, blinds models.Blinds) *Hand {
+
+	minBet := blinds.BigBlind
+	if minBet < 1 {
+		minBet = 1
+	}
+
+	hand := &Hand{
+		players:    players,
+		stacks:     make(map[string]int),
+		streetBets: make(map[string]int),
+		handBets:   make(map[string]int),
+		folded:     make(map[string]bool),
+		allIn:      make(map[string]bool),
+		acted:      make(map[string]bool),
+		minBet:     minBet,
+		minRaise:   minBet,
+		carry:      carry,
+		blinds:     blinds,
+		dealer:     dealer,
+	}
+
+	for _, player := range players {
+		hand.stacks[player] = stacks[player]
+	}
+
+	// Heads-up the dealer posts the small blind
+	hand.smallBlind = (dealer + 1) % len(players)
+	if len(players) == 2 {
+		hand.smallBlind = dealer
+	}
+	hand.bigBlind = (hand.smallBlind + 1) % len(players)
+
+	return hand
+}
+
+// dealIn returns the seated players in seat order, and those of them dealt into the next hand: the players
+// who are connected, neither away nor sitting out and have chips.
+func dealIn(seats []models.Seat, record map[string]int, isOnline func(name string) bool) ([]string, []string) {
+
+	var seated, players []string
+	for _, seat := range seats {
+		seated = append(seated, seat.Player)
+		if !seat.SittingOut && !seat.Away && isOnline(seat.Player) && record[seat.Player] > 0 {
+			players = append(players, seat.Player)
+		}
+	}
+
+	return seated, players
+}
+
+// nextDealer returns the index in players of the next dealer. The button moves from the previous dealer to
+// the next player in seat order who is dealt in, the first hand is dealt by the first player.
+func nextDealer(seated []string, players []string, previous string) int {
+
+	if from := indexOf(seated, previous); from >= 0 {
+		for step := 1; step <= len(seated); step++ {
+			if i := indexOf(players, seated[(from+step)%len(seated)]); i >= 0 {
+				return i
+			}
+		}
+	}
+
+	return 0
+}
+
+// forcedMoves returns the antes and blinds to post, capped at what each player has.
+func (hand *Hand) forcedMoves() []*handMove {
+
+	var moves []*handMove
+	left := make(map[string]int)
+	for player, stack := range hand.stacks {
+		left[player] = stack
+	}
+
+	post := func(player string, action string, chips int) {
+		if chips > left[player] {
+			chips = left[player]
+		}
+		if chips > 0 {
+			left[player] -= chips
+			moves = append(moves, &handMove{player: player, action: action, chips: chips})
+		}
+	}
+
+	if hand.blinds.Ante > 0 {
+		for i := 1; i <= len(hand.players); i++ {
+			post(hand.players[(hand.dealer+i)%len(hand.players)], postAnte, hand.blinds.Ante)
+		}
+	}
+	post(hand.players[hand.smallBlind], postSmallBlind, hand.blinds.SmallBlind)
+	post(hand.players[hand.bigBlind], postBigBlind, hand.blinds.BigBlind)
+
+	return moves
+}
+
+// post records a forced bet. Antes are dead money and do not count towards the bet to call.
+func (hand *Hand) post(move *handMove) {
+
+	hand.stacks[move.player] -= move.chips
+	hand.handBets[move.player] += move.chips
+	if move.action != postAnte {
+		hand.streetBets[move.player] += move.chips
+		if hand.streetBets[move.player] > hand.currentBet {
+			hand.currentBet = hand.streetBets[move.player]
+		}
+	}
+
+	if hand.stacks[move.player] == 0 {
+		hand.allIn[move.player] = true
+	}
+}
+
+// open starts the betting once the forced bets are in. The player after the big blind acts first,
+// or the player after the dealer when the room plays without blinds.
+func (hand *Hand) open() {
+
+	if hand.canActCount() == 0 {
+		hand.street = len(streets) - 1
+		return
+	}
+
+	if hand.blinds.BigBlind > 0 {
+		hand.turn = hand.nextToAct(hand.bigBlind)
+	} else {
+		hand.turn = hand.nextToAct(hand.dealer)
+	}
+}
+
+// move validates an action from player and works out how many chips it costs.
+// For a raise, amount is the total the player bets on this street.
+func (hand *Hand) move(player string, action string, amount int) (*handMove, error) {
+
+	if hand.isOver() {
+		return nil, errNoHand
+	}
+
+	if _, ok := hand.stacks[player]; !ok {
+		return nil, errNotInHand
+	}
+
+	if hand.toAct() != player {
+		return nil, errNotYourTurn
+	}
+
+	stack := hand.stacks[player]
+	toCall := hand.currentBet - hand.streetBets[player]
+	move := &handMove{player: player, action: action}
+
+	switch action {
+	case FoldAction:
+	case CheckAction:
+		if toCall > 0 {
+			return nil, errCannotCheck
+		}
+	case CallAction:
+		if toCall == 0 {
+			return nil, errNothingToCall
+		}
+		move.chips = toCall
+		if stack < toCall {
+			move.chips = stack
+		}
+	case RaiseAction:
+		if amount <= hand.currentBet {
+			return nil, newError(CodeInvalidAmount, fmt.Sprintf("you must raise to more than %v", hand.currentBet))
+		}
+		move.chips = amount - hand.streetBets[player]
+		if move.chips > stack {
+			return nil, errNotEnoughChips
+		}
+		if hand.acted[player] {
+			return nil, errNotReopened
+		}
+		// A raise short of the minimum is only allowed when it puts the player all-in
+		if amount-hand.currentBet < hand.minRaise && move.chips < stack {
+			return nil, newError(CodeInvalidAmount, fmt.Sprintf("you must raise to at least %v", hand.currentBet+hand.minRaise))
+		}
+	case AllInAction:
+		if stack == 0 {
+			return nil, errNoChips
+		}
+		if hand.acted[player] && stack > toCall {
+			return nil, errNotReopened
+		}
+		move.chips = stack
+	default:
+		return nil, errUnknownAction
+	}
+
+	return move, nil
+}
+
+// describe returns a line for the room about the move. It must be called before the move is applied.
+func (hand *Hand) describe(move *handMove) string {
+
+	switch {
+	case move.action == postAnte:
+		return fmt.Sprintf("%v posts an ante of %v.", move.player, move.chips)
+	case move.action == postSmallBlind:
+		return fmt.Sprintf("%v posts the small blind of %v.", move.player, move.chips)
+	case move.action == postBigBlind:
+		return fmt.Sprintf("%v posts the big blind of %v.", move.player, move.chips)
+	case move.action == FoldAction:
+		return fmt.Sprintf("%v folds.", move.player)
+	case move.action == CheckAction:
+		return fmt.Sprintf("%v checks.", move.player)
+	case move.chips == hand.stacks[move.player]:
+		return fmt.Sprintf("%v is all-in with %v.", move.player, move.chips)
+	case move.action == CallAction:
+		return fmt.Sprintf("%v calls %v.", move.player, move.chips)
+	default:
+		return fmt.Sprintf("%v raises to %v.", move.player, hand.streetBets[move.player]+move.chips)
+	}
+}
+
+// apply records a move once its chips have been moved into the pot, then passes the turn on.
+func (hand *Hand) apply(move *handMove) {
+
+	player := move.player
+
+	if move.action == FoldAction {
+		hand.folded[player] = true
+	}
+
+	hand.stacks[player] -= move.chips
+	hand.streetBets[player] += move.chips
+	hand.handBets[player] += move.chips
+	if move.chips > 0 && hand.stacks[player] == 0 {
+		hand.allIn[player] = true
+	}
+
+	if bet := hand.streetBets[player]; bet > hand.currentBet {
+		// Only a full raise changes the minimum raise increment and reopens the betting to everybody
+		// else. After a short all-in the players who already acted only have to match the new bet.
+		if raise := bet - hand.currentBet; raise >= hand.minRaise {
+			hand.minRaise = raise
+			hand.acted = make(map[string]bool)
+		}
+		hand.currentBet = bet
+	}
+	hand.acted[player] = true
+
+	hand.advance()
+}
+
+// advance moves the turn to the next player, or the hand to the next street once the betting is closed.
+func (hand *Hand) advance() {
+
+	if len(hand.remaining()) == 1 {
+		hand.street = len(streets) - 1
+		return
+	}
+
+	if !hand.streetComplete() {
+		hand.turn = hand.nextToAct(hand.turn)
+		return
+	}
+
+	hand.street++
+	hand.streetBets = make(map[string]int)
+	hand.acted = make(map[string]bool)
+	hand.currentBet = 0
+	hand.minRaise = hand.minBet
+
+	// Nobody can bet anymore, run the board out to the showdown
+	if hand.canActCount() <= 1 {
+		hand.street = len(streets) - 1
+	}
+
+	if !hand.isOver() {
+		hand.turn = hand.nextToAct(hand.dealer)
+	}
+}
+
+func (hand *Hand) streetComplete() bool {
+
+	for _, player := range hand.players {
+		if !hand.canAct(player) {
+			continue
+		}
+		if !hand.acted[player] || hand.streetBets[player] != hand.currentBet {
+			return false
+		}
+	}
+
+	return true
+}
+
+// nextToAct returns the index of the first player after index who can still act.
+func (hand *Hand) nextToAct(index int) int {
+
+	for i := 1; i <= len(hand.players); i++ {
+		next := (index + i) % len(hand.players)
+		if hand.canAct(hand.players[next]) {
+			return next
+		}
+	}
+
+	return index
+}
+
+func (hand *Hand) canAct(player string) bool {
+	return !hand.folded[player] && !hand.allIn[player]
+}
+
+// canCheck reports whether the player has no bet to call on this street.
+func (hand *Hand) canCheck(player string) bool {
+	return hand.streetBets[player] >= hand.currentBet
+}
+
+func (hand *Hand) canActCount() int {
+
+	count := 0
+	for _, player := range hand.players {
+		if hand.canAct(player) {
+			count++
+		}
+	}
+
+	return count
+}
+
+// remaining returns the players who have not folded.
+func (hand *Hand) remaining() []string {
+
+	var players []string
+	for _, player := range hand.players {
+		if !hand.folded[player] {
+			players = append(players, player)
+		}
+	}
+
+	return players
+}
+
+// toAct returns the player whose turn it is, or an empty string once the betting is over.
+func (hand *Hand) toAct() string {
+
+	if hand.isOver() {
+		return ""
+	}
+
+	return hand.players[hand.turn]
+}
+
+func (hand *Hand) isOver() bool {
+	return streets[hand.street] == StreetShowdown
+}
+
+// pots splits the chips of the hand into a main pot and side pots.
+// A new pot starts at every all-in amount, and a player is eligible for every pot
+// up to what they put in. Players who can still act are eligible for every pot,
+// since they can still match any bet.
+func (hand *Hand) pots() []models.Pot {
+
+	var levels []int
+	highest := 0
+	for _, player := range hand.players {
+		bet := hand.handBets[player]
+		if hand.allIn[player] && !hand.folded[player] {
+			levels = append(levels, bet)
+		}
+		if bet > highest {
+			highest = bet
+		}
+	}
+	levels = append(levels, highest)
+	sort.Ints(levels)
+
+	pots := make([]models.Pot, 0)
+	previous := 0
+	for _, level := range levels {
+		if level == previous {
+			continue
+		}
+
+		pot := models.Pot{Eligible: []string{}}
+		for _, player := range hand.players {
+			bet := hand.handBets[player]
+			pot.Amount += minInt(bet, level) - minInt(bet, previous)
+			if !hand.folded[player] && (bet >= level || hand.canAct(player)) {
+				pot.Eligible = append(pot.Eligible, player)
+			}
+		}
+		previous = level
+
+		// Chips of players who folded above the last all-in can end up in a pot
+		// with the same players as the one before it, so merge the two
+		if n := len(pots); n > 0 && sameStrings(pots[n-1].Eligible, pot.Eligible) {
+			pots[n-1].Amount += pot.Amount
+			continue
+		}
+		pots = append(pots, pot)
+	}
+
+	if hand.carry > 0 {
+		if len(pots) == 0 {
+			pots = append(pots, models.Pot{Eligible: hand.remaining()})
+		}
+		pots[0].Amount += hand.carry
+	}
+
+	return pots
+}
+
+func sameStrings(a []string, b []string) bool {
+
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+func minInt(a int, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func (hand *Hand) state() *HandState {
+
+	state := &HandState{
+		Street:        streets[hand.street],
+		Players:       hand.players,
+		Turn:          hand.toAct(),
+		Dealer:        hand.players[hand.dealer],
+		CurrentBet:    hand.currentBet,
+		MinRaise:      hand.minRaise,
+		Contributions: make(map[string]int),
+		Stacks:        make(map[string]int),
+		Folded:        []string{},
+		AllIn:         []string{},
+	}
+
+	if hand.blinds.SmallBlind > 0 {
+		state.SmallBlind = hand.players[hand.smallBlind]
+	}
+	if hand.blinds.BigBlind > 0 {
+		state.BigBlind = hand.players[hand.bigBlind]
+	}
+
+	for _, player := range hand.players {
+		state.Contributions[player] = hand.streetBets[player]
+		state.Stacks[player] = hand.stacks[player]
+		if hand.folded[player] {
+			state.Folded = append(state.Folded, player)
+		}
+		if hand.allIn[player] {
+			state.AllIn = append(state.AllIn, player)
+		}
+	}
+
+	return state
+}
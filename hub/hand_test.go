@@ -0,0 +1,315 @@
+package hub
+
+import (
+	"go-pokerchips/models"
+	"reflect"
+	"testing"
+)
+
+// startTestHand deals a hand and posts its forced bets the way the room does.
+func startTestHand(players []string, stacks map[string]int, blinds models.Blinds) *Hand {
+
+	hand := newHand(players, stacks, 0, 0, blinds)
+	for _, move := range hand.forcedMoves() {
+		hand.post(move)
+	}
+	hand.open()
+
+	return hand
+}
+
+func play(t *testing.T, hand *Hand, player string, action string, amount int) {
+
+	t.Helper()
+
+	move, err := hand.move(player, action, amount)
+	if err != nil {
+		t.Fatalf("%v %v %v: %v", player, action, amount, err)
+	}
+	hand.apply(move)
+}
+
+func TestShortAllInDoesNotReopenBetting(t *testing.T) {
+
+	stacks := map[string]int{"ann": 1000, "bob": 1000, "cat": 25}
+	hand := startTestHand([]string{"ann", "bob", "cat"}, stacks, models.Blinds{SmallBlind: 5, BigBlind: 10})
+
+	play(t, hand, "ann", RaiseAction, 20)
+	play(t, hand, "bob", CallAction, 0)
+
+	// All-in for 25 is only 5 more than the raise to 20
+	play(t, hand, "cat", AllInAction, 0)
+
+	if hand.toAct() != "ann" {
+		t.Fatalf("%v is to act, want ann", hand.toAct())
+	}
+
+	if _, err := hand.move("ann", RaiseAction, 60); err != errNotReopened {
+		t.Errorf("raise after a short all-in: got %v, want %v", err, errNotReopened)
+	}
+
+	if _, err := hand.move("ann", AllInAction, 0); err != errNotReopened {
+		t.Errorf("all-in after a short all-in: got %v, want %v", err, errNotReopened)
+	}
+
+	play(t, hand, "ann", CallAction, 0)
+	play(t, hand, "bob", CallAction, 0)
+
+	if street := streets[hand.street]; street != StreetFlop {
+		t.Errorf("street is %v, want %v", street, StreetFlop)
+	}
+}
+
+func TestFullRaiseReopensBetting(t *testing.T) {
+
+	stacks := map[string]int{"ann": 1000, "bob": 1000, "cat": 1000}
+	hand := startTestHand([]string{"ann", "bob", "cat"}, stacks, models.Blinds{SmallBlind: 5, BigBlind: 10})
+
+	play(t, hand, "ann", RaiseAction, 20)
+	play(t, hand, "bob", CallAction, 0)
+	play(t, hand, "cat", RaiseAction, 40)
+
+	play(t, hand, "ann", RaiseAction, 80)
+
+	if hand.minRaise != 40 {
+		t.Errorf("minimum raise is %v, want 40", hand.minRaise)
+	}
+}
+
+func TestSidePots(t *testing.T) {
+
+	type move struct {
+		player string
+		action string
+		amount int
+	}
+
+	// Without blinds the player after the dealer, the first one, opens the betting
+	tests := []struct {
+		name    string
+		players []string
+		stacks  map[string]int
+		carry   int
+		moves   []move
+		want    []models.Pot
+	}{
+		{
+			name:    "everybody calls",
+			players: []string{"ann", "bob", "cat"},
+			stacks:  map[string]int{"ann": 1000, "bob": 1000, "cat": 1000},
+			moves:   []move{{"bob", RaiseAction, 50}, {"cat", CallAction, 0}, {"ann", CallAction, 0}},
+			want:    []models.Pot{{Amount: 150, Eligible: []string{"ann", "bob", "cat"}}},
+		},
+		{
+			name:    "short all-in",
+			players: []string{"ann", "bob", "cat"},
+			stacks:  map[string]int{"ann": 1000, "bob": 1000, "cat": 100},
+			moves:   []move{{"bob", RaiseAction, 300}, {"cat", AllInAction, 0}, {"ann", CallAction, 0}},
+			want: []models.Pot{
+				{Amount: 300, Eligible: []string{"ann", "bob", "cat"}},
+				{Amount: 400, Eligible: []string{"ann", "bob"}},
+			},
+		},
+		{
+			name:    "three uneven all-ins",
+			players: []string{"ann", "bob", "cat", "dan"},
+			stacks:  map[string]int{"ann": 500, "bob": 300, "cat": 100, "dan": 1000},
+			moves: []move{
+				{"bob", AllInAction, 0}, {"cat", AllInAction, 0}, {"dan", CallAction, 0},
+				{"ann", AllInAction, 0}, {"dan", CallAction, 0},
+			},
+			want: []models.Pot{
+				{Amount: 400, Eligible: []string{"ann", "bob", "cat", "dan"}},
+				{Amount: 600, Eligible: []string{"ann", "bob", "dan"}},
+				{Amount: 400, Eligible: []string{"ann", "dan"}},
+			},
+		},
+		{
+			name:    "fold after an all-in",
+			players: []string{"ann", "bob", "cat"},
+			stacks:  map[string]int{"ann": 1000, "bob": 1000, "cat": 100},
+			moves:   []move{{"bob", RaiseAction, 300}, {"cat", AllInAction, 0}, {"ann", FoldAction, 0}},
+			want: []models.Pot{
+				{Amount: 200, Eligible: []string{"bob", "cat"}},
+				{Amount: 200, Eligible: []string{"bob"}},
+			},
+		},
+		{
+			name:    "chips carried over go to the main pot",
+			players: []string{"ann", "bob", "cat"},
+			stacks:  map[string]int{"ann": 1000, "bob": 1000, "cat": 50},
+			carry:   30,
+			moves:   []move{{"bob", RaiseAction, 100}, {"cat", AllInAction, 0}, {"ann", CallAction, 0}},
+			want: []models.Pot{
+				{Amount: 180, Eligible: []string{"ann", "bob", "cat"}},
+				{Amount: 100, Eligible: []string{"ann", "bob"}},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			hand := newHand(test.players, test.stacks, test.carry, 0, models.Blinds{})
+			hand.open()
+
+			for _, move := range test.moves {
+				play(t, hand, move.player, move.action, move.amount)
+			}
+
+			if pots := hand.pots(); !reflect.DeepEqual(pots, test.want) {
+				t.Errorf("got pots %v, want %v", pots, test.want)
+			}
+		})
+	}
+}
+
+func TestForcedMoves(t *testing.T) {
+
+	players := []string{"ann", "bob", "cat"}
+
+	tests := []struct {
+		name    string
+		players []string
+		stacks  map[string]int
+		dealer  int
+		blinds  models.Blinds
+		want    []handMove
+		bet     int
+		toAct   string
+	}{
+		{
+			name:    "blinds left of the dealer",
+			players: players,
+			stacks:  map[string]int{"ann": 1000, "bob": 1000, "cat": 1000},
+			blinds:  models.Blinds{SmallBlind: 5, BigBlind: 10},
+			want:    []handMove{{"bob", postSmallBlind, 5}, {"cat", postBigBlind, 10}},
+			bet:     10,
+			toAct:   "ann",
+		},
+		{
+			name:    "blinds wrap around the table",
+			players: players,
+			stacks:  map[string]int{"ann": 1000, "bob": 1000, "cat": 1000},
+			dealer:  2,
+			blinds:  models.Blinds{SmallBlind: 5, BigBlind: 10},
+			want:    []handMove{{"ann", postSmallBlind, 5}, {"bob", postBigBlind, 10}},
+			bet:     10,
+			toAct:   "cat",
+		},
+		{
+			name:    "heads-up the dealer posts the small blind",
+			players: []string{"ann", "bob"},
+			stacks:  map[string]int{"ann": 1000, "bob": 1000},
+			blinds:  models.Blinds{SmallBlind: 5, BigBlind: 10},
+			want:    []handMove{{"ann", postSmallBlind, 5}, {"bob", postBigBlind, 10}},
+			bet:     10,
+			toAct:   "ann",
+		},
+		{
+			name:    "antes before the blinds do not count towards the bet",
+			players: players,
+			stacks:  map[string]int{"ann": 1000, "bob": 1000, "cat": 1000},
+			blinds:  models.Blinds{SmallBlind: 5, BigBlind: 10, Ante: 1},
+			want: []handMove{
+				{"bob", postAnte, 1}, {"cat", postAnte, 1}, {"ann", postAnte, 1},
+				{"bob", postSmallBlind, 5}, {"cat", postBigBlind, 10},
+			},
+			bet:   10,
+			toAct: "ann",
+		},
+		{
+			name:    "short stack posts what it has",
+			players: players,
+			stacks:  map[string]int{"ann": 1000, "bob": 1000, "cat": 7},
+			blinds:  models.Blinds{SmallBlind: 5, BigBlind: 10, Ante: 2},
+			want: []handMove{
+				{"bob", postAnte, 2}, {"cat", postAnte, 2}, {"ann", postAnte, 2},
+				{"bob", postSmallBlind, 5}, {"cat", postBigBlind, 5},
+			},
+			bet:   5,
+			toAct: "ann",
+		},
+		{
+			name:    "no blinds",
+			players: players,
+			stacks:  map[string]int{"ann": 1000, "bob": 1000, "cat": 1000},
+			toAct:   "bob",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			hand := newHand(test.players, test.stacks, 0, test.dealer, test.blinds)
+
+			var moves []handMove
+			for _, move := range hand.forcedMoves() {
+				moves = append(moves, *move)
+				hand.post(move)
+			}
+			hand.open()
+
+			if !reflect.DeepEqual(moves, test.want) {
+				t.Errorf("got forced moves %v, want %v", moves, test.want)
+			}
+			if hand.currentBet != test.bet {
+				t.Errorf("bet to call is %v, want %v", hand.currentBet, test.bet)
+			}
+			if hand.toAct() != test.toAct {
+				t.Errorf("%v is to act, want %v", hand.toAct(), test.toAct)
+			}
+		})
+	}
+}
+
+func TestDealIn(t *testing.T) {
+
+	seats := []models.Seat{
+		{Number: 1, Player: "ann"},
+		{Number: 2, Player: "bob", SittingOut: true},
+		{Number: 3, Player: "cat", Away: true},
+		{Number: 5, Player: "dan"},
+		{Number: 6, Player: "eve"},
+		{Number: 8, Player: "fay"},
+	}
+	record := map[string]int{"ann": 100, "bob": 100, "cat": 100, "dan": 0, "eve": 100, "fay": 100}
+	online := func(name string) bool { return name != "fay" }
+
+	seated, players := dealIn(seats, record, online)
+
+	if want := []string{"ann", "bob", "cat", "dan", "eve", "fay"}; !reflect.DeepEqual(seated, want) {
+		t.Errorf("seated %v, want %v", seated, want)
+	}
+	if want := []string{"ann", "eve"}; !reflect.DeepEqual(players, want) {
+		t.Errorf("dealt in %v, want %v", players, want)
+	}
+}
+
+func TestNextDealer(t *testing.T) {
+
+	seated := []string{"ann", "bob", "cat", "dan"}
+
+	tests := []struct {
+		name     string
+		players  []string
+		previous string
+		want     string
+	}{
+		{"first hand", seated, "", "ann"},
+		{"next seat", seated, "ann", "bob"},
+		{"round the table", seated, "dan", "ann"},
+		{"busted player skipped", []string{"ann", "cat", "dan"}, "ann", "cat"},
+		{"busted dealer", []string{"ann", "cat", "dan"}, "bob", "cat"},
+		{"several skipped", []string{"ann", "bob"}, "bob", "ann"},
+		{"dealer left the table", seated, "eve", "ann"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if dealer := test.players[nextDealer(seated, test.players, test.previous)]; dealer != test.want {
+				t.Errorf("%v deals, want %v", dealer, test.want)
+			}
+		})
+	}
+}
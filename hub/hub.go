@@ -1,52 +1,99 @@
 package hub
 
 import (
-	"fmt"
+	"github.com/dchest/uniuri"
 	"go-pokerchips/models"
 	"go-pokerchips/services"
+	"log"
+	"sync"
+	"time"
 )
 
 type Hub struct {
 
-	// Track the rooms available
-	rooms map[*Room]bool
+	// Guards rooms, which is read by the HTTP handlers and changed by the room goroutines
+	mu sync.Mutex
+
+	// The rooms loaded in memory by uri, at most one per room
+	rooms map[string]*Room
 
 	roomService services.RoomService
+
+	// Shares the room broadcasts with the other instances of the server, nil when it runs alone
+	pubsub PubSub
+
+	// Tells the events of this instance apart from those of the others
+	instance string
+
+	// How long a room stays loaded after the last player left
+	idleGrace time.Duration
+
+	// What to do with clients that cannot keep up, SlowClientResync or SlowClientDisconnect
+	slowClients string
 }
 
-func NewHub(roomService services.RoomService) *Hub {
+func NewHub(roomService services.RoomService, pubsub PubSub, idleGrace time.Duration, slowClients string) *Hub {
 
 	return &Hub{
-		rooms:       make(map[*Room]bool),
+		rooms:       make(map[string]*Room),
 		roomService: roomService,
+		pubsub:      pubsub,
+		instance:    uniuri.New(),
+		idleGrace:   idleGrace,
+		slowClients: slowClients,
 	}
 }
 
 func (hub *Hub) FindRoomByUri(uri string) *Room {
 
-	for room := range hub.rooms {
-		if room.Uri == uri {
-			return room
-		}
-	}
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
 
-	return nil
+	return hub.rooms[uri]
 }
 
-// CreateRoom creates room in memory and assign its pointer to hub map.
-func (hub *Hub) CreateRoom(room *models.DBRoom) *Room {
+// GetOrCreateRoom returns the room loaded for the uri of the stored room, or loads it and starts
+// its goroutine. Players connecting at the same time always end up in the same room.
+func (hub *Hub) GetOrCreateRoom(room *models.DBRoom) *Room {
+
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+
+	if hubRoom, ok := hub.rooms[room.Uri]; ok {
+		return hubRoom
+	}
 
 	hubRoom := NewRoom(hub, room)
 	go hubRoom.RunRoom()
-	hub.rooms[hubRoom] = true
+	hub.rooms[hubRoom.Uri] = hubRoom
 
-	fmt.Println(hub.rooms)
+	log.Printf("Loaded room %v, %v rooms in memory\n", hubRoom.Uri, len(hub.rooms))
 	return hubRoom
 }
 
+// DeleteRoom takes the room out of the hub, unless it was already replaced by a newer one.
 func (hub *Hub) DeleteRoom(room *Room) {
 
-	if _, ok := hub.rooms[room]; ok {
-		delete(hub.rooms, room)
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+
+	if hub.rooms[room.Uri] == room {
+		delete(hub.rooms, room.Uri)
+	}
+}
+
+// Shutdown stops every room and waits until they have saved their state.
+func (hub *Hub) Shutdown() {
+
+	hub.mu.Lock()
+	rooms := make([]*Room, 0, len(hub.rooms))
+	for _, room := range hub.rooms {
+		rooms = append(rooms, room)
+	}
+	hub.mu.Unlock()
+
+	for _, room := range rooms {
+		room.stop()
+		<-room.stopped
 	}
 }
@@ -0,0 +1,197 @@
+package hub
+
+import (
+	"encoding/json"
+	"go-pokerchips/models"
+	"go-pokerchips/services"
+	"sync"
+	"testing"
+	"time"
+)
+
+// How long a test waits for a message before it gives up
+const testTimeout = 5 * time.Second
+
+// newTestHub runs a hub on the room service, alone when pubsub is nil.
+func newTestHub(t *testing.T, roomService services.RoomService, pubsub PubSub, idleGrace time.Duration, slowClients string) *Hub {
+
+	hub := NewHub(roomService, pubsub, idleGrace, slowClients)
+	t.Cleanup(hub.Shutdown)
+
+	return hub
+}
+
+// createTestRoom stores a cash game room created by the first player, with the others registered in it.
+func createTestRoom(t *testing.T, roomService services.RoomService, players ...string) *models.DBRoom {
+
+	t.Helper()
+
+	dbRoom, err := roomService.CreateRoom(&models.CreateRoomInput{Creator: players[0]})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range players[1:] {
+		if err = roomService.RegisterUserInRoom(dbRoom.Id.Hex(), name, "", ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return dbRoom
+}
+
+// connect registers a client without a websocket in the room, the test reads what it is sent from its send channel.
+func connect(t *testing.T, room *Room, name string) *Client {
+
+	t.Helper()
+
+	client := newClient(nil, room.hub, room, name, ProtocolV1)
+	if !deliver(room, room.register, client) {
+		t.Fatalf("room %v stopped before %v connected", room.Uri, name)
+	}
+
+	return client
+}
+
+// disconnect unregisters a client the way its read pump does when the connection is gone.
+func disconnect(client *Client) {
+	deliver(client.room, client.room.unregister, client)
+}
+
+// send hands a message to the client as if it came in over its websocket.
+func send(client *Client, message Message) {
+	client.handleNewMessage(message.encode())
+}
+
+// next returns the next message sent to the client.
+func next(t *testing.T, client *Client) Message {
+
+	t.Helper()
+
+	select {
+	case data, ok := <-client.send:
+		if !ok {
+			t.Fatalf("%v was disconnected", client.name)
+		}
+		var message Message
+		if err := json.Unmarshal(data, &message); err != nil {
+			t.Fatal(err)
+		}
+		return message
+	case <-time.After(testTimeout):
+		t.Fatalf("%v got no message", client.name)
+	}
+
+	return Message{}
+}
+
+// expect skips the messages sent to the client until one with the action.
+func expect(t *testing.T, client *Client, action string) Message {
+
+	t.Helper()
+
+	for {
+		if message := next(t, client); message.Action == action {
+			return message
+		}
+	}
+}
+
+// join connects a client the way ServeWS does, loading the room again when it shut down in the meantime.
+func join(hub *Hub, dbRoom *models.DBRoom, name string) *Client {
+
+	room := hub.GetOrCreateRoom(dbRoom)
+	client := newClient(nil, hub, room, name, ProtocolV1)
+
+	for !deliver(room, room.register, client) {
+		room = hub.GetOrCreateRoom(dbRoom)
+		client.room = room
+	}
+
+	return client
+}
+
+// isClosed reports whether the channel was closed, skipping what is still queued on it.
+func isClosed(ch chan []byte) bool {
+
+	for {
+		select {
+		case _, ok := <-ch:
+			if !ok {
+				return true
+			}
+		case <-time.After(100 * time.Millisecond):
+			return false
+		}
+	}
+}
+
+func TestGetOrCreateRoomLoadsOnce(t *testing.T) {
+
+	roomService := services.NewMemoryRoomService()
+	hub := newTestHub(t, roomService, nil, time.Minute, SlowClientResync)
+	dbRoom := createTestRoom(t, roomService, "ann")
+
+	rooms := make([]*Room, 50)
+	var wg sync.WaitGroup
+	for i := range rooms {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			rooms[i] = hub.GetOrCreateRoom(dbRoom)
+		}(i)
+	}
+	wg.Wait()
+
+	for _, room := range rooms {
+		if room != rooms[0] {
+			t.Fatal("the room was loaded more than once")
+		}
+	}
+}
+
+func TestGetOrCreateRoomRacesShutdown(t *testing.T) {
+
+	roomService := services.NewMemoryRoomService()
+	hub := newTestHub(t, roomService, nil, time.Minute, SlowClientResync)
+	dbRoom := createTestRoom(t, roomService, "ann", "bob")
+
+	for i := 0; i < 20; i++ {
+
+		room := hub.GetOrCreateRoom(dbRoom)
+		clients := make([]*Client, 10)
+
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			room.stop()
+			hub.DeleteRoom(room)
+		}()
+		for j := range clients {
+			wg.Add(1)
+			go func(j int) {
+				defer wg.Done()
+				clients[j] = join(hub, dbRoom, "ann")
+			}(j)
+		}
+		wg.Wait()
+		<-room.stopped
+
+		current := hub.FindRoomByUri(dbRoom.Uri)
+		if current != nil {
+			select {
+			case <-current.stopped:
+				t.Fatal("the hub kept a room that stopped")
+			default:
+			}
+		}
+
+		// Every client is either in the room loaded now or was dropped by the one that stopped
+		for _, client := range clients {
+			if client.room != current && !isClosed(client.send) {
+				t.Fatal("a client was left in a room that stopped")
+			}
+		}
+	}
+}
@@ -2,6 +2,7 @@ package hub
 
 import (
 	"encoding/json"
+	"go-pokerchips/models"
 	"log"
 )
 
@@ -12,14 +13,117 @@ const (
 	JoinRoomAction    = "join-room"
 	SendMessageAction = "send-message"
 	LeaveRoomAction   = "leave-room"
+	ErrorAction       = "error"
+	RoomStateAction   = "room-state"
 )
 
+// A client that reconnects sends resume with the last Sequence and Epoch it saw to get the messages it missed.
+const ResumeAction = "resume"
+
+// Replies to an action handled by the room goroutine and sent with a RequestId, only to its sender. An ack carries the Sequence of the
+// last broadcast once the action was applied, a nack the Code and text of the error.
+// Sending the same RequestId again gets the same reply, the action is not applied twice.
+const (
+	AckAction  = "ack"
+	NackAction = "nack"
+)
+
+// Betting round actions. A raise carries the total bet for the street in Amount.
+const (
+	StartHandAction  = "start-hand"
+	CheckAction      = "check"
+	CallAction       = "call"
+	RaiseAction      = "raise"
+	FoldAction       = "fold"
+	AllInAction      = "all-in"
+	UpdateHandAction = "update-hand"
+)
+
+// Action clock of the rooms that have one. The player to act can add time from their time bank with time-bank,
+// every change of the Clock is told with action-clock.
+const (
+	TimeBankAction    = "time-bank"
+	ActionClockAction = "action-clock"
+)
+
+// The host sets the blinds and ante for the next hands with Blinds.
+const (
+	SetBlindsAction    = "set-blinds"
+	UpdateBlindsAction = "update-blinds"
+)
+
+// Tournament clock actions, only the host of the room can start, pause or resume it.
+const (
+	StartTournamentAction  = "start-tournament"
+	PauseTournamentAction  = "pause-tournament"
+	ResumeTournamentAction = "resume-tournament"
+	UpdateTournamentAction = "update-tournament"
+)
+
+// Host commands, Name is the player the command applies to. Every player is told with update-room.
+// Swapping seats moves the players of Seat and OtherSeat.
+const (
+	KickAction         = "kick"
+	TransferHostAction = "transfer-host"
+	AdjustStackAction  = "adjust-stack"
+	LockRoomAction     = "lock-room"
+	UnlockRoomAction   = "unlock-room"
+	CloseRoomAction    = "close-room"
+	SwapSeatsAction    = "swap-seats"
+	UpdateRoomAction   = "update-room"
+)
+
+// Seat actions, a player takes the seat numbered Seat. Every player is told with update-seats,
+// which carries the taken Seats. Players are also marked away, and back, as they disconnect and connect.
+const (
+	TakeSeatAction    = "take-seat"
+	StandUpAction     = "stand-up"
+	SitOutAction      = "sit-out"
+	SitInAction       = "sit-in"
+	UpdateSeatsAction = "update-seats"
+)
+
+// Showdown actions. The host declares the winners of the pot at PotIndex in Winners.
+const (
+	AwardPotAction   = "award-pot"
+	PotAwardedAction = "pot-awarded"
+)
+
+// Message is a message of protocol version 1, and the form every version is read into for the room goroutine.
 type Message struct {
-	Action       string `json:"action"`
-	Message      string `json:"message"`
-	Pot          int    `json:"pot"`
-	CurrentChips int    `json:"currentChips"`
-	Sender       string `json:"sender,omitempty"`
+	Action       string             `json:"action"`
+	Message      string             `json:"message"`
+	Pot          int                `json:"pot"`
+	Pots         []models.Pot       `json:"pots,omitempty"`
+	Amount       int                `json:"amount,omitempty"`
+	CurrentChips int                `json:"currentChips"`
+	Sender       string             `json:"sender,omitempty"`
+	Hand         *HandState         `json:"hand,omitempty"`
+	PotIndex     int                `json:"potIndex,omitempty"`
+	Winners      []string           `json:"winners,omitempty"`
+	Payouts      map[string]int     `json:"payouts,omitempty"`
+	Blinds       *models.Blinds     `json:"blinds,omitempty"`
+	Tournament   *models.Tournament `json:"tournament,omitempty"`
+	Name         string             `json:"name,omitempty"`
+	Reason       string             `json:"reason,omitempty"`
+	State        *RoomState         `json:"state,omitempty"`
+	Seat         int                `json:"seat,omitempty"`
+	OtherSeat    int                `json:"otherSeat,omitempty"`
+	Seats        []models.Seat      `json:"seats,omitempty"`
+	Clock        *ActionClock       `json:"clock,omitempty"`
+
+	// Machine readable reason of an error message, one of the Code constants
+	Code string `json:"code,omitempty"`
+
+	// Set on every message broadcast to the room, increasing by one each time
+	Sequence int64 `json:"sequence,omitempty"`
+
+	// The run of the room the Sequence counts in. Each instance numbers its own broadcasts from the
+	// start every time it loads the room, so a sequence only means something along with its epoch.
+	Epoch string `json:"epoch,omitempty"`
+
+	// Chosen by the client to be told with ack or nack whether the action was applied
+	RequestId string `json:"requestId,omitempty"`
 }
 
 func (message *Message) encode() []byte {
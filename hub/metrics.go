@@ -0,0 +1,74 @@
+package hub
+
+import "sort"
+
+// ClientMetrics is how well a connected client keeps up with the messages of its room.
+type ClientMetrics struct {
+	Name string `json:"name"`
+
+	// Messages waiting to be written to the connection, out of Capacity, and the most there ever were
+	Queued    int `json:"queued"`
+	Capacity  int `json:"capacity"`
+	HighWater int `json:"highWater"`
+
+	Sent    int64 `json:"sent"`
+	Dropped int64 `json:"dropped"`
+	Resyncs int64 `json:"resyncs"`
+	Lagging bool  `json:"lagging"`
+}
+
+// RoomMetrics are the metrics of the clients connected to a room on this instance.
+type RoomMetrics struct {
+	Uri     string          `json:"uri"`
+	Clients []ClientMetrics `json:"clients"`
+}
+
+// Metrics asks every room for the metrics of its clients.
+func (hub *Hub) Metrics() []RoomMetrics {
+
+	hub.mu.Lock()
+	rooms := make([]*Room, 0, len(hub.rooms))
+	for _, room := range hub.rooms {
+		rooms = append(rooms, room)
+	}
+	hub.mu.Unlock()
+
+	metrics := make([]RoomMetrics, 0, len(rooms))
+	for _, room := range rooms {
+		reply := make(chan RoomMetrics, 1)
+		if deliver(room, room.metrics, reply) {
+			metrics = append(metrics, <-reply)
+		}
+	}
+
+	sort.Slice(metrics, func(i, j int) bool {
+		return metrics[i].Uri < metrics[j].Uri
+	})
+
+	return metrics
+}
+
+// collectMetrics runs on the room goroutine, which is the only one to touch the counters of the clients.
+func (room *Room) collectMetrics() RoomMetrics {
+
+	metrics := RoomMetrics{Uri: room.Uri, Clients: make([]ClientMetrics, 0, len(room.clients))}
+
+	for client := range room.clients {
+		metrics.Clients = append(metrics.Clients, ClientMetrics{
+			Name:      client.name,
+			Queued:    len(client.send),
+			Capacity:  cap(client.send),
+			HighWater: client.highWater,
+			Sent:      client.sent,
+			Dropped:   client.dropped,
+			Resyncs:   client.resyncs,
+			Lagging:   client.lagging,
+		})
+	}
+
+	sort.Slice(metrics.Clients, func(i, j int) bool {
+		return metrics.Clients[i].Name < metrics.Clients[j].Name
+	})
+
+	return metrics
+}
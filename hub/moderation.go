@@ -0,0 +1,166 @@
+package hub
+
+import (
+	"fmt"
+	"go-pokerchips/models"
+	"go-pokerchips/services"
+	"log"
+)
+
+var errUnknownCommand = newError(CodeInvalidRequest, "unknown host command")
+
+// moderation is a host command that came in over REST, handled by the room goroutine like the websocket ones.
+type moderation struct {
+	host  string
+	input *models.ModerateRoomInput
+	done  chan error
+}
+
+// Moderate applies a host command to the room with the given uri. While players are connected the
+// command goes through the room goroutine, so it cannot get in the way of a hand and everybody sees it.
+func (hub *Hub) Moderate(uri string, host string, input *models.ModerateRoomInput) error {
+
+	room := hub.FindRoomByUri(uri)
+
+	if room == nil {
+		dbRoom, err := hub.roomService.FindRoomByUri(uri)
+		if err != nil {
+			return err
+		}
+
+		// Another instance may be playing in the room, the command then goes through its owner
+		if hub.pubsub == nil {
+			_, err = moderate(hub.roomService, dbRoom.Id.Hex(), host, input)
+			return err
+		}
+		room = hub.GetOrCreateRoom(dbRoom)
+	}
+
+	done := make(chan error, 1)
+	if !deliver(room, room.moderations, &moderation{host, input, done}) {
+		// The room shut down in the meantime
+		return hub.Moderate(uri, host, input)
+	}
+
+	return <-done
+}
+
+// handleModeration applies a host command that came in over REST, or has the owner of the room apply it.
+func (room *Room) handleModeration(m *moderation) {
+
+	if room.isOwner() {
+		m.done <- room.moderate(m.host, m.input)
+		return
+	}
+
+	room.forwardRequest(&forwardedRequest{Player: m.host, Moderation: m.input}, "", func(reply *Message) {
+		m.done <- replyError(reply)
+	})
+}
+
+// moderate runs on the room goroutine of the owner of the room, see Room.RunRoom.
+func (room *Room) moderate(host string, input *models.ModerateRoomInput) error {
+
+	// Stacks and seats cannot change under a hand that is being played
+	if (input.Action == KickAction || input.Action == AdjustStackAction || input.Action == SwapSeatsAction) && room.handInProgress() {
+		return errHandInProgress
+	}
+
+	result, err := moderate(room.hub.roomService, room.Id, host, input)
+	if err != nil {
+		return err
+	}
+
+	switch input.Action {
+	case TransferHostAction:
+		room.Host = input.Name
+	case LockRoomAction:
+		room.Locked = true
+	case UnlockRoomAction:
+		room.Locked = false
+	}
+
+	message := &Message{
+		Action:       UpdateRoomAction,
+		Message:      result.text,
+		Pot:          room.Pot,
+		Amount:       input.Amount,
+		CurrentChips: result.chips,
+		Sender:       host,
+		Name:         input.Name,
+		Reason:       input.Reason,
+		Seat:         input.Seat,
+		OtherSeat:    input.OtherSeat,
+		Seats:        result.seats,
+	}
+	room.broadcastLocal(message)
+	room.publish(&remoteEvent{Message: message, Command: input.Action})
+
+	// The players are dropped after the broadcast so they know why
+	switch input.Action {
+	case KickAction:
+		for client := range room.clients {
+			if client.name == input.Name {
+				room.dropClient(client)
+			}
+		}
+	case CloseRoomAction:
+		room.stop()
+		log.Printf("Room %v was closed by %v\n", room.Uri, host)
+	}
+
+	return nil
+}
+
+// moderationResult is a host command described for the players, along with what it changed.
+type moderationResult struct {
+	text string
+
+	// The new stack of the player after a stack adjustment
+	chips int
+
+	// The taken seats after a swap
+	seats []models.Seat
+}
+
+// moderate applies a host command through the room service and describes it for the players.
+func moderate(roomService services.RoomService, id string, host string, input *models.ModerateRoomInput) (*moderationResult, error) {
+
+	result := &moderationResult{}
+	var err error
+
+	switch input.Action {
+	case KickAction:
+		err = roomService.KickUser(id, host, input.Name, input.Reason)
+		result.text = fmt.Sprintf("%v kicked %v out of the room.", host, input.Name)
+		if input.Reason != "" {
+			result.text = fmt.Sprintf("%v kicked %v out of the room: %v", host, input.Name, input.Reason)
+		}
+	case TransferHostAction:
+		err = roomService.TransferHost(id, host, input.Name)
+		result.text = fmt.Sprintf("%v made %v the host of the room.", host, input.Name)
+	case AdjustStackAction:
+		result.chips, err = roomService.AdjustStack(id, host, input.Name, input.Amount, input.Reason)
+		result.text = fmt.Sprintf("%v adjusted the stack of %v by %+d to %v: %v", host, input.Name, input.Amount, result.chips, input.Reason)
+	case LockRoomAction:
+		err = roomService.LockRoom(id, host, true)
+		result.text = fmt.Sprintf("%v locked the room, nobody else can join.", host)
+	case UnlockRoomAction:
+		err = roomService.LockRoom(id, host, false)
+		result.text = fmt.Sprintf("%v unlocked the room.", host)
+	case CloseRoomAction:
+		err = roomService.CloseRoom(id, host)
+		result.text = fmt.Sprintf("%v closed the room. Thanks for playing!", host)
+	case SwapSeatsAction:
+		result.seats, err = roomService.SwapSeats(id, host, input.Seat, input.OtherSeat)
+		result.text = fmt.Sprintf("%v swapped seats %v and %v.", host, input.Seat, input.OtherSeat)
+	default:
+		err = errUnknownCommand
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
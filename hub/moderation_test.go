@@ -0,0 +1,191 @@
+package hub
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"go-pokerchips/controllers"
+	"go-pokerchips/models"
+	"go-pokerchips/services"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// kickEntry returns the ledger entry of the kick of the player.
+func kickEntry(t *testing.T, roomService services.RoomService, uri string, name string) *models.LedgerEntry {
+
+	t.Helper()
+
+	entries, err := roomService.FindLedgerByUri(uri, 1, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		if entry.Type == models.LedgerKick && entry.Actor == name {
+			return entry
+		}
+	}
+
+	t.Fatalf("the ledger has no kick of %v", name)
+	return nil
+}
+
+func TestModerateOverWebsocket(t *testing.T) {
+
+	roomService := services.NewMemoryRoomService()
+	hub := newTestHub(t, roomService, nil, time.Minute, SlowClientResync)
+	dbRoom := createTestRoom(t, roomService, "ann", "bob", "cat")
+	room := hub.GetOrCreateRoom(dbRoom)
+
+	ann := connect(t, room, "ann")
+	bob := connect(t, room, "bob")
+	cat := connect(t, room, "cat")
+
+	// Only the host moderates
+	for _, action := range []string{KickAction, LockRoomAction, CloseRoomAction} {
+		send(bob, Message{Action: action, Name: "cat", RequestId: action})
+		if nack := expect(t, bob, NackAction); nack.Message != services.ErrNotHost.Error() || nack.Code != CodeNotAllowed {
+			t.Errorf("%v by a player got %q with code %v, want %q with %v", action, nack.Message, nack.Code, services.ErrNotHost, CodeNotAllowed)
+		}
+	}
+
+	send(ann, Message{Action: KickAction, Name: "cat", Reason: "slow play", RequestId: "kick"})
+	expect(t, ann, AckAction)
+	if update := expect(t, bob, UpdateRoomAction); update.Name != "cat" || update.Reason != "slow play" {
+		t.Errorf("got kick of %v for %q, want cat for slow play", update.Name, update.Reason)
+	}
+	if !isClosed(cat.send) {
+		t.Error("cat is still connected once kicked")
+	}
+
+	// The stack of cat left with them
+	if entry := kickEntry(t, roomService, dbRoom.Uri, "cat"); entry.Reason != "slow play" || entry.Amount != models.DefaultStartingStack || entry.BalanceAfter != 0 {
+		t.Errorf("kick entry has reason %q, amount %v and balance %v, want slow play, %v and 0", entry.Reason, entry.Amount, entry.BalanceAfter, models.DefaultStartingStack)
+	}
+	if err := roomService.RegisterUserInRoom(dbRoom.Id.Hex(), "cat", "", ""); err != services.ErrUserKicked {
+		t.Errorf("cat joined again with error %v, want %v", err, services.ErrUserKicked)
+	}
+
+	send(ann, Message{Action: LockRoomAction, RequestId: "lock"})
+	expect(t, ann, AckAction)
+	if err := roomService.RegisterUserInRoom(dbRoom.Id.Hex(), "dan", "", ""); err != services.ErrRoomLocked {
+		t.Errorf("dan joined a locked room with error %v, want %v", err, services.ErrRoomLocked)
+	}
+
+	send(ann, Message{Action: UnlockRoomAction, RequestId: "unlock"})
+	expect(t, ann, AckAction)
+	if err := roomService.RegisterUserInRoom(dbRoom.Id.Hex(), "dan", "", ""); err != nil {
+		t.Errorf("dan could not join the unlocked room: %v", err)
+	}
+
+	send(ann, Message{Action: CloseRoomAction, RequestId: "close"})
+	expect(t, bob, UpdateRoomAction)
+	if !isClosed(ann.send) || !isClosed(bob.send) {
+		t.Error("players are still connected to the closed room")
+	}
+
+	stored, err := roomService.FindRoomByUri(dbRoom.Uri)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !stored.Closed {
+		t.Error("the stored room is not closed")
+	}
+}
+
+func TestModerateOverREST(t *testing.T) {
+
+	gin.SetMode(gin.TestMode)
+
+	roomService := services.NewMemoryRoomService()
+	sessionService := services.NewSessionService([]byte("test secret"), time.Hour)
+	hub := newTestHub(t, roomService, nil, time.Minute, SlowClientResync)
+
+	rc := controllers.NewRoomController(roomService, sessionService, hub, false)
+	router := gin.New()
+	router.POST("/api/room/join", rc.JoinRoom)
+	router.POST("/api/room/:uri/moderate", rc.Moderate)
+
+	dbRoom := createTestRoom(t, roomService, "ann", "bob", "cat")
+	room := hub.GetOrCreateRoom(dbRoom)
+	bob := connect(t, room, "bob")
+	cat := connect(t, room, "cat")
+
+	request := func(user string, path string, body any) int {
+
+		t.Helper()
+
+		data, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		request := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(data))
+		request.Header.Set("Content-Type", "application/json")
+
+		if user != "" {
+			session, err := sessionService.Sign(&models.JoinRoomInput{User: user, Uri: dbRoom.Uri})
+			if err != nil {
+				t.Fatal(err)
+			}
+			request.AddCookie(&http.Cookie{Name: controllers.SessionCookie, Value: session})
+		}
+
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, request)
+
+		return recorder.Code
+	}
+	moderate := "/api/room/" + dbRoom.Uri + "/moderate"
+
+	tests := []struct {
+		name  string
+		user  string
+		input models.ModerateRoomInput
+		want  int
+	}{
+		{"kick without a session", "", models.ModerateRoomInput{Action: KickAction, Name: "cat"}, http.StatusUnauthorized},
+		{"kick by a player", "bob", models.ModerateRoomInput{Action: KickAction, Name: "cat"}, http.StatusForbidden},
+		{"lock by a player", "bob", models.ModerateRoomInput{Action: LockRoomAction}, http.StatusForbidden},
+		{"close by a player", "bob", models.ModerateRoomInput{Action: CloseRoomAction}, http.StatusForbidden},
+		{"unknown command", "ann", models.ModerateRoomInput{Action: "deal"}, http.StatusBadRequest},
+		{"kick", "ann", models.ModerateRoomInput{Action: KickAction, Name: "cat", Reason: "slow play"}, http.StatusOK},
+		{"lock", "ann", models.ModerateRoomInput{Action: LockRoomAction}, http.StatusOK},
+	}
+
+	for _, test := range tests {
+		if code := request(test.user, moderate, test.input); code != test.want {
+			t.Errorf("%v answered %v, want %v", test.name, code, test.want)
+		}
+	}
+
+	// The players at the table saw the kick live
+	if update := expect(t, bob, UpdateRoomAction); update.Name != "cat" || update.Reason != "slow play" {
+		t.Errorf("got kick of %v for %q, want cat for slow play", update.Name, update.Reason)
+	}
+	if !isClosed(cat.send) {
+		t.Error("cat is still connected once kicked")
+	}
+	if entry := kickEntry(t, roomService, dbRoom.Uri, "cat"); entry.Reason != "slow play" {
+		t.Errorf("kick entry has reason %q, want slow play", entry.Reason)
+	}
+
+	join := "/api/room/join"
+	if code := request("", join, &models.JoinRoomInput{User: "cat", Uri: dbRoom.Uri}); code != http.StatusForbidden {
+		t.Errorf("join of a kicked player answered %v, want %v", code, http.StatusForbidden)
+	}
+	if code := request("", join, &models.JoinRoomInput{User: "dan", Uri: dbRoom.Uri}); code != http.StatusForbidden {
+		t.Errorf("join of a locked room answered %v, want %v", code, http.StatusForbidden)
+	}
+
+	if code := request("ann", moderate, &models.ModerateRoomInput{Action: CloseRoomAction}); code != http.StatusOK {
+		t.Errorf("close answered %v, want %v", code, http.StatusOK)
+	}
+	if !isClosed(bob.send) {
+		t.Error("bob is still connected to the closed room")
+	}
+	if code := request("", join, &models.JoinRoomInput{User: "dan", Uri: dbRoom.Uri}); code != http.StatusForbidden {
+		t.Errorf("join of a closed room answered %v, want %v", code, http.StatusForbidden)
+	}
+}
@@ -0,0 +1,205 @@
+package hub
+
+import (
+	"github.com/dchest/uniuri"
+	"go-pokerchips/models"
+	"log"
+	"time"
+)
+
+// One of the instances that have a room loaded owns it, the first to take the lease on it. Only the
+// owner changes the room: it applies the actions of every player, times the turns and runs the
+// tournament clock. The other instances forward the actions of their players to it and follow the
+// room through what it publishes. When the owner stops renewing its lease, because it shut down or
+// lost Redis, the next instance to try takes the room over.
+const (
+	ownerLease = 10 * time.Second
+
+	// How long an instance waits for the owner to answer an action it forwarded
+	forwardTimeout = 10 * time.Second
+)
+
+var errNoOwner = newError(CodeInternal, "the room did not answer, please try again")
+
+// forwardedRequest is an action passed on to the owner of the room, from a player or a host command over REST.
+type forwardedRequest struct {
+	Id         string                    `json:"id"`
+	Player     string                    `json:"player"`
+	Message    *Message                  `json:"message,omitempty"`
+	Moderation *models.ModerateRoomInput `json:"moderation,omitempty"`
+}
+
+// forwardedReply is the answer of the owner to a forwarded request, for the instance it came from.
+// It has no message when there is nothing to tell the player.
+type forwardedReply struct {
+	Id       string   `json:"id"`
+	Instance string   `json:"instance"`
+	Message  *Message `json:"message,omitempty"`
+}
+
+// pendingRequest is a forwarded request waiting for the answer of the owner.
+type pendingRequest struct {
+	name      string
+	requestId string
+	sent      time.Time
+	answer    func(reply *Message)
+}
+
+func (room *Room) ownerKey() string {
+	return room.channel() + ":owner"
+}
+
+// isOwner reports whether this instance owns the room, an instance on its own always does.
+func (room *Room) isOwner() bool {
+	return room.hub.pubsub == nil || time.Now().Before(room.ownedUntil)
+}
+
+// claim takes the lease on the room, or renews it. The instance only counts itself the owner for half
+// of the lease, so it has stopped changing the room well before another instance can take it.
+func (room *Room) claim() {
+
+	if room.hub.pubsub == nil {
+		return
+	}
+
+	start := time.Now()
+	held, err := room.hub.pubsub.Lease(room.ownerKey(), room.hub.instance, ownerLease)
+	if err != nil {
+		log.Printf("Could not lease room %v: %v\n", room.Uri, err)
+		return
+	}
+
+	if !held {
+		room.ownedUntil = time.Time{}
+		return
+	}
+
+	owner := room.isOwner()
+	room.ownedUntil = start.Add(ownerLease / 2)
+
+	if !owner {
+		log.Printf("Instance %v owns room %v\n", room.hub.instance, room.Uri)
+		room.takeOver()
+	}
+}
+
+// takeOver carries on with the turn the previous owner was timing, if it was.
+func (room *Room) takeOver() {
+
+	if room.clock == nil && !room.skipAbsent() {
+		room.startClock()
+	}
+}
+
+// release gives up the lease of a room that shuts down, so another instance takes it over right away.
+func (room *Room) release() {
+
+	if room.hub.pubsub == nil || !room.isOwner() {
+		return
+	}
+
+	if err := room.hub.pubsub.Release(room.ownerKey(), room.hub.instance); err != nil {
+		log.Printf("Could not release room %v: %v\n", room.Uri, err)
+	}
+	room.ownedUntil = time.Time{}
+}
+
+// isLocalAction reports whether every instance handles the action for its own players, the other
+// actions change the room and are applied by its owner.
+func isLocalAction(action string) bool {
+
+	switch action {
+	case SendMessageAction, LeaveRoomAction, ResumeAction:
+		return true
+	}
+
+	return false
+}
+
+// forward passes an action of a player connected here on to the owner of the room. The player gets
+// the reply once it comes back.
+func (room *Room) forward(client *Client, message Message) {
+
+	room.forwardRequest(&forwardedRequest{Player: client.name, Message: &message}, message.RequestId, func(reply *Message) {
+		if reply == nil {
+			return
+		}
+
+		// What the owner published for the action came in before its reply, so the sequence of this
+		// instance acknowledges it
+		answer := *reply
+		if answer.Action == AckAction {
+			answer.Sequence = room.sequence
+			answer.Epoch = room.epoch
+		}
+		client.sendMessage(&answer)
+	})
+}
+
+// forwardRequest publishes the request for the owner and keeps what to do with its reply.
+// The request id is the one of the player, for the reply sent if the owner never answers.
+func (room *Room) forwardRequest(request *forwardedRequest, requestId string, answer func(reply *Message)) {
+
+	request.Id = uniuri.New()
+	room.forwarded[request.Id] = &pendingRequest{request.Player, requestId, time.Now(), answer}
+
+	room.publish(&remoteEvent{Request: request})
+}
+
+// handleForwarded applies a request forwarded by another instance and sends the reply back to it.
+func (room *Room) handleForwarded(instance string, request *forwardedRequest) {
+
+	if !room.isOwner() {
+		return
+	}
+
+	var reply *Message
+	switch {
+	case request.Moderation != nil:
+		reply = room.replyMessage(request.Player, "", room.moderate(request.Player, request.Moderation))
+	case request.Message != nil:
+		// The player is connected to the other instance, nothing is sent to them from here
+		player := &Client{name: request.Player, hub: room.hub, room: room}
+		reply, _ = room.answer(player, *request.Message, nil)
+	}
+
+	room.publish(&remoteEvent{Reply: &forwardedReply{Id: request.Id, Instance: instance, Message: reply}})
+}
+
+// handleReply hands the reply of the owner to whoever forwarded the request from here.
+func (room *Room) handleReply(reply *forwardedReply) {
+
+	if reply.Instance != room.hub.instance {
+		return
+	}
+
+	pending, ok := room.forwarded[reply.Id]
+	if !ok {
+		return
+	}
+
+	delete(room.forwarded, reply.Id)
+	pending.answer(reply.Message)
+}
+
+// expireForwarded gives up on the requests forwarded before the given time, the owner they were sent
+// to may be gone. The players are told to try again.
+func (room *Room) expireForwarded(before time.Time) {
+
+	for id, pending := range room.forwarded {
+		if pending.sent.Before(before) {
+			delete(room.forwarded, id)
+			pending.answer(room.replyMessage(pending.name, pending.requestId, errNoOwner))
+		}
+	}
+}
+
+// replyError is the error a reply tells of, nil when the action was applied.
+func replyError(reply *Message) error {
+
+	if reply == nil || (reply.Action != NackAction && reply.Action != ErrorAction) {
+		return nil
+	}
+
+	return newError(reply.Code, reply.Message)
+}
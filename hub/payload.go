@@ -0,0 +1,330 @@
+package hub
+
+import "go-pokerchips/models"
+
+// Payloads of the messages a client sends with protocol version 2. Each one fills in the fields
+// of the Message the room goroutine works with.
+type clientPayload interface {
+	apply(message *Message)
+}
+
+// EmptyPayload is the payload of the actions that need nothing else than their type.
+type EmptyPayload struct{}
+
+// ChatPayload is a chat message sent to the room.
+type ChatPayload struct {
+	Message string `json:"message"`
+}
+
+// AddPotPayload bets Amount chips outside of a hand.
+type AddPotPayload struct {
+	Amount int `json:"amount"`
+}
+
+// TakePotPayload takes Amount chips from the pot for the host, outside of a hand.
+type TakePotPayload struct {
+	Amount int `json:"amount"`
+}
+
+// RaisePayload raises the bet of the street to a total of Amount.
+type RaisePayload struct {
+	Amount int `json:"amount"`
+}
+
+// AwardPotPayload declares the winners of the pot at PotIndex.
+type AwardPotPayload struct {
+	PotIndex int      `json:"potIndex"`
+	Winners  []string `json:"winners"`
+}
+
+// SetBlindsPayload sets the blinds and ante of the next hands.
+type SetBlindsPayload struct {
+	Blinds models.Blinds `json:"blinds"`
+}
+
+// KickPayload sends a player out of the room.
+type KickPayload struct {
+	Name   string `json:"name"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// TransferHostPayload makes another player the host of the room.
+type TransferHostPayload struct {
+	Name string `json:"name"`
+}
+
+// AdjustStackPayload adds Amount chips to the stack of a player, or takes them when negative.
+type AdjustStackPayload struct {
+	Name   string `json:"name"`
+	Amount int    `json:"amount"`
+	Reason string `json:"reason"`
+}
+
+// TakeSeatPayload sits the player down at Seat, or moves them there.
+type TakeSeatPayload struct {
+	Seat int `json:"seat"`
+}
+
+// SwapSeatsPayload moves the players of Seat and OtherSeat to each other's seat.
+type SwapSeatsPayload struct {
+	Seat      int `json:"seat"`
+	OtherSeat int `json:"otherSeat"`
+}
+
+// ResumePayload asks for the messages broadcast after Sequence in Epoch.
+type ResumePayload struct {
+	Sequence int64  `json:"sequence"`
+	Epoch    string `json:"epoch"`
+}
+
+func (p *EmptyPayload) apply(message *Message) {}
+
+func (p *ChatPayload) apply(message *Message) {
+	message.Message = p.Message
+}
+
+func (p *AddPotPayload) apply(message *Message) {
+	message.Pot = p.Amount
+}
+
+func (p *TakePotPayload) apply(message *Message) {
+	message.Pot = p.Amount
+}
+
+func (p *RaisePayload) apply(message *Message) {
+	message.Amount = p.Amount
+}
+
+func (p *AwardPotPayload) apply(message *Message) {
+	message.PotIndex = p.PotIndex
+	message.Winners = p.Winners
+}
+
+func (p *SetBlindsPayload) apply(message *Message) {
+	message.Blinds = &p.Blinds
+}
+
+func (p *KickPayload) apply(message *Message) {
+	message.Name = p.Name
+	message.Reason = p.Reason
+}
+
+func (p *TransferHostPayload) apply(message *Message) {
+	message.Name = p.Name
+}
+
+func (p *AdjustStackPayload) apply(message *Message) {
+	message.Name = p.Name
+	message.Amount = p.Amount
+	message.Reason = p.Reason
+}
+
+func (p *TakeSeatPayload) apply(message *Message) {
+	message.Seat = p.Seat
+}
+
+func (p *SwapSeatsPayload) apply(message *Message) {
+	message.Seat = p.Seat
+	message.OtherSeat = p.OtherSeat
+}
+
+func (p *ResumePayload) apply(message *Message) {
+	message.Sequence = p.Sequence
+	message.Epoch = p.Epoch
+}
+
+// Payloads of the messages the server sends with protocol version 2.
+
+// JoinedPayload tells a client it is connected to the room as Name.
+type JoinedPayload struct {
+	Name string       `json:"name"`
+	Pot  int          `json:"pot"`
+	Pots []models.Pot `json:"pots"`
+}
+
+// ChatMessagePayload is a chat message, or a player joining or leaving the room.
+type ChatMessagePayload struct {
+	Sender  string `json:"sender"`
+	Message string `json:"message"`
+}
+
+// ErrorPayload is why an action was refused.
+type ErrorPayload struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// PotUpdatePayload is a bet of Amount chips outside of a hand, or Amount chips the host took from the pot.
+// Pots is empty, both leave a single undivided pot.
+type PotUpdatePayload struct {
+	Sender       string       `json:"sender"`
+	Message      string       `json:"message"`
+	Amount       int          `json:"amount"`
+	Pot          int          `json:"pot"`
+	Pots         []models.Pot `json:"pots"`
+	CurrentChips int          `json:"currentChips"`
+}
+
+// HandUpdatePayload is a hand that started or a move in it.
+type HandUpdatePayload struct {
+	Sender       string       `json:"sender"`
+	Message      string       `json:"message"`
+	Pot          int          `json:"pot"`
+	Pots         []models.Pot `json:"pots"`
+	CurrentChips int          `json:"currentChips"`
+	Hand         *HandState   `json:"hand"`
+}
+
+// PotAwardedPayload is what each winner of a pot got.
+type PotAwardedPayload struct {
+	Sender  string         `json:"sender"`
+	Message string         `json:"message"`
+	Pot     int            `json:"pot"`
+	Pots    []models.Pot   `json:"pots"`
+	Payouts map[string]int `json:"payouts"`
+}
+
+// BlindsUpdatePayload is the blinds and ante of the next hands.
+type BlindsUpdatePayload struct {
+	Sender  string        `json:"sender"`
+	Message string        `json:"message"`
+	Blinds  models.Blinds `json:"blinds"`
+}
+
+// TournamentUpdatePayload is the tournament clock and standings. Sender is empty when the clock moved on its own.
+type TournamentUpdatePayload struct {
+	Sender     string             `json:"sender"`
+	Message    string             `json:"message"`
+	Blinds     models.Blinds      `json:"blinds"`
+	Tournament *models.Tournament `json:"tournament"`
+}
+
+// RoomUpdatePayload is a host command. Stack is the new stack of the player after a stack adjustment,
+// Seats the taken seats after a swap.
+type RoomUpdatePayload struct {
+	Sender    string        `json:"sender"`
+	Message   string        `json:"message"`
+	Name      string        `json:"name,omitempty"`
+	Amount    int           `json:"amount,omitempty"`
+	Stack     int           `json:"stack,omitempty"`
+	Reason    string        `json:"reason,omitempty"`
+	Seat      int           `json:"seat,omitempty"`
+	OtherSeat int           `json:"otherSeat,omitempty"`
+	Seats     []models.Seat `json:"seats,omitempty"`
+}
+
+// SeatsUpdatePayload is a player taking a seat, standing up, sitting out or in, or going away or coming back,
+// with the taken seats after it.
+type SeatsUpdatePayload struct {
+	Sender  string        `json:"sender"`
+	Message string        `json:"message"`
+	Seats   []models.Seat `json:"seats"`
+}
+
+// ActionClockPayload is the time the player to act has left. Sender is empty when the clock moved on its own.
+type ActionClockPayload struct {
+	Sender  string       `json:"sender"`
+	Message string       `json:"message"`
+	Clock   *ActionClock `json:"clock"`
+}
+
+func joinedPayload(message *Message) any {
+	return &JoinedPayload{Name: message.Sender, Pot: message.Pot, Pots: message.Pots}
+}
+
+func chatMessagePayload(message *Message) any {
+	return &ChatMessagePayload{Sender: message.Sender, Message: message.Message}
+}
+
+func errorPayload(message *Message) any {
+	return &ErrorPayload{Code: message.Code, Message: message.Message}
+}
+
+func emptyPayload(message *Message) any {
+	return &EmptyPayload{}
+}
+
+func roomStatePayload(message *Message) any {
+	return message.State
+}
+
+func potUpdatePayload(message *Message) any {
+
+	// The empty pots are left out of the messages that came from another instance
+	pots := message.Pots
+	if pots == nil {
+		pots = []models.Pot{}
+	}
+
+	return &PotUpdatePayload{
+		Sender:       message.Sender,
+		Message:      message.Message,
+		Amount:       message.Amount,
+		Pot:          message.Pot,
+		Pots:         pots,
+		CurrentChips: message.CurrentChips,
+	}
+}
+
+func handUpdatePayload(message *Message) any {
+	return &HandUpdatePayload{
+		Sender:       message.Sender,
+		Message:      message.Message,
+		Pot:          message.Pot,
+		Pots:         message.Pots,
+		CurrentChips: message.CurrentChips,
+		Hand:         message.Hand,
+	}
+}
+
+func potAwardedPayload(message *Message) any {
+	return &PotAwardedPayload{
+		Sender:  message.Sender,
+		Message: message.Message,
+		Pot:     message.Pot,
+		Pots:    message.Pots,
+		Payouts: message.Payouts,
+	}
+}
+
+func blindsUpdatePayload(message *Message) any {
+
+	payload := &BlindsUpdatePayload{Sender: message.Sender, Message: message.Message}
+	if message.Blinds != nil {
+		payload.Blinds = *message.Blinds
+	}
+
+	return payload
+}
+
+func tournamentUpdatePayload(message *Message) any {
+
+	payload := &TournamentUpdatePayload{Sender: message.Sender, Message: message.Message, Tournament: message.Tournament}
+	if message.Blinds != nil {
+		payload.Blinds = *message.Blinds
+	}
+
+	return payload
+}
+
+func roomUpdatePayload(message *Message) any {
+	return &RoomUpdatePayload{
+		Sender:    message.Sender,
+		Message:   message.Message,
+		Name:      message.Name,
+		Amount:    message.Amount,
+		Stack:     message.CurrentChips,
+		Reason:    message.Reason,
+		Seat:      message.Seat,
+		OtherSeat: message.OtherSeat,
+		Seats:     message.Seats,
+	}
+}
+
+func actionClockPayload(message *Message) any {
+	return &ActionClockPayload{Sender: message.Sender, Message: message.Message, Clock: message.Clock}
+}
+
+func seatsUpdatePayload(message *Message) any {
+	return &SeatsUpdatePayload{Sender: message.Sender, Message: message.Message, Seats: message.Seats}
+}
@@ -0,0 +1,192 @@
+package hub
+
+import (
+	"encoding/json"
+	"github.com/gorilla/websocket"
+	"log"
+	"net/http"
+)
+
+// Versions of the websocket protocol. Version 1 is the flat Message, version 2 wraps a payload
+// of its own type for every action in an Envelope. Clients pick one with the Sec-WebSocket-Protocol
+// header when they connect, without it they get version 1.
+const (
+	ProtocolV1      = 1
+	ProtocolV2      = 2
+	ProtocolVersion = ProtocolV2
+)
+
+// Subprotocols maps the names clients offer when they connect to the protocol version.
+var Subprotocols = map[string]int{
+	"pokerchips.v1": ProtocolV1,
+	"pokerchips.v2": ProtocolV2,
+}
+
+var (
+	errUnsupportedProtocol = newError(CodeInvalidRequest, "none of the offered protocols is supported, use pokerchips.v1 or pokerchips.v2")
+	errInvalidEnvelope     = newError(CodeInvalidRequest, "the message is not a valid envelope")
+	errProtocolVersion     = newError(CodeInvalidRequest, "the version of the message is not the one negotiated on connect")
+	errUnknownType         = newError(CodeInvalidRequest, "unknown message type")
+	errInvalidPayload      = newError(CodeInvalidRequest, "the payload does not match the message type")
+	errInvalidMessage      = newError(CodeInvalidRequest, "the message is not valid JSON or its fields have the wrong type")
+)
+
+// Envelope is a message of protocol version 2. The type is one of the actions and decides the payload.
+type Envelope struct {
+	Type    string `json:"type"`
+	Version int    `json:"version"`
+
+	// Chosen by the client to be told with ack or nack whether the action was applied
+	RequestId string `json:"requestId,omitempty"`
+
+	// Set on every message broadcast to the room, increasing by one each time within the Epoch
+	Sequence int64  `json:"sequence,omitempty"`
+	Epoch    string `json:"epoch,omitempty"`
+
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+// Payload types of the messages clients send.
+var clientPayloads = map[string]func() clientPayload{
+	SendMessageAction:      func() clientPayload { return &ChatPayload{} },
+	LeaveRoomAction:        func() clientPayload { return &EmptyPayload{} },
+	ResumeAction:           func() clientPayload { return &ResumePayload{} },
+	AddPot:                 func() clientPayload { return &AddPotPayload{} },
+	TakePot:                func() clientPayload { return &TakePotPayload{} },
+	StartHandAction:        func() clientPayload { return &EmptyPayload{} },
+	CheckAction:            func() clientPayload { return &EmptyPayload{} },
+	CallAction:             func() clientPayload { return &EmptyPayload{} },
+	RaiseAction:            func() clientPayload { return &RaisePayload{} },
+	FoldAction:             func() clientPayload { return &EmptyPayload{} },
+	AllInAction:            func() clientPayload { return &EmptyPayload{} },
+	SetBlindsAction:        func() clientPayload { return &SetBlindsPayload{} },
+	StartTournamentAction:  func() clientPayload { return &EmptyPayload{} },
+	PauseTournamentAction:  func() clientPayload { return &EmptyPayload{} },
+	ResumeTournamentAction: func() clientPayload { return &EmptyPayload{} },
+	KickAction:             func() clientPayload { return &KickPayload{} },
+	TransferHostAction:     func() clientPayload { return &TransferHostPayload{} },
+	AdjustStackAction:      func() clientPayload { return &AdjustStackPayload{} },
+	LockRoomAction:         func() clientPayload { return &EmptyPayload{} },
+	UnlockRoomAction:       func() clientPayload { return &EmptyPayload{} },
+	CloseRoomAction:        func() clientPayload { return &EmptyPayload{} },
+	SwapSeatsAction:        func() clientPayload { return &SwapSeatsPayload{} },
+	TakeSeatAction:         func() clientPayload { return &TakeSeatPayload{} },
+	StandUpAction:          func() clientPayload { return &EmptyPayload{} },
+	SitOutAction:           func() clientPayload { return &EmptyPayload{} },
+	SitInAction:            func() clientPayload { return &EmptyPayload{} },
+	TimeBankAction:         func() clientPayload { return &EmptyPayload{} },
+	AwardPotAction:         func() clientPayload { return &AwardPotPayload{} },
+}
+
+// Payloads of the messages the server sends, built from the Message.
+var serverPayloads = map[string]func(message *Message) any{
+	JoinRoomAction:         joinedPayload,
+	SendMessageAction:      chatMessagePayload,
+	ErrorAction:            errorPayload,
+	RoomStateAction:        roomStatePayload,
+	AckAction:              emptyPayload,
+	NackAction:             errorPayload,
+	UpdatePot:              potUpdatePayload,
+	UpdateHandAction:       handUpdatePayload,
+	UpdateBlindsAction:     blindsUpdatePayload,
+	UpdateTournamentAction: tournamentUpdatePayload,
+	UpdateRoomAction:       roomUpdatePayload,
+	UpdateSeatsAction:      seatsUpdatePayload,
+	ActionClockAction:      actionClockPayload,
+	PotAwardedAction:       potAwardedPayload,
+}
+
+// negotiateProtocol picks the first protocol the client offers that the server supports.
+// The name is empty when the client offered none.
+func negotiateProtocol(r *http.Request) (string, int, error) {
+
+	offered := websocket.Subprotocols(r)
+	if len(offered) == 0 {
+		return "", ProtocolV1, nil
+	}
+
+	for _, name := range offered {
+		if version, ok := Subprotocols[name]; ok {
+			return name, version, nil
+		}
+	}
+
+	return "", 0, errUnsupportedProtocol
+}
+
+// decodeEnvelope reads a message of protocol version 2 into the Message handled by the room.
+func decodeEnvelope(data []byte) (Message, error) {
+
+	var envelope Envelope
+	if err := json.Unmarshal(data, &envelope); err != nil {
+		return Message{}, errInvalidEnvelope
+	}
+
+	message := Message{Action: envelope.Type, RequestId: envelope.RequestId}
+
+	if envelope.Version != ProtocolV2 {
+		return message, errProtocolVersion
+	}
+
+	newPayload, ok := clientPayloads[envelope.Type]
+	if !ok {
+		return message, errUnknownType
+	}
+
+	payload := newPayload()
+	if len(envelope.Payload) > 0 {
+		if err := json.Unmarshal(envelope.Payload, payload); err != nil {
+			return message, errInvalidPayload
+		}
+	}
+	payload.apply(&message)
+
+	return message, nil
+}
+
+// encodeFor encodes the message for a client of the given protocol version.
+func (message *Message) encodeFor(version int) []byte {
+
+	if version == ProtocolV1 {
+		return message.encode()
+	}
+
+	envelope := Envelope{
+		Type:      message.Action,
+		Version:   ProtocolV2,
+		RequestId: message.RequestId,
+		Sequence:  message.Sequence,
+		Epoch:     message.Epoch,
+	}
+
+	if newPayload, ok := serverPayloads[message.Action]; ok {
+		payload, err := json.Marshal(newPayload(message))
+		if err != nil {
+			log.Println(err)
+		}
+		envelope.Payload = payload
+	} else {
+		log.Printf("No payload type for message %v\n", message.Action)
+	}
+
+	data, err := json.Marshal(envelope)
+	if err != nil {
+		log.Println(err)
+	}
+
+	return data
+}
+
+// frames is a message encoded for every protocol version, so a broadcast is encoded once per version
+// and not once per client.
+type frames map[int][]byte
+
+func (message *Message) frames() frames {
+
+	encoded := make(frames, len(Subprotocols))
+	for _, version := range Subprotocols {
+		encoded[version] = message.encodeFor(version)
+	}
+
+	return encoded
+}
@@ -0,0 +1,70 @@
+package hub
+
+import (
+	"encoding/json"
+	"go-pokerchips/services"
+	"testing"
+	"time"
+)
+
+// connectV2 registers a client of protocol version 2 without a websocket, see connect.
+func connectV2(t *testing.T, room *Room, name string) *Client {
+
+	t.Helper()
+
+	client := newClient(nil, room.hub, room, name, ProtocolV2)
+	if !deliver(room, room.register, client) {
+		t.Fatalf("room %v stopped before %v connected", room.Uri, name)
+	}
+
+	return client
+}
+
+// expectEnvelope skips the envelopes sent to a client of protocol version 2 until one of the type.
+func expectEnvelope(t *testing.T, client *Client, messageType string) Envelope {
+
+	t.Helper()
+
+	for {
+		select {
+		case data, ok := <-client.send:
+			if !ok {
+				t.Fatalf("%v was disconnected", client.name)
+			}
+			var envelope Envelope
+			if err := json.Unmarshal(data, &envelope); err != nil {
+				t.Fatal(err)
+			}
+			if envelope.Type == messageType {
+				return envelope
+			}
+		case <-time.After(testTimeout):
+			t.Fatalf("%v got no %v", client.name, messageType)
+		}
+	}
+}
+
+func TestPotUpdateCarriesPots(t *testing.T) {
+
+	roomService := services.NewMemoryRoomService()
+	hub := newTestHub(t, roomService, nil, time.Minute, SlowClientResync)
+	dbRoom := createTestRoom(t, roomService, "ann", "bob")
+	room := hub.GetOrCreateRoom(dbRoom)
+
+	ann := connect(t, room, "ann")
+	bob := connectV2(t, room, "bob")
+
+	send(ann, Message{Action: AddPot, Pot: 10})
+
+	var update PotUpdatePayload
+	if err := json.Unmarshal(expectEnvelope(t, bob, UpdatePot).Payload, &update); err != nil {
+		t.Fatal(err)
+	}
+	if update.Pot != 10 || update.Amount != 10 || update.Pots == nil || len(update.Pots) != 0 {
+		t.Errorf("got pot %v, amount %v and pots %v, want 10, 10 and no side pots", update.Pot, update.Amount, update.Pots)
+	}
+
+	if message := expect(t, ann, UpdatePot); message.Pot != 10 || len(message.Pots) != 0 {
+		t.Errorf("got pot %v and pots %v, want 10 and no side pots", message.Pot, message.Pots)
+	}
+}
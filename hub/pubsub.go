@@ -0,0 +1,140 @@
+package hub
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// PubSub carries the room broadcasts between the instances of the server, so players connected
+// to different instances see each other. Each room has a channel of its own.
+// It also leases each room to one of the instances, the only one to change it, see owner.go.
+type PubSub interface {
+	Publish(channel string, data []byte) error
+	Subscribe(channel string) (Subscription, error)
+
+	// Lease takes the lease on key for the instance, or renews it when the instance holds it already, and
+	// reports whether the instance holds it. The lease runs out after ttl unless it is renewed.
+	Lease(key string, instance string, ttl time.Duration) (bool, error)
+
+	// Release gives up the lease on key if the instance holds it.
+	Release(key string, instance string) error
+}
+
+// Subscription receives what is published on a channel until it is closed.
+type Subscription interface {
+	Messages() <-chan []byte
+	Close() error
+}
+
+// Most messages a subscriber of the in-process pub/sub can fall behind by before they are dropped
+const memorySubscriptionBuffer = 256
+
+// MemoryPubSub is a pub/sub within a single process, it lets several hubs share rooms in one process.
+type MemoryPubSub struct {
+	mu          sync.Mutex
+	subscribers map[string]map[*memorySubscription]bool
+	leases      map[string]memoryLease
+}
+
+type memoryLease struct {
+	instance string
+	expires  time.Time
+}
+
+type memorySubscription struct {
+	pubsub   *MemoryPubSub
+	channel  string
+	messages chan []byte
+}
+
+func NewMemoryPubSub() *MemoryPubSub {
+	return &MemoryPubSub{
+		subscribers: make(map[string]map[*memorySubscription]bool),
+		leases:      make(map[string]memoryLease),
+	}
+}
+
+// Publish never blocks, like Redis it drops the messages of subscribers that do not keep up.
+func (ps *MemoryPubSub) Publish(channel string, data []byte) error {
+
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	for subscription := range ps.subscribers[channel] {
+		select {
+		case subscription.messages <- append([]byte{}, data...):
+		default:
+			log.Printf("Dropped a message on %v for a slow subscriber\n", channel)
+		}
+	}
+
+	return nil
+}
+
+func (ps *MemoryPubSub) Subscribe(channel string) (Subscription, error) {
+
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	subscription := &memorySubscription{
+		pubsub:   ps,
+		channel:  channel,
+		messages: make(chan []byte, memorySubscriptionBuffer),
+	}
+
+	if ps.subscribers[channel] == nil {
+		ps.subscribers[channel] = make(map[*memorySubscription]bool)
+	}
+	ps.subscribers[channel][subscription] = true
+
+	return subscription, nil
+}
+
+func (ps *MemoryPubSub) Lease(key string, instance string, ttl time.Duration) (bool, error) {
+
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	if lease, ok := ps.leases[key]; ok && lease.instance != instance && time.Now().Before(lease.expires) {
+		return false, nil
+	}
+
+	ps.leases[key] = memoryLease{instance, time.Now().Add(ttl)}
+
+	return true, nil
+}
+
+func (ps *MemoryPubSub) Release(key string, instance string) error {
+
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	if lease, ok := ps.leases[key]; ok && lease.instance == instance {
+		delete(ps.leases, key)
+	}
+
+	return nil
+}
+
+func (subscription *memorySubscription) Messages() <-chan []byte {
+	return subscription.messages
+}
+
+func (subscription *memorySubscription) Close() error {
+
+	ps := subscription.pubsub
+
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	if _, ok := ps.subscribers[subscription.channel][subscription]; ok {
+		delete(ps.subscribers[subscription.channel], subscription)
+		if len(ps.subscribers[subscription.channel]) == 0 {
+			delete(ps.subscribers, subscription.channel)
+		}
+		close(subscription.messages)
+	}
+
+	return nil
+}
@@ -0,0 +1,98 @@
+package hub
+
+import (
+	"context"
+	"github.com/redis/go-redis/v9"
+	"time"
+)
+
+// The lease is taken with the key set to the instance holding it. Only that instance renews or deletes
+// it, which has to be checked in the same step as the change.
+var (
+	leaseScript = redis.NewScript(`
+local holder = redis.call("GET", KEYS[1])
+if holder == ARGV[1] then
+	redis.call("PEXPIRE", KEYS[1], ARGV[2])
+	return 1
+end
+if not holder then
+	redis.call("SET", KEYS[1], ARGV[1], "PX", ARGV[2])
+	return 1
+end
+return 0
+`)
+
+	releaseScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+)
+
+// RedisPubSub shares the room broadcasts between instances through Redis, and leases the rooms with Redis keys.
+type RedisPubSub struct {
+	client *redis.Client
+}
+
+type redisSubscription struct {
+	pubsub   *redis.PubSub
+	messages chan []byte
+}
+
+func NewRedisPubSub(client *redis.Client) *RedisPubSub {
+	return &RedisPubSub{client}
+}
+
+func (ps *RedisPubSub) Publish(channel string, data []byte) error {
+	return ps.client.Publish(context.Background(), channel, data).Err()
+}
+
+// Subscribe returns once Redis confirmed the subscription, so nothing published afterwards is missed.
+func (ps *RedisPubSub) Subscribe(channel string) (Subscription, error) {
+
+	ctx := context.Background()
+
+	pubsub := ps.client.Subscribe(ctx, channel)
+	if _, err := pubsub.Receive(ctx); err != nil {
+		pubsub.Close()
+		return nil, err
+	}
+
+	subscription := &redisSubscription{
+		pubsub:   pubsub,
+		messages: make(chan []byte),
+	}
+
+	// The channel of go-redis is closed when the subscription is
+	go func() {
+		defer close(subscription.messages)
+		for message := range pubsub.Channel() {
+			subscription.messages <- []byte(message.Payload)
+		}
+	}()
+
+	return subscription, nil
+}
+
+func (ps *RedisPubSub) Lease(key string, instance string, ttl time.Duration) (bool, error) {
+
+	held, err := leaseScript.Run(context.Background(), ps.client, []string{key}, instance, ttl.Milliseconds()).Int()
+	if err != nil {
+		return false, err
+	}
+
+	return held == 1, nil
+}
+
+func (ps *RedisPubSub) Release(key string, instance string) error {
+	return releaseScript.Run(context.Background(), ps.client, []string{key}, instance).Err()
+}
+
+func (subscription *redisSubscription) Messages() <-chan []byte {
+	return subscription.messages
+}
+
+func (subscription *redisSubscription) Close() error {
+	return subscription.pubsub.Close()
+}
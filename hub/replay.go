@@ -0,0 +1,49 @@
+package hub
+
+import "log"
+
+// Most broadcast messages a room keeps for clients that resume
+const eventBufferSize = 256
+
+var errResumeFailed = newError(CodeInternal, "could not resume, please reconnect")
+
+// event is a broadcast message as it was sent.
+type event struct {
+	sequence int64
+	message  frames
+}
+
+func (room *Room) remember(sequence int64, message frames) {
+
+	room.events = append(room.events, event{sequence, message})
+
+	if len(room.events) > eventBufferSize {
+		room.events = append([]event{}, room.events[len(room.events)-eventBufferSize:]...)
+	}
+}
+
+// resume sends a client the messages broadcast after the last one it saw. When some of them are
+// no longer kept, or the sequence counts in another epoch because the room was reloaded since or the
+// client saw it on another instance, it gets a room-state snapshot instead.
+func (room *Room) resume(client *Client, epoch string, lastSeen int64) error {
+
+	if epoch == room.epoch && lastSeen == room.sequence {
+		return nil
+	}
+
+	if epoch == room.epoch && lastSeen < room.sequence && len(room.events) > 0 && room.events[0].sequence <= lastSeen+1 {
+		for _, e := range room.events {
+			if e.sequence > lastSeen {
+				room.sendTo(client, e.message[client.protocol])
+			}
+		}
+		return nil
+	}
+
+	if err := room.sendState(client); err != nil {
+		log.Printf("Could not take a snapshot of room %v: %v\n", room.Uri, err)
+		return errResumeFailed
+	}
+
+	return nil
+}
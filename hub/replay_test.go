@@ -0,0 +1,77 @@
+package hub
+
+import (
+	"encoding/json"
+	"go-pokerchips/models"
+	"go-pokerchips/services"
+	"testing"
+	"time"
+)
+
+// received decodes the messages sent to the client so far.
+func received(t *testing.T, client *Client) []Message {
+
+	t.Helper()
+
+	var messages []Message
+	for {
+		select {
+		case data := <-client.send:
+			var message Message
+			if err := json.Unmarshal(data, &message); err != nil {
+				t.Fatal(err)
+			}
+			messages = append(messages, message)
+		default:
+			return messages
+		}
+	}
+}
+
+func TestResumeInAnotherEpochGetsSnapshot(t *testing.T) {
+
+	roomService := services.NewMemoryRoomService()
+	dbRoom, err := roomService.CreateRoom(&models.CreateRoomInput{Creator: "ann"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	hub := NewHub(roomService, nil, time.Minute, SlowClientResync)
+
+	// The rooms are not run, the test calls them from its own goroutine
+	room := NewRoom(hub, dbRoom)
+	ann := newClient(nil, hub, room, "ann", ProtocolV1)
+	room.clients[ann] = true
+
+	room.broadcastClientsInRoom(&Message{Action: SendMessageAction, Message: "hello"})
+	seen := received(t, ann)[0]
+
+	// The room is reloaded and numbers its broadcasts from the start again
+	room = NewRoom(hub, dbRoom)
+	if room.epoch == seen.Epoch {
+		t.Fatal("the reloaded room has the epoch of the one before")
+	}
+
+	bob := newClient(nil, hub, room, "bob", ProtocolV1)
+	room.clients[bob] = true
+	for i := 0; i < 20; i++ {
+		room.broadcastClientsInRoom(&Message{Action: SendMessageAction, Message: "hello"})
+	}
+	received(t, bob)
+
+	room.resume(ann, seen.Epoch, seen.Sequence)
+
+	messages := received(t, ann)
+	if len(messages) != 1 || messages[0].Action != RoomStateAction {
+		t.Fatalf("got %v, want a room-state snapshot", messages)
+	}
+	if epoch := messages[0].State.Epoch; epoch != room.epoch {
+		t.Errorf("snapshot of epoch %v, want %v", epoch, room.epoch)
+	}
+
+	// In the same epoch the missed messages are replayed
+	room.resume(bob, room.epoch, room.sequence-5)
+
+	if replayed := len(received(t, bob)); replayed != 5 {
+		t.Errorf("replayed %v messages, want 5", replayed)
+	}
+}
@@ -0,0 +1,108 @@
+package hub
+
+// Most requests of a player whose replies a room keeps
+const requestLogSize = 64
+
+// requestLog keeps the replies to the last requests of each player, oldest first.
+type requestLog struct {
+	players map[string]*playerRequests
+}
+
+type playerRequests struct {
+	ids     []string
+	replies map[string]*Message
+}
+
+func newRequestLog() *requestLog {
+	return &requestLog{players: make(map[string]*playerRequests)}
+}
+
+// find returns the reply sent the first time a player made the request with the given id.
+func (rl *requestLog) find(name string, requestId string) (*Message, bool) {
+
+	if requestId == "" {
+		return nil, false
+	}
+
+	requests, ok := rl.players[name]
+	if !ok {
+		return nil, false
+	}
+
+	reply, ok := requests.replies[requestId]
+
+	return reply, ok
+}
+
+func (rl *requestLog) add(name string, requestId string, reply *Message) {
+
+	requests, ok := rl.players[name]
+	if !ok {
+		requests = &playerRequests{replies: make(map[string]*Message)}
+		rl.players[name] = requests
+	}
+
+	requests.ids = append(requests.ids, requestId)
+	requests.replies[requestId] = reply
+
+	if len(requests.ids) > requestLogSize {
+		delete(requests.replies, requests.ids[0])
+		requests.ids = append([]string{}, requests.ids[1:]...)
+	}
+}
+
+// answer applies an action of a player and returns the reply for them, see replyMessage, and whether
+// the action was applied now. A retried request gets the reply of the first attempt instead of being
+// applied twice. The player is in the room, or connected to another instance that forwarded the action.
+func (room *Room) answer(client *Client, message Message, err error) (*Message, bool) {
+
+	requestId := message.RequestId
+	if reply, ok := room.requests.find(client.name, requestId); ok {
+		return reply, false
+	}
+
+	// Some actions are broadcast as they came in, the request id is for the sender only
+	message.RequestId = ""
+
+	if err == nil {
+		err = room.applyAction(client, message)
+	}
+
+	reply := room.replyMessage(client.name, requestId, err)
+	if requestId != "" {
+		room.requests.add(client.name, requestId, reply)
+	}
+
+	return reply, err == nil
+}
+
+// replyMessage is the reply to an action for the player who sent it. An action with a request id is
+// acknowledged, or refused with the error. Without one, only an error is sent back.
+func (room *Room) replyMessage(name string, requestId string, err error) *Message {
+
+	if requestId == "" {
+		if err == nil {
+			return nil
+		}
+		return errorMessage(name, err)
+	}
+
+	message := &Message{
+		Action:    AckAction,
+		Pot:       room.Pot,
+		Sender:    name,
+		Sequence:  room.sequence,
+		Epoch:     room.epoch,
+		RequestId: requestId,
+	}
+
+	if err != nil {
+		message.Action = NackAction
+		message.Message = err.Error()
+		message.Code = errorCode(err)
+		message.Sequence = 0
+		message.Epoch = ""
+	}
+
+	return message
+}
@@ -0,0 +1,78 @@
+package hub
+
+import (
+	"go-pokerchips/services"
+	"testing"
+	"time"
+)
+
+func TestRetriedChatIsPostedOnce(t *testing.T) {
+
+	roomService := services.NewMemoryRoomService()
+	hub := newTestHub(t, roomService, nil, time.Minute, SlowClientResync)
+	room := hub.GetOrCreateRoom(createTestRoom(t, roomService, "ann", "bob"))
+
+	ann := connect(t, room, "ann")
+	expect(t, ann, SendMessageAction)
+
+	// A v1 client can claim to be anybody, the room knows better
+	for i := 0; i < 3; i++ {
+		send(ann, Message{Action: SendMessageAction, Message: "hello", Sender: "bob", RequestId: "chat-1"})
+	}
+	send(ann, Message{Action: LeaveRoomAction, RequestId: "leave-1"})
+
+	posted, acks := 0, 0
+	for message := next(t, ann); message.RequestId != "leave-1"; message = next(t, ann) {
+		switch message.Action {
+		case SendMessageAction:
+			posted++
+			if message.Sender != "ann" {
+				t.Errorf("chat sent as %v, want ann", message.Sender)
+			}
+		case AckAction:
+			acks++
+			if message.RequestId != "chat-1" {
+				t.Errorf("ack of %v, want chat-1", message.RequestId)
+			}
+		}
+	}
+
+	if posted != 1 || acks != 3 {
+		t.Errorf("chat posted %v times with %v acks, want once with 3 acks", posted, acks)
+	}
+}
+
+func TestUnreadableMessagesAreNacked(t *testing.T) {
+
+	roomService := services.NewMemoryRoomService()
+	hub := newTestHub(t, roomService, nil, time.Minute, SlowClientResync)
+	room := hub.GetOrCreateRoom(createTestRoom(t, roomService, "ann"))
+
+	ann := connect(t, room, "ann")
+	expect(t, ann, SendMessageAction)
+
+	tests := []struct {
+		name    string
+		message string
+		err     error
+	}{
+		{"wrong type", `{"action":"add-pot","pot":"ten","requestId":"bet"}`, errInvalidMessage},
+		{"unknown action", `{"action":"shuffle","requestId":"shuffle"}`, errUnknownAction},
+	}
+
+	for _, test := range tests {
+		ann.handleNewMessage([]byte(test.message))
+		if nack := expect(t, ann, NackAction); nack.Message != test.err.Error() || nack.Code != CodeInvalidRequest {
+			t.Errorf("%v: got nack %q with code %v, want %q", test.name, nack.Message, nack.Code, test.err)
+		}
+	}
+
+	// Without a request id nobody waits for an answer, so there is none
+	ann.handleNewMessage([]byte(`{"action":"shuffle"}`))
+	ann.handleNewMessage([]byte(`not json`))
+	send(ann, Message{Action: LeaveRoomAction, RequestId: "leave"})
+
+	if message := next(t, ann); message.Action != AckAction || message.RequestId != "leave" {
+		t.Errorf("got %v of %q, want only the ack of leave", message.Action, message.RequestId)
+	}
+}
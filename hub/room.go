@@ -2,17 +2,45 @@ package hub
 
 import (
 	"fmt"
+	"github.com/dchest/uniuri"
 	"go-pokerchips/models"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 )
 
 const welcomeMessage = "> %s joined the room."
 const leaveMessage = "> %s left the room."
 
+var (
+	errAddPotInTournament  = newError(CodeNotAllowed, "chips cannot be added to the pot outside of a hand in a tournament")
+	errAddPotInHand        = newError(CodeInvalidState, "a hand is in progress, use call, raise or all-in to bet")
+	errTakePotNotHost      = newError(CodeNotAllowed, "only the host of the room can take chips from the pot, declare the winners with award-pot")
+	errTakePotInTournament = newError(CodeNotAllowed, "chips cannot be taken from the pot of a tournament")
+	errTakePotInHand       = newError(CodeInvalidState, "a hand is in progress, declare the winners with award-pot once it is over")
+	errHandNotOver         = newError(CodeInvalidState, "the hand is not over yet")
+	errAwardNotHost        = newError(CodeNotAllowed, "only the host of the room can declare the winners of a pot")
+	errNotEnoughPlayers    = newError(CodeInvalidState, "at least two players with chips are needed to start a hand")
+	errMissingBlinds       = newError(CodeInvalidRequest, "the blinds are missing")
+	errTournamentBlinds    = newError(CodeNotAllowed, "the blinds of a tournament follow its level schedule")
+	errBlindsNotHost       = newError(CodeNotAllowed, "only the host of the room can change the blinds")
+)
+
 type Room struct {
-	Id     string         `json:"id"`
-	Uri    string         `json:"uri"`
-	Pot    int            `json:"pot"`
-	Record map[string]int `json:"record"`
+	Id         string              `json:"id"`
+	Uri        string              `json:"uri"`
+	Creator    string              `json:"creator"`
+	Host       string              `json:"host"`
+	Type       string              `json:"type"`
+	Pot        int                 `json:"pot"`
+	Pots       []models.Pot        `json:"pots"`
+	Blinds     models.Blinds       `json:"blinds"`
+	Dealer     string              `json:"dealer"`
+	Settings   models.RoomSettings `json:"settings"`
+	Tournament *models.Tournament  `json:"tournament,omitempty"`
+	Locked     bool                `json:"locked"`
 
 	hub *Hub
 
@@ -25,40 +53,591 @@ type Room struct {
 	// Unregister requests from the clients
 	unregister chan *Client
 
-	// Inbound messages from the clients.
-	broadcast chan *Message
+	// Chat, chip and betting actions from the clients, handled one at a time by the room goroutine.
+	actions chan *clientAction
+
+	// Host commands that came in over REST
+	moderations chan *moderation
+
+	// The current or last hand played in the room
+	hand *Hand
+
+	// Chips each player of the hand started with, until the busted players are eliminated
+	handStacks map[string]int
+
+	// When the current tournament level ends while the clock is running
+	levelEndsAt time.Time
+
+	// The turn being timed when the room has an action clock, and the time bank left of each player who used theirs
+	clock     *actionClock
+	timeBanks map[string]int
+
+	// Number of messages broadcast to the room so far, counted from when this instance loaded the
+	// room, which the random epoch tells apart from any other load of the room
+	sequence int64
+	epoch    string
+
+	// The last broadcast messages, oldest first, replayed to clients that resume
+	events []event
+
+	// Replies to the last requests of each player, so retried requests are not applied twice
+	requests *requestLog
+
+	// Closed to stop the room goroutine, see stop
+	quit     chan struct{}
+	quitOnce sync.Once
+
+	// Closed once the room goroutine has stopped, so nobody blocks sending to a room that is gone
+	stopped chan struct{}
+
+	// Since when nobody is connected. The room is shut down once it has been idle for the grace period of the hub.
+	idleSince time.Time
+
+	// Players whose connection the room dropped while handling the current event, see markDroppedAway
+	dropped []string
+
+	// Events of the other instances that have the room loaded, see cluster.go
+	remote       chan *remoteEvent
+	subscription Subscription
+
+	// Players connected to the other instances by instance, and when they were last announced from here
+	presence     map[string]remotePresence
+	presenceSent time.Time
+
+	// Until when this instance owns the room, and the requests it forwarded to the owner by id, see owner.go
+	ownedUntil time.Time
+	forwarded  map[string]*pendingRequest
+
+	// Requests for the queue metrics of the clients, see Hub.Metrics
+	metrics chan chan RoomMetrics
+}
+
+// clientAction is a message from a client queued for the room goroutine.
+type clientAction struct {
+	client  *Client
+	message Message
+
+	// Why the message could not be read, it is refused without being applied
+	err error
 }
 
 func NewRoom(hub *Hub, room *models.DBRoom) *Room {
 
-	return &Room{
-		Id:         room.Id.Hex(),
-		Uri:        room.Uri,
-		Pot:        room.Pot,
-		Record:     room.Record,
-		hub:        hub,
-		clients:    make(map[*Client]bool),
-		register:   make(chan *Client),
-		unregister: make(chan *Client),
-		broadcast:  make(chan *Message),
+	newRoom := &Room{
+		Id:          room.Id.Hex(),
+		Uri:         room.Uri,
+		Creator:     room.Creator,
+		Host:        room.HostName(),
+		Type:        room.Type,
+		Pot:         room.Pot,
+		Pots:        room.Pots,
+		Blinds:      room.Blinds,
+		Dealer:      room.Dealer,
+		Settings:    room.Settings.WithDefaults(),
+		Tournament:  room.Tournament,
+		Locked:      room.Locked,
+		hub:         hub,
+		clients:     make(map[*Client]bool),
+		register:    make(chan *Client),
+		unregister:  make(chan *Client),
+		actions:     make(chan *clientAction),
+		moderations: make(chan *moderation),
+		requests:    newRequestLog(),
+		epoch:       uniuri.New(),
+		timeBanks:   make(map[string]int),
+		quit:        make(chan struct{}),
+		stopped:     make(chan struct{}),
+		idleSince:   time.Now(),
+		remote:      make(chan *remoteEvent),
+		presence:    make(map[string]remotePresence),
+		forwarded:   make(map[string]*pendingRequest),
+		metrics:     make(chan chan RoomMetrics),
+	}
+
+	// A running clock picks up where it was last saved
+	if newRoom.Tournament != nil {
+		newRoom.levelEndsAt = time.Now().Add(time.Duration(newRoom.Tournament.Remaining) * time.Second)
 	}
+
+	return newRoom
 }
 
 func (room *Room) RunRoom() {
 
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	defer room.shutdown()
+
+	room.subscribe()
+	room.claim()
+	if room.isOwner() {
+		room.seatPlayers()
+	}
+
 	for {
 		select {
 		case client := <-room.register:
 			room.registerClientInRoom(client)
 		case client := <-room.unregister:
 			room.unregisterClientInRoom(client)
-		case message := <-room.broadcast:
-			fmt.Printf("Broadcast message: %v to the room %v", message, room.Uri)
-			room.broadcastClientsInRoom(message.encode())
+		case action := <-room.actions:
+			room.handleAction(action)
+		case m := <-room.moderations:
+			room.handleModeration(m)
+		case event := <-room.remote:
+			room.applyRemote(event)
+		case reply := <-room.metrics:
+			reply <- room.collectMetrics()
+		case <-ticker.C:
+			room.claim()
+			room.tick()
+			room.tickClock()
+			room.expireForwarded(time.Now().Add(-forwardTimeout))
+			room.announcePresence()
+			room.resyncLagging()
+			if room.isIdle() {
+				log.Printf("Room %v has been idle for %v, shutting it down\n", room.Uri, room.hub.idleGrace)
+				return
+			}
+		case <-room.quit:
+			return
+		}
+
+		room.markDroppedAway()
+	}
+}
+
+// stop asks the room goroutine to shut the room down. It can be called more than once and from any goroutine.
+func (room *Room) stop() {
+	room.quitOnce.Do(func() {
+		close(room.quit)
+	})
+}
+
+// isIdle reports whether nobody has been connected for the grace period, which lets players who
+// lost their connection come back to the same room.
+func (room *Room) isIdle() bool {
+	return len(room.clients) == 0 && time.Since(room.idleSince) >= room.hub.idleGrace
+}
+
+// shutdown saves what is only known to the room goroutine, disconnects the players still there and
+// takes the room out of the hub. The next player to connect loads it again from the database.
+func (room *Room) shutdown() {
+
+	room.persist()
+	room.expireForwarded(time.Now())
+
+	for client := range room.clients {
+		room.dropClient(client)
+	}
+
+	room.hub.DeleteRoom(room)
+	room.release()
+	room.unsubscribe()
+	close(room.stopped)
+}
+
+// persist saves the time left in the level of a running tournament clock, the rest of the room is
+// saved as it changes. The clock stands still while the room is not loaded.
+// A hand still being played is abandoned, its pots stay in the room until they are awarded.
+// Only the owner saves, the clock of the other instances follows it.
+func (room *Room) persist() {
+
+	if !room.isOwner() {
+		return
+	}
+
+	if tournament := room.Tournament; tournament != nil && tournament.Started && !tournament.Paused && !tournament.Finished {
+		tournament.Remaining = room.secondsLeft()
+		room.saveTournament()
+	}
+
+	if room.handInProgress() {
+		log.Printf("Room %v shut down in the middle of a hand, the pots are left to be awarded\n", room.Uri)
+	}
+}
+
+// deliver sends value on a channel read by the room goroutine, unless the room has stopped.
+func deliver[T any](room *Room, ch chan<- T, value T) bool {
+
+	select {
+	case ch <- value:
+		return true
+	case <-room.stopped:
+		return false
+	}
+}
+
+func (room *Room) handleAction(action *clientAction) {
+
+	client := action.client
+
+	// Actions still queued from a client that was dropped
+	if _, ok := room.clients[client]; !ok {
+		return
+	}
+
+	// Only the owner of the room changes it, see owner.go
+	if action.err == nil && !isLocalAction(action.message.Action) && !room.isOwner() {
+		room.forward(client, action.message)
+		return
+	}
+
+	reply, applied := room.answer(client, action.message, action.err)
+
+	// An action can drop its own sender
+	if _, ok := room.clients[client]; !ok {
+		return
+	}
+
+	if reply != nil {
+		client.sendMessage(reply)
+	}
+
+	// Leaving is acknowledged while the player is still in the room to be told
+	if action.message.Action == LeaveRoomAction && applied {
+		room.unregisterClientInRoom(client)
+	}
+}
+
+// applyAction runs a client action and returns why it was refused, if it was.
+func (room *Room) applyAction(client *Client, message Message) error {
+
+	switch message.Action {
+	case SendMessageAction:
+		// Only the text comes from the client, the sender is the player of the signed session
+		room.broadcastClientsInRoom(&Message{Action: SendMessageAction, Message: message.Message, Sender: client.name})
+		return nil
+	case LeaveRoomAction:
+		// The player leaves once they are told, see handleAction
+		return nil
+	case AddPot:
+		if room.Tournament != nil {
+			return errAddPotInTournament
+		}
+		if room.handInProgress() {
+			return errAddPotInHand
+		}
+		return client.addPot(message)
+	case TakePot:
+		// Players would otherwise help themselves to the pot, the winners of a hand are declared with award-pot
+		if client.name != room.Host {
+			return errTakePotNotHost
 		}
+		if room.Tournament != nil {
+			return errTakePotInTournament
+		}
+		if room.handInProgress() {
+			return errTakePotInHand
+		}
+		return client.takePot(message)
+	case AwardPotAction:
+		// Players would otherwise declare themselves the winners of any pot
+		if client.name != room.Host {
+			return errAwardNotHost
+		}
+		if room.handInProgress() {
+			return errHandNotOver
+		}
+		return room.payOut(client.name, message.PotIndex, message.Winners)
+	case StartHandAction:
+		return room.startHand(client)
+	case SetBlindsAction:
+		return room.setBlinds(client, message.Blinds)
+	case ResumeAction:
+		return room.resume(client, message.Epoch, message.Sequence)
+	case TimeBankAction:
+		return room.extendClock(client)
+	case StartTournamentAction:
+		return room.startTournament(client)
+	case PauseTournamentAction:
+		return room.pauseTournament(client, true)
+	case ResumeTournamentAction:
+		return room.pauseTournament(client, false)
+	case TakeSeatAction, StandUpAction, SitOutAction, SitInAction:
+		return room.changeSeat(client, message)
+	case KickAction, TransferHostAction, AdjustStackAction, LockRoomAction, UnlockRoomAction, CloseRoomAction, SwapSeatsAction:
+		input := &models.ModerateRoomInput{
+			Action:    message.Action,
+			Name:      message.Name,
+			Amount:    message.Amount,
+			Reason:    message.Reason,
+			Seat:      message.Seat,
+			OtherSeat: message.OtherSeat,
+		}
+		return room.moderate(client.name, input)
+	default:
+		return room.playHand(client.name, message, "")
 	}
 }
 
+func (room *Room) handInProgress() bool {
+	return room.hand != nil && !room.hand.isOver()
+}
+
+// startHand deals every seated player who is connected, neither away nor sitting out and has chips into a new hand,
+// in seat order. The others are skipped for the blinds and the turn order.
+func (room *Room) startHand(client *Client) error {
+
+	if room.handInProgress() {
+		return errHandInProgress
+	}
+
+	if err := room.canDealTournamentHand(); err != nil {
+		return err
+	}
+
+	dbRoom, err := room.hub.roomService.FindRoomByUri(room.Uri)
+	if err != nil {
+		return err
+	}
+
+	seated, players := dealIn(dbRoom.Seats, dbRoom.Record, room.isOnline)
+	if len(players) < 2 {
+		return errNotEnoughPlayers
+	}
+
+	dealer := nextDealer(seated, players, dbRoom.Dealer)
+	room.Dealer = players[dealer]
+	if err = room.hub.roomService.UpdateDealer(room.Id, room.Dealer); err != nil {
+		log.Printf("Could not move the dealer button of room %v: %v\n", room.Uri, err)
+	}
+
+	room.Blinds = dbRoom.Blinds
+	room.Pot = dbRoom.Pot
+	room.Pots = dbRoom.Pots
+	room.hand = newHand(players, dbRoom.Record, dbRoom.Pot, dealer, dbRoom.Blinds)
+
+	room.handStacks = make(map[string]int, len(players))
+	for _, name := range players {
+		room.handStacks[name] = dbRoom.Record[name]
+	}
+
+	lines := []string{fmt.Sprintf("%v started a new hand, %v is the dealer.", client.name, room.Dealer)}
+
+	for _, move := range room.hand.forcedMoves() {
+		updatePotResp, err := room.hub.roomService.AddPot(room.Id, move.player, move.chips)
+		if err != nil {
+			log.Printf("Could not post the %v of %v in room %v: %v\n", move.action, move.player, room.Uri, err)
+			continue
+		}
+		room.Pot = updatePotResp.Pot
+		lines = append(lines, room.hand.describe(move))
+		room.hand.post(move)
+	}
+
+	room.hand.open()
+	room.refreshPots()
+
+	if room.hand.isOver() {
+		lines = append(lines, "Everybody is all-in. Showdown!")
+	} else {
+		lines = append(lines, fmt.Sprintf("%v to act.", room.hand.toAct()))
+	}
+
+	message := &Message{
+		Action:  UpdateHandAction,
+		Message: strings.Join(lines, " "),
+		Pot:     room.Pot,
+		Pots:    room.Pots,
+		Sender:  client.name,
+		Hand:    room.hand.state(),
+	}
+	room.broadcastClientsInRoom(message)
+	room.startClock()
+
+	return nil
+}
+
+// refreshPots recomputes the main pot and side pots of the hand and stores them.
+func (room *Room) refreshPots() {
+
+	pots := room.hand.pots()
+	if err := room.hub.roomService.UpdatePots(room.Id, pots); err != nil {
+		log.Printf("Could not update the pots of room %v: %v\n", room.Uri, err)
+	}
+	room.Pots = pots
+}
+
+// setBlinds lets the host change the blinds and ante, they apply from the next hand on.
+func (room *Room) setBlinds(client *Client, blinds *models.Blinds) error {
+
+	if client.name != room.Host {
+		return errBlindsNotHost
+	}
+
+	if blinds == nil {
+		return errMissingBlinds
+	}
+
+	if room.Tournament != nil {
+		return errTournamentBlinds
+	}
+
+	if err := room.hub.roomService.UpdateBlinds(room.Id, *blinds); err != nil {
+		return err
+	}
+	room.Blinds = *blinds
+
+	message := &Message{
+		Action: UpdateBlindsAction,
+		Message: fmt.Sprintf("%v set the blinds to %v/%v with an ante of %v, starting from the next hand.",
+			client.name, blinds.SmallBlind, blinds.BigBlind, blinds.Ante),
+		Pot:    room.Pot,
+		Sender: client.name,
+		Blinds: blinds,
+	}
+	room.broadcastClientsInRoom(message)
+
+	return nil
+}
+
+// playHand applies a check, call, raise, fold or all-in from the player whose turn it is.
+// A non-empty note is added to the description of the move, for moves made on the player's behalf.
+func (room *Room) playHand(name string, message Message, note string) error {
+
+	if room.hand == nil {
+		return errNoHand
+	}
+
+	move, err := room.hand.move(name, message.Action, message.Amount)
+	if err != nil {
+		return err
+	}
+
+	if move.chips > 0 {
+		updatePotResp, err := room.hub.roomService.AddPot(room.Id, name, move.chips)
+		if err != nil {
+			return err
+		}
+		room.Pot = updatePotResp.Pot
+	}
+
+	street := room.hand.street
+	description := room.hand.describe(move)
+	if note != "" {
+		description += " " + note
+	}
+	room.hand.apply(move)
+
+	// A fold can change who is eligible for each pot, so the split is refreshed after every move
+	room.refreshPots()
+
+	message.Action = UpdateHandAction
+	message.Message = description
+	message.Pot = room.Pot
+	message.Pots = room.Pots
+	message.CurrentChips = room.hand.stacks[name]
+	message.Sender = name
+	message.Hand = room.hand.state()
+
+	if remaining := room.hand.remaining(); len(remaining) == 1 {
+		message.Message += fmt.Sprintf(" %v wins the hand uncontested.", remaining[0])
+	} else if room.hand.isOver() {
+		message.Message += " Showdown!"
+	} else if room.hand.street != street {
+		message.Message += fmt.Sprintf(" Dealing the %v.", streets[room.hand.street])
+	}
+
+	room.broadcastClientsInRoom(&message)
+
+	// Nobody is left to contest the pots, so they all go to the last player standing
+	if remaining := room.hand.remaining(); len(remaining) == 1 {
+		for len(room.Pots) > 0 {
+			if err = room.payOut(name, 0, remaining); err != nil {
+				log.Printf("Could not award the pot of room %v: %v\n", room.Uri, err)
+				break
+			}
+		}
+	}
+
+	// The next player gets their time on the clock, unless they are not there to take it
+	if !room.skipAbsent() {
+		room.startClock()
+	}
+
+	return nil
+}
+
+// payOut awards the pot at index to its winners and tells the room who won what.
+func (room *Room) payOut(sender string, index int, winners []string) error {
+
+	awardPotResp, err := room.hub.roomService.AwardPot(room.Id, index, winners)
+	if err != nil {
+		return err
+	}
+
+	room.Pot = awardPotResp.Pot
+	room.Pots = awardPotResp.Pots
+
+	names := make([]string, 0, len(awardPotResp.Payouts))
+	for name := range awardPotResp.Payouts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	wins := make([]string, 0, len(names))
+	for _, name := range names {
+		wins = append(wins, fmt.Sprintf("%v wins %v", name, awardPotResp.Payouts[name]))
+	}
+
+	text := strings.Join(wins, ", ") + "."
+	if len(names) > 1 {
+		text = fmt.Sprintf("Split pot of %v: %v", awardPotResp.Amount, text)
+	}
+
+	message := &Message{
+		Action:  PotAwardedAction,
+		Message: text,
+		Pot:     room.Pot,
+		Pots:    room.Pots,
+		Sender:  sender,
+		Payouts: awardPotResp.Payouts,
+	}
+	room.broadcastClientsInRoom(message)
+
+	// Once the last pot of a tournament hand is paid out, the players left without chips are out
+	if room.Tournament != nil && len(room.Pots) == 0 && !room.handInProgress() {
+		room.eliminate()
+	}
+
+	return nil
+}
+
+// errorMessage tells a single player why their action was refused, without bothering the rest of the room.
+func errorMessage(name string, err error) *Message {
+
+	return &Message{
+		Action:  ErrorAction,
+		Message: err.Error(),
+		Code:    errorCode(err),
+		Sender:  name,
+	}
+}
+
+func indexOf(names []string, name string) int {
+
+	for i, n := range names {
+		if n == name {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// isOnline reports whether the player is connected to this instance or to another one.
+func (room *Room) isOnline(name string) bool {
+
+	for client := range room.clients {
+		if client.name == name {
+			return true
+		}
+	}
+
+	return room.isOnlineElsewhere(name)
+}
+
 func (room *Room) registerClientInRoom(client *Client) {
 
 	fmt.Printf("registerClientInRoom: %v\n", client.name)
@@ -67,12 +646,19 @@ func (room *Room) registerClientInRoom(client *Client) {
 	//Notify client with his/her username
 	message := &Message{
 		Pot:    room.Pot,
+		Pots:   room.Pots,
 		Action: JoinRoomAction,
 		Sender: client.name,
 	}
-	client.send <- message.encode()
+	client.sendMessage(message)
+
+	// The full state lets the client render the room without asking the REST API
+	if err := room.sendState(client); err != nil {
+		log.Printf("Could not take a snapshot of room %v: %v\n", room.Uri, err)
+	}
 
 	room.notifyClientJoined(client)
+	room.setAway(client.name, false)
 }
 
 func (room *Room) unregisterClientInRoom(client *Client) {
@@ -80,20 +666,75 @@ func (room *Room) unregisterClientInRoom(client *Client) {
 	fmt.Printf("unregisterClientInRoom: %v\n", client.name)
 	if _, ok := room.clients[client]; ok {
 		delete(room.clients, client)
+
+		// A player whose last connection is gone is away until they connect again
+		if !room.isOnline(client.name) {
+			room.setAway(client.name, true)
+		}
+
 		if len(room.clients) == 0 {
-			room.hub.DeleteRoom(room)
-			room.notifyClientJoined(client)
+			room.idleSince = time.Now()
+			return
 		}
+		room.notifyClientLeft(client)
 	}
 }
 
-func (room *Room) broadcastClientsInRoom(message []byte) {
+// dropClient removes a client from the room and closes its connection once the messages
+// already queued for it are written. The grace period of an empty room starts over, as when the
+// last player disconnects.
+func (room *Room) dropClient(client *Client) {
+
+	if _, ok := room.clients[client]; ok {
+		delete(room.clients, client)
+		close(client.send)
+
+		room.dropped = append(room.dropped, client.name)
+		if len(room.clients) == 0 {
+			room.idleSince = time.Now()
+		}
+	}
+}
+
+// markDroppedAway marks the players dropped by the last event away when that was their last connection,
+// like the players who disconnected. It waits for the event to be handled, so a broadcast that drops a
+// slow client is not interleaved with the one telling the room it is away.
+func (room *Room) markDroppedAway() {
+
+	dropped := room.dropped
+	room.dropped = nil
+
+	for _, name := range dropped {
+		if !room.isOnline(name) {
+			room.setAway(name, true)
+		}
+	}
+}
+
+// broadcastClientsInRoom sends the message to everybody in the room, on this instance and the others.
+func (room *Room) broadcastClientsInRoom(message *Message) {
+
+	room.broadcastLocal(message)
+	room.publish(&remoteEvent{Message: message})
+}
+
+// broadcastLocal numbers the message with the next sequence number, keeps it for clients that
+// resume later and sends it to the players connected to this instance.
+// Every instance numbers the messages of its own players, in an epoch of its own.
+func (room *Room) broadcastLocal(message *Message) {
+
+	room.sequence++
+	message.Sequence = room.sequence
+	message.Epoch = room.epoch
+
+	encoded := message.frames()
+	room.remember(room.sequence, encoded)
 
-	fmt.Printf("broadcastClientsInRoom: %v\n", string(message))
+	fmt.Printf("broadcastClientsInRoom: %v\n", string(encoded[ProtocolV1]))
 	fmt.Println("The clients in room: ")
 	for client := range room.clients {
 		fmt.Println(client.name)
-		client.send <- message
+		room.sendTo(client, encoded[client.protocol])
 	}
 }
 
@@ -108,7 +749,7 @@ func (room *Room) notifyClientJoined(client *Client) {
 		Sender:  client.name,
 	}
 
-	room.broadcastClientsInRoom(message.encode())
+	room.broadcastClientsInRoom(message)
 }
 
 func (room *Room) notifyClientLeft(client *Client) {
@@ -120,5 +761,5 @@ func (room *Room) notifyClientLeft(client *Client) {
 		Message: fmt.Sprintf(leaveMessage, client.name),
 		Sender:  client.name,
 	}
-	room.broadcastClientsInRoom(message.encode())
+	room.broadcastClientsInRoom(message)
 }
@@ -0,0 +1,157 @@
+package hub
+
+import (
+	"go-pokerchips/models"
+	"go-pokerchips/services"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestIdleRoomsStopTheirGoroutines(t *testing.T) {
+
+	roomService := services.NewMemoryRoomService()
+	before := runtime.NumGoroutine()
+
+	hub := newTestHub(t, roomService, NewMemoryPubSub(), time.Second, SlowClientResync)
+
+	rooms := make([]*Room, 0, 5)
+	for i := 0; i < cap(rooms); i++ {
+		room := hub.GetOrCreateRoom(createTestRoom(t, roomService, "ann", "bob"))
+		rooms = append(rooms, room)
+
+		for _, name := range []string{"ann", "bob", "ann"} {
+			disconnect(connect(t, room, name))
+		}
+	}
+
+	for _, room := range rooms {
+		select {
+		case <-room.stopped:
+		case <-time.After(testTimeout):
+			t.Fatalf("room %v did not stop once it was idle", room.Uri)
+		}
+
+		if hub.FindRoomByUri(room.Uri) != nil {
+			t.Errorf("room %v is still in the hub", room.Uri)
+		}
+	}
+
+	deadline := time.Now().Add(testTimeout)
+	for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if after := runtime.NumGoroutine(); after > before {
+		t.Errorf("%v goroutines before the rooms were loaded, %v after they stopped", before, after)
+	}
+}
+
+func TestDroppingLastClientStartsGracePeriod(t *testing.T) {
+
+	roomService := services.NewMemoryRoomService()
+	hub := newTestHub(t, roomService, nil, time.Second, SlowClientResync)
+	room := hub.GetOrCreateRoom(createTestRoom(t, roomService, "ann", "bob"))
+
+	connect(t, room, "bob")
+
+	// Longer than the grace period, counted from when the room was loaded
+	time.Sleep(1500 * time.Millisecond)
+
+	if err := hub.Moderate(room.Uri, "ann", &models.ModerateRoomInput{Action: KickAction, Name: "bob"}); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-room.stopped:
+		t.Fatal("the room shut down without a grace period once its last client was kicked")
+	case <-time.After(500 * time.Millisecond):
+	}
+
+	select {
+	case <-room.stopped:
+	case <-time.After(testTimeout):
+		t.Fatal("the room did not shut down after the grace period")
+	}
+}
+
+func TestDroppedPlayerIsAway(t *testing.T) {
+
+	roomService := services.NewMemoryRoomService()
+	hub := newTestHub(t, roomService, nil, time.Minute, SlowClientDisconnect)
+	dbRoom := createTestRoom(t, roomService, "ann", "bob")
+	room := hub.GetOrCreateRoom(dbRoom)
+
+	ann := connect(t, room, "ann")
+	bob := connect(t, room, "bob")
+
+	// Bob never reads his messages until he is dropped
+	away := false
+	for i := 0; i <= sendBufferSize && !away; i++ {
+		send(ann, Message{Action: SendMessageAction, Message: "hello"})
+		for message := next(t, ann); message.Action != SendMessageAction; message = next(t, ann) {
+			away = away || message.Message == "bob is away."
+		}
+	}
+
+	if !away {
+		if update := expect(t, ann, UpdateSeatsAction); update.Message != "bob is away." {
+			t.Errorf("got %q, want bob to be away", update.Message)
+		}
+	}
+
+	dbRoom, err := roomService.FindRoomByUri(dbRoom.Uri)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if seat := dbRoom.SeatOf("bob"); seat == nil || !seat.Away {
+		t.Errorf("seat of bob is %+v, want away", seat)
+	}
+
+	for range bob.send {
+	}
+}
+
+func TestTakePotIsForTheHost(t *testing.T) {
+
+	roomService := services.NewMemoryRoomService()
+	hub := newTestHub(t, roomService, nil, time.Minute, SlowClientResync)
+
+	dbRoom, err := roomService.CreateRoom(&models.CreateRoomInput{
+		Creator:  "ann",
+		Settings: models.RoomSettings{Blinds: models.Blinds{SmallBlind: 5, BigBlind: 10}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = roomService.RegisterUserInRoom(dbRoom.Id.Hex(), "bob", "", ""); err != nil {
+		t.Fatal(err)
+	}
+	room := hub.GetOrCreateRoom(dbRoom)
+
+	ann := connect(t, room, "ann")
+	bob := connect(t, room, "bob")
+
+	send(bob, Message{Action: AddPot, Pot: 30, RequestId: "bet"})
+	expect(t, bob, AckAction)
+	expect(t, ann, UpdatePot)
+
+	send(bob, Message{Action: TakePot, Pot: 30, RequestId: "take"})
+	if nack := expect(t, bob, NackAction); nack.Message != errTakePotNotHost.Error() {
+		t.Errorf("got %q, want %q", nack.Message, errTakePotNotHost.Error())
+	}
+
+	send(ann, Message{Action: TakePot, Pot: 20, RequestId: "take"})
+	if update := expect(t, ann, UpdatePot); update.Pot != 10 || update.CurrentChips != models.DefaultStartingStack+20 {
+		t.Errorf("got pot %v and %v chips, want 10 and %v", update.Pot, update.CurrentChips, models.DefaultStartingStack+20)
+	}
+	expect(t, ann, AckAction)
+
+	send(ann, Message{Action: StartHandAction, RequestId: "start"})
+	expect(t, ann, AckAction)
+
+	send(ann, Message{Action: TakePot, Pot: 10, RequestId: "take-in-hand"})
+	if nack := expect(t, ann, NackAction); nack.Message != errTakePotInHand.Error() {
+		t.Errorf("got %q, want %q", nack.Message, errTakePotInHand.Error())
+	}
+}
@@ -0,0 +1,164 @@
+package hub
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+const schemaDialect = "https://json-schema.org/draft/2020-12/schema"
+
+// Schema is the JSON Schema of protocol version 2, generated from the payload types so it cannot
+// drift from what the server reads and writes. A message is valid when it matches ClientMessage if
+// a client sends it, or ServerMessage if the server does.
+func Schema() map[string]any {
+
+	g := &schemaGenerator{defs: make(map[string]any)}
+
+	clientMessages := make([]any, 0, len(clientPayloads))
+	for _, action := range sortedKeys(clientPayloads) {
+		payload := reflect.TypeOf(clientPayloads[action]())
+		clientMessages = append(clientMessages, g.envelope(action, payload, false))
+	}
+
+	serverMessages := make([]any, 0, len(serverPayloads))
+	for _, action := range sortedKeys(serverPayloads) {
+		payload := reflect.TypeOf(serverPayloads[action](&Message{}))
+		serverMessages = append(serverMessages, g.envelope(action, payload, true))
+	}
+
+	g.defs["ClientMessage"] = map[string]any{"oneOf": clientMessages}
+	g.defs["ServerMessage"] = map[string]any{"oneOf": serverMessages}
+
+	return map[string]any{
+		"$schema": schemaDialect,
+		"title":   "go-pokerchips websocket protocol",
+		"version": ProtocolVersion,
+		"oneOf": []any{
+			map[string]any{"$ref": "#/$defs/ClientMessage"},
+			map[string]any{"$ref": "#/$defs/ServerMessage"},
+		},
+		"$defs": g.defs,
+	}
+}
+
+// schemaGenerator keeps the schemas of the named struct types in $defs, so each is written once.
+type schemaGenerator struct {
+	defs map[string]any
+}
+
+// envelope is the schema of the Envelope of one message type. The server always sends a payload,
+// clients can leave out the empty ones.
+func (g *schemaGenerator) envelope(action string, payload reflect.Type, server bool) map[string]any {
+
+	// Payloads are never null, even the ones built from a pointer
+	if payload.Kind() == reflect.Pointer {
+		payload = payload.Elem()
+	}
+
+	required := []string{"type", "version"}
+	if server {
+		required = append(required, "payload")
+	}
+
+	return map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			"type":      map[string]any{"const": action},
+			"version":   map[string]any{"const": ProtocolV2},
+			"requestId": map[string]any{"type": "string"},
+			"sequence":  map[string]any{"type": "integer"},
+			"epoch":     map[string]any{"type": "string"},
+			"payload":   g.schema(payload),
+		},
+		"required":             required,
+		"additionalProperties": false,
+	}
+}
+
+func (g *schemaGenerator) schema(t reflect.Type) map[string]any {
+
+	if t == reflect.TypeOf(time.Time{}) {
+		return map[string]any{"type": "string", "format": "date-time"}
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		return map[string]any{"anyOf": []any{g.schema(t.Elem()), map[string]any{"type": "null"}}}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		// A nil slice is encoded as null
+		return map[string]any{"type": []string{"array", "null"}, "items": g.schema(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": []string{"object", "null"}, "additionalProperties": g.schema(t.Elem())}
+	case reflect.Struct:
+		return g.object(t)
+	}
+
+	return map[string]any{}
+}
+
+// object writes the schema of a struct to $defs and refers to it.
+func (g *schemaGenerator) object(t reflect.Type) map[string]any {
+
+	ref := map[string]any{"$ref": "#/$defs/" + t.Name()}
+
+	if _, ok := g.defs[t.Name()]; ok {
+		return ref
+	}
+
+	properties := make(map[string]any)
+	required := make([]string, 0, t.NumField())
+
+	// Reserved before the fields are walked, in case a field refers back to the type
+	g.defs[t.Name()] = nil
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+
+		name, options, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+
+		properties[name] = g.schema(field.Type)
+		if !strings.Contains(options, "omitempty") {
+			required = append(required, name)
+		}
+	}
+
+	g.defs[t.Name()] = map[string]any{
+		"type":                 "object",
+		"properties":           properties,
+		"required":             required,
+		"additionalProperties": false,
+	}
+
+	return ref
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
@@ -0,0 +1,138 @@
+package hub
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"go-pokerchips/services"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSchemaRoute(t *testing.T) {
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/ws/schema", func(c *gin.Context) {
+		c.JSON(http.StatusOK, Schema())
+	})
+
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/ws/schema", nil))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("schema answered %v", recorder.Code)
+	}
+
+	var schema struct {
+		Version int `json:"version"`
+		Defs    map[string]struct {
+			OneOf []struct {
+				Properties struct {
+					Type struct {
+						Const string `json:"const"`
+					} `json:"type"`
+				} `json:"properties"`
+			} `json:"oneOf"`
+		} `json:"$defs"`
+	}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &schema); err != nil {
+		t.Fatal(err)
+	}
+	if schema.Version != ProtocolVersion {
+		t.Errorf("schema of version %v, want %v", schema.Version, ProtocolVersion)
+	}
+
+	// Every message type has its envelope, and nothing else
+	for name, payloads := range map[string]int{"ClientMessage": len(clientPayloads), "ServerMessage": len(serverPayloads)} {
+		types := schema.Defs[name].OneOf
+		if len(types) != payloads {
+			t.Errorf("%v has %v message types, want %v", name, len(types), payloads)
+		}
+		for _, message := range types {
+			action := message.Properties.Type.Const
+			_, client := clientPayloads[action]
+			_, server := serverPayloads[action]
+			if (name == "ClientMessage" && !client) || (name == "ServerMessage" && !server) {
+				t.Errorf("%v has the unknown type %q", name, action)
+			}
+		}
+	}
+}
+
+func TestSubprotocolNegotiation(t *testing.T) {
+
+	roomService := services.NewMemoryRoomService()
+	hub := newTestHub(t, roomService, nil, time.Minute, SlowClientResync)
+	dbRoom := createTestRoom(t, roomService, "ann")
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/ws", func(c *gin.Context) {
+		ServeWS(hub, dbRoom, "ann", c)
+	})
+	server := httptest.NewServer(router)
+	t.Cleanup(server.Close)
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws"
+
+	tests := []struct {
+		name     string
+		offered  []string
+		chosen   string
+		status   int
+		envelope bool
+	}{
+		{"nothing offered falls back to v1", nil, "", http.StatusSwitchingProtocols, false},
+		{"v1", []string{"pokerchips.v1"}, "pokerchips.v1", http.StatusSwitchingProtocols, false},
+		{"v2", []string{"pokerchips.v2"}, "pokerchips.v2", http.StatusSwitchingProtocols, true},
+		{"first supported one", []string{"pokerchips.v9", "pokerchips.v2", "pokerchips.v1"}, "pokerchips.v2", http.StatusSwitchingProtocols, true},
+		{"unknown", []string{"pokerchips.v9"}, "", http.StatusBadRequest, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			dialer := websocket.Dialer{Subprotocols: test.offered, HandshakeTimeout: testTimeout}
+			conn, response, err := dialer.Dial(url, nil)
+			if response == nil {
+				t.Fatal(err)
+			}
+			if response.StatusCode != test.status {
+				t.Fatalf("handshake answered %v, want %v", response.StatusCode, test.status)
+			}
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+
+			if conn.Subprotocol() != test.chosen {
+				t.Errorf("got subprotocol %q, want %q", conn.Subprotocol(), test.chosen)
+			}
+
+			// The first message tells the player they joined, in the negotiated format
+			conn.SetReadDeadline(time.Now().Add(testTimeout))
+			_, data, err := conn.ReadMessage()
+			if err != nil {
+				t.Fatal(err)
+			}
+			var frame struct {
+				Action  string `json:"action"`
+				Type    string `json:"type"`
+				Version int    `json:"version"`
+			}
+			// The write pump puts the queued messages in one frame, a line each
+			if err = json.NewDecoder(bytes.NewReader(data)).Decode(&frame); err != nil {
+				t.Fatal(err)
+			}
+			if test.envelope && (frame.Type != JoinRoomAction || frame.Version != ProtocolV2) {
+				t.Errorf("got %s, want a v2 envelope of %v", data, JoinRoomAction)
+			}
+			if !test.envelope && frame.Action != JoinRoomAction {
+				t.Errorf("got %s, want a v1 message of %v", data, JoinRoomAction)
+			}
+		})
+	}
+}
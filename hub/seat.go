@@ -0,0 +1,169 @@
+package hub
+
+import (
+	"fmt"
+	"go-pokerchips/models"
+	"log"
+	"sort"
+)
+
+var errSeatInHand = newError(CodeInvalidState, "you are playing the hand, change seats once it is over")
+
+// changeSeat takes a seat for the player, stands them up, sits them out or back in, and tells the room.
+// Sitting out keeps the seat, the player is not dealt in and skipped in the hand they are playing.
+func (room *Room) changeSeat(client *Client, message Message) error {
+
+	var seats []models.Seat
+	var text string
+	var err error
+
+	switch message.Action {
+	case TakeSeatAction:
+		if room.inHand(client.name) {
+			return errSeatInHand
+		}
+		seats, err = room.hub.roomService.TakeSeat(room.Id, client.name, message.Seat)
+		text = fmt.Sprintf("%v took seat %v.", client.name, message.Seat)
+	case StandUpAction:
+		if room.inHand(client.name) {
+			return errSeatInHand
+		}
+		seats, err = room.hub.roomService.StandUp(room.Id, client.name)
+		text = fmt.Sprintf("%v stood up.", client.name)
+	case SitOutAction:
+		seats, err = room.hub.roomService.SitOut(room.Id, client.name, true)
+		text = fmt.Sprintf("%v is sitting out.", client.name)
+	case SitInAction:
+		seats, err = room.hub.roomService.SitOut(room.Id, client.name, false)
+		text = fmt.Sprintf("%v is back in the game from the next hand.", client.name)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	update := &Message{
+		Action:  UpdateSeatsAction,
+		Message: text,
+		Pot:     room.Pot,
+		Sender:  client.name,
+		Seat:    message.Seat,
+		Seats:   seats,
+	}
+	room.broadcastClientsInRoom(update)
+
+	// The player may be sitting out on their turn
+	room.skipAbsent()
+
+	return nil
+}
+
+// setAway marks a seated player away when their last connection to the room is gone, or back when
+// they connect again, and tells the room.
+func (room *Room) setAway(name string, away bool) {
+
+	dbRoom, err := room.hub.roomService.FindRoomByUri(room.Uri)
+	if err != nil {
+		log.Printf("Could not load the seats of room %v: %v\n", room.Uri, err)
+		return
+	}
+
+	if seat := dbRoom.SeatOf(name); seat == nil || seat.Away == away {
+		return
+	}
+
+	seats, err := room.hub.roomService.SetAway(room.Id, name, away)
+	if err != nil {
+		log.Printf("Could not mark %v away in room %v: %v\n", name, room.Uri, err)
+		return
+	}
+
+	text := fmt.Sprintf("%v is back.", name)
+	if away {
+		text = fmt.Sprintf("%v is away.", name)
+	}
+
+	message := &Message{
+		Action:  UpdateSeatsAction,
+		Message: text,
+		Pot:     room.Pot,
+		Sender:  name,
+		Seats:   seats,
+	}
+	room.broadcastClientsInRoom(message)
+
+	if away {
+		room.skipAbsent()
+	}
+}
+
+// skipAbsent checks, or folds when there is a bet to call, on behalf of the player to act when they
+// are away or sitting out, and reports whether it did. Every move ends with it, so a hand carries on
+// without the players who left the table. Only the owner of the room moves for them.
+func (room *Room) skipAbsent() bool {
+
+	if !room.isOwner() || !room.handInProgress() {
+		return false
+	}
+
+	name := room.hand.toAct()
+
+	dbRoom, err := room.hub.roomService.FindRoomByUri(room.Uri)
+	if err != nil {
+		log.Printf("Could not load the seats of room %v: %v\n", room.Uri, err)
+		return false
+	}
+
+	seat := dbRoom.SeatOf(name)
+	if seat == nil || (!seat.Away && !seat.SittingOut) {
+		return false
+	}
+
+	note := fmt.Sprintf("%v is sitting out.", name)
+	if seat.Away {
+		note = fmt.Sprintf("%v is away.", name)
+	}
+
+	action := FoldAction
+	if room.hand.canCheck(name) {
+		action = CheckAction
+	}
+
+	if err = room.playHand(name, Message{Action: action}, note); err != nil {
+		log.Printf("Could not %v for %v in room %v: %v\n", action, name, room.Uri, err)
+		return false
+	}
+
+	return true
+}
+
+// inHand reports whether the player was dealt into the hand being played.
+func (room *Room) inHand(name string) bool {
+	return room.handInProgress() && indexOf(room.hand.players, name) >= 0
+}
+
+// seatPlayers seats the players of a room from before there were seats, in name order.
+func (room *Room) seatPlayers() {
+
+	dbRoom, err := room.hub.roomService.FindRoomByUri(room.Uri)
+	if err != nil {
+		log.Printf("Could not load the seats of room %v: %v\n", room.Uri, err)
+		return
+	}
+
+	if dbRoom.Seats != nil {
+		return
+	}
+
+	names := make([]string, 0, len(dbRoom.Record))
+	for name := range dbRoom.Record {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for i, name := range names {
+		if _, err = room.hub.roomService.TakeSeat(room.Id, name, i+1); err != nil {
+			log.Printf("Could not seat %v in room %v: %v\n", name, room.Uri, err)
+		}
+	}
+}
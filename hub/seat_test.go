@@ -0,0 +1,74 @@
+package hub
+
+import (
+	"go-pokerchips/models"
+	"go-pokerchips/services"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSeatChanges(t *testing.T) {
+
+	roomService := services.NewMemoryRoomService()
+	hub := newTestHub(t, roomService, nil, time.Minute, SlowClientResync)
+	dbRoom := createTestRoom(t, roomService, "ann", "bob", "cat")
+	room := hub.GetOrCreateRoom(dbRoom)
+
+	ann := connect(t, room, "ann")
+	bob := connect(t, room, "bob")
+	cat := connect(t, room, "cat")
+
+	send(cat, Message{Action: SitOutAction, RequestId: "sit-out"})
+	expect(t, cat, AckAction)
+	if update := expect(t, ann, UpdateSeatsAction); update.Sender != "cat" || !update.Seats[2].SittingOut {
+		t.Errorf("got seats %+v from %v, want cat sitting out", update.Seats, update.Sender)
+	}
+
+	send(ann, Message{Action: StartHandAction, RequestId: "start"})
+	expect(t, ann, AckAction)
+	if hand := expect(t, cat, UpdateHandAction).Hand; !reflect.DeepEqual(hand.Players, []string{"ann", "bob"}) {
+		t.Errorf("dealt %v in, want ann and bob without cat sitting out", hand.Players)
+	}
+
+	// The players of the hand keep their seats until it is over, the others can move
+	send(bob, Message{Action: StandUpAction, RequestId: "stand-up"})
+	if nack := expect(t, bob, NackAction); nack.Message != errSeatInHand.Error() {
+		t.Errorf("bob standing up in a hand got %q, want %q", nack.Message, errSeatInHand.Error())
+	}
+
+	send(cat, Message{Action: TakeSeatAction, Seat: 5, RequestId: "take-seat"})
+	expect(t, cat, AckAction)
+	want := []models.Seat{{Number: 1, Player: "ann"}, {Number: 2, Player: "bob"}, {Number: 5, Player: "cat"}}
+	if update := expect(t, ann, UpdateSeatsAction); !reflect.DeepEqual(update.Seats, want) {
+		t.Errorf("got seats %+v, want %+v with cat back in", update.Seats, want)
+	}
+
+	// Without blinds the player after the dealer acts first, the fold ends the hand
+	send(bob, Message{Action: FoldAction, RequestId: "fold"})
+	expect(t, bob, AckAction)
+
+	send(cat, Message{Action: SitOutAction, RequestId: "sit-out-again"})
+	expect(t, cat, AckAction)
+	send(cat, Message{Action: SitInAction, RequestId: "sit-in"})
+	expect(t, cat, AckAction)
+
+	send(ann, Message{Action: StartHandAction, RequestId: "start-again"})
+	expect(t, ann, AckAction)
+	if hand := expect(t, cat, UpdateHandAction).Hand; !reflect.DeepEqual(hand.Players, []string{"ann", "bob", "cat"}) {
+		t.Errorf("dealt %v in, want cat back in the next hand", hand.Players)
+	}
+
+	// A player who lost their connection is away and not dealt in
+	disconnect(cat)
+	if update := expect(t, ann, UpdateSeatsAction); update.Message != "cat is away." {
+		t.Errorf("got %q, want cat to be away", update.Message)
+	}
+	dbRoom, err := roomService.FindRoomByUri(dbRoom.Uri)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if seat := dbRoom.SeatOf("cat"); seat == nil || !seat.Away {
+		t.Errorf("seat of cat is %+v, want away", seat)
+	}
+}
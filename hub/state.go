@@ -0,0 +1,119 @@
+package hub
+
+import (
+	"go-pokerchips/models"
+	"sort"
+)
+
+// RoomState is everything a client needs to render the room, sent with room-state when it connects.
+type RoomState struct {
+	Uri        string              `json:"uri"`
+	Type       string              `json:"type"`
+	Host       string              `json:"host"`
+	Pot        int                 `json:"pot"`
+	Pots       []models.Pot        `json:"pots"`
+	Blinds     models.Blinds       `json:"blinds"`
+	Dealer     string              `json:"dealer"`
+	Locked     bool                `json:"locked"`
+	Settings   models.RoomSettings `json:"settings"`
+	Tournament *models.Tournament  `json:"tournament,omitempty"`
+	Seats      []models.Seat       `json:"seats"`
+	Players    []PlayerState       `json:"players"`
+
+	// The hand being played, if any, and how long the player to act has left when the room has an action clock
+	Hand  *HandState   `json:"hand,omitempty"`
+	Clock *ActionClock `json:"clock,omitempty"`
+
+	// Number of messages broadcast to the room before this snapshot was taken, and the epoch they count in
+	Sequence int64  `json:"sequence"`
+	Epoch    string `json:"epoch"`
+}
+
+// PlayerState is a player registered in the room.
+type PlayerState struct {
+	Name  string `json:"name"`
+	Stack int    `json:"stack"`
+
+	// What the stack is worth in real currency, when the room has a chip value
+	Value float64 `json:"value,omitempty"`
+
+	// Seats are numbered from 1 to the most players of the room, 0 means standing
+	Seat       int  `json:"seat"`
+	SittingOut bool `json:"sittingOut"`
+	Away       bool `json:"away"`
+	Online     bool `json:"online"`
+}
+
+// snapshot reads the stored room and combines it with what only the room goroutine knows.
+func (room *Room) snapshot() (*RoomState, error) {
+
+	dbRoom, err := room.hub.roomService.FindRoomByUri(room.Uri)
+	if err != nil {
+		return nil, err
+	}
+
+	state := &RoomState{
+		Uri:        dbRoom.Uri,
+		Type:       dbRoom.Type,
+		Host:       room.Host,
+		Pot:        dbRoom.Pot,
+		Pots:       dbRoom.Pots,
+		Blinds:     dbRoom.Blinds,
+		Dealer:     dbRoom.Dealer,
+		Locked:     room.Locked,
+		Settings:   room.Settings,
+		Tournament: room.Tournament,
+		Seats:      dbRoom.Seats,
+		Players:    make([]PlayerState, 0, len(dbRoom.Record)),
+		Sequence:   room.sequence,
+		Epoch:      room.epoch,
+	}
+
+	for name, stack := range dbRoom.Record {
+		player := PlayerState{Name: name, Stack: stack, Value: float64(stack) * room.Settings.ChipValue, Online: room.isOnline(name)}
+		if seat := dbRoom.SeatOf(name); seat != nil {
+			player.Seat = seat.Number
+			player.SittingOut = seat.SittingOut
+			player.Away = seat.Away
+		}
+		state.Players = append(state.Players, player)
+	}
+
+	// Seated players first in seat order, then the others by name
+	sort.Slice(state.Players, func(i, j int) bool {
+		a, b := state.Players[i], state.Players[j]
+		if (a.Seat == 0) != (b.Seat == 0) {
+			return a.Seat != 0
+		}
+		if a.Seat != b.Seat {
+			return a.Seat < b.Seat
+		}
+		return a.Name < b.Name
+	})
+
+	if room.handInProgress() {
+		state.Hand = room.hand.state()
+		state.Clock = room.clockState()
+	}
+
+	return state, nil
+}
+
+// sendState sends a room-state snapshot to a single client.
+func (room *Room) sendState(client *Client) error {
+
+	state, err := room.snapshot()
+	if err != nil {
+		return err
+	}
+
+	message := &Message{
+		Action: RoomStateAction,
+		Pot:    state.Pot,
+		Sender: client.name,
+		State:  state,
+	}
+	client.sendMessage(message)
+
+	return nil
+}
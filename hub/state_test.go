@@ -0,0 +1,81 @@
+package hub
+
+import (
+	"go-pokerchips/models"
+	"go-pokerchips/services"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRoomStateSnapshot(t *testing.T) {
+
+	roomService := services.NewMemoryRoomService()
+	hub := newTestHub(t, roomService, nil, time.Minute, SlowClientResync)
+
+	dbRoom, err := roomService.CreateRoom(&models.CreateRoomInput{
+		Creator:  "ann",
+		Settings: models.RoomSettings{Blinds: models.Blinds{SmallBlind: 5, BigBlind: 10}, ChipValue: 0.25},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"bob", "cat", "dan"} {
+		if err = roomService.RegisterUserInRoom(dbRoom.Id.Hex(), name, "", ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err = roomService.SitOut(dbRoom.Id.Hex(), "dan", true); err != nil {
+		t.Fatal(err)
+	}
+	room := hub.GetOrCreateRoom(dbRoom)
+
+	ann := connect(t, room, "ann")
+	bob := connect(t, room, "bob")
+
+	// Heads-up the dealer posts the small blind
+	send(ann, Message{Action: StartHandAction, RequestId: "start"})
+	expect(t, ann, AckAction)
+	last := expect(t, bob, UpdateHandAction)
+
+	cat := connect(t, room, "cat")
+	expect(t, cat, JoinRoomAction)
+
+	state := expect(t, cat, RoomStateAction).State
+	if state == nil {
+		t.Fatal("room-state has no state")
+	}
+
+	if state.Uri != dbRoom.Uri || state.Type != models.RoomTypeCash || state.Host != "ann" || state.Locked {
+		t.Errorf("got room %v of type %v hosted by %v and locked %v", state.Uri, state.Type, state.Host, state.Locked)
+	}
+	if state.Pot != 15 || state.Blinds != (models.Blinds{SmallBlind: 5, BigBlind: 10}) || state.Dealer != "ann" {
+		t.Errorf("got pot %v, blinds %+v and dealer %v, want 15, 5/10 and ann", state.Pot, state.Blinds, state.Dealer)
+	}
+
+	want := []PlayerState{
+		{Name: "ann", Stack: 995, Value: 248.75, Seat: 1, Online: true},
+		{Name: "bob", Stack: 990, Value: 247.5, Seat: 2, Online: true},
+		{Name: "cat", Stack: 1000, Value: 250, Seat: 3, Online: true},
+		{Name: "dan", Stack: 1000, Value: 250, Seat: 4, SittingOut: true},
+	}
+	if !reflect.DeepEqual(state.Players, want) {
+		t.Errorf("got players %+v, want %+v", state.Players, want)
+	}
+
+	// The hand in play, without the players who joined after it was dealt
+	if state.Hand == nil || !reflect.DeepEqual(state.Hand.Players, []string{"ann", "bob"}) || state.Hand.Turn != "ann" {
+		t.Errorf("got hand %+v, want ann and bob with ann to act", state.Hand)
+	}
+	if state.Clock != nil {
+		t.Errorf("got clock %+v in a room without an action clock", state.Clock)
+	}
+
+	// The snapshot counts every broadcast before it, the next one carries on from there
+	if state.Sequence != last.Sequence || state.Epoch != last.Epoch {
+		t.Errorf("snapshot at %v/%v, want the last broadcast at %v/%v", state.Epoch, state.Sequence, last.Epoch, last.Sequence)
+	}
+	if welcome := expect(t, cat, SendMessageAction); welcome.Sequence != state.Sequence+1 || welcome.Epoch != state.Epoch {
+		t.Errorf("broadcast after the snapshot at %v/%v, want %v/%v", welcome.Epoch, welcome.Sequence, state.Epoch, state.Sequence+1)
+	}
+}
@@ -0,0 +1,266 @@
+package hub
+
+import (
+	"fmt"
+	"go-pokerchips/models"
+	"log"
+	"sort"
+	"strings"
+	"time"
+)
+
+// How often the remaining time of the level is broadcast while the clock runs
+const clockBroadcastSeconds = 10
+
+var (
+	errNotTournament        = newError(CodeInvalidRequest, "this room is not a tournament")
+	errTournamentNotStarted = newError(CodeInvalidState, "the tournament has not started yet")
+	errTournamentStarted    = newError(CodeInvalidState, "the tournament has already started")
+	errTournamentPaused     = newError(CodeInvalidState, "the tournament is paused")
+	errTournamentRunning    = newError(CodeInvalidState, "the tournament is already running")
+	errTournamentFinished   = newError(CodeInvalidState, "the tournament is over")
+	errNotHost              = newError(CodeNotAllowed, "only the host of the room can run the tournament clock")
+)
+
+// canDealTournamentHand reports why no hand can be dealt, if the room is a tournament.
+func (room *Room) canDealTournamentHand() error {
+
+	tournament := room.Tournament
+
+	switch {
+	case tournament == nil:
+		return nil
+	case tournament.Finished:
+		return errTournamentFinished
+	case !tournament.Started:
+		return errTournamentNotStarted
+	case tournament.Paused:
+		return errTournamentPaused
+	}
+
+	return nil
+}
+
+// startTournament starts the clock of the first level.
+func (room *Room) startTournament(client *Client) error {
+
+	if err := room.checkClockControl(client); err != nil {
+		return err
+	}
+
+	if room.Tournament.Started {
+		return errTournamentStarted
+	}
+
+	room.Tournament.Started = true
+	room.levelEndsAt = time.Now().Add(time.Duration(room.Tournament.Remaining) * time.Second)
+	room.saveTournament()
+
+	room.broadcastTournament(client.name, fmt.Sprintf("%v started the tournament. %v", client.name, room.levelText()))
+
+	return nil
+}
+
+// pauseTournament stops or restarts the level clock.
+func (room *Room) pauseTournament(client *Client, pause bool) error {
+
+	if err := room.checkClockControl(client); err != nil {
+		return err
+	}
+
+	tournament := room.Tournament
+
+	if !tournament.Started {
+		return errTournamentNotStarted
+	}
+
+	if tournament.Paused && pause {
+		return errTournamentPaused
+	}
+
+	if !tournament.Paused && !pause {
+		return errTournamentRunning
+	}
+
+	var text string
+	if pause {
+		tournament.Remaining = room.secondsLeft()
+		text = fmt.Sprintf("%v paused the tournament. %v", client.name, room.levelText())
+	} else {
+		room.levelEndsAt = time.Now().Add(time.Duration(tournament.Remaining) * time.Second)
+		text = fmt.Sprintf("%v resumed the tournament. %v", client.name, room.levelText())
+	}
+
+	tournament.Paused = pause
+	room.saveTournament()
+
+	room.broadcastTournament(client.name, text)
+
+	return nil
+}
+
+func (room *Room) checkClockControl(client *Client) error {
+
+	if room.Tournament == nil {
+		return errNotTournament
+	}
+
+	if client.name != room.Host {
+		return errNotHost
+	}
+
+	if room.Tournament.Finished {
+		return errTournamentFinished
+	}
+
+	return nil
+}
+
+// tick runs every second on the room goroutine and moves to the next level when the current one is over.
+// Only the owner of the room runs the clock, the other instances count down with what it publishes.
+func (room *Room) tick() {
+
+	tournament := room.Tournament
+	if tournament == nil || !tournament.Started || tournament.Paused || tournament.Finished {
+		return
+	}
+
+	// The last level lasts until the tournament is over
+	if tournament.Level == len(tournament.Levels)-1 && tournament.Remaining == 0 {
+		return
+	}
+
+	tournament.Remaining = room.secondsLeft()
+
+	if !room.isOwner() {
+		return
+	}
+
+	if tournament.Remaining > 0 {
+		if tournament.Remaining%clockBroadcastSeconds == 0 {
+			room.broadcastTournament("", room.levelText())
+		}
+		return
+	}
+
+	if tournament.Level == len(tournament.Levels)-1 {
+		room.saveTournament()
+		room.broadcastTournament("", "The last level has no time limit, the blinds stay where they are.")
+		return
+	}
+
+	tournament.Level++
+	level := tournament.Levels[tournament.Level]
+	tournament.Remaining = level.Minutes * 60
+	room.levelEndsAt = time.Now().Add(time.Duration(tournament.Remaining) * time.Second)
+
+	// startHand reads the blinds from the stored room, so the new level applies from the next hand on
+	if err := room.hub.roomService.UpdateBlinds(room.Id, level.Blinds()); err != nil {
+		log.Printf("Could not raise the blinds of room %v: %v\n", room.Uri, err)
+	}
+	room.Blinds = level.Blinds()
+	room.saveTournament()
+
+	room.broadcastTournament("", fmt.Sprintf("The blinds go up, starting from the next hand. %v", room.levelText()))
+}
+
+// eliminate takes the players who lost all their chips in the last hand out of the tournament.
+// Players busted in the same hand finish in the order of the stacks they started the hand with.
+func (room *Room) eliminate() {
+
+	tournament := room.Tournament
+	if room.handStacks == nil || tournament.Finished {
+		return
+	}
+
+	dbRoom, err := room.hub.roomService.FindRoomByUri(room.Uri)
+	if err != nil {
+		log.Printf("Could not eliminate the busted players of room %v: %v\n", room.Uri, err)
+		return
+	}
+	stacks := room.handStacks
+	room.handStacks = nil
+
+	var busted, alive []string
+	for name, chips := range dbRoom.Record {
+		if tournament.HasFinished(name) {
+			continue
+		}
+		if chips > 0 {
+			alive = append(alive, name)
+		} else if _, ok := stacks[name]; ok {
+			busted = append(busted, name)
+		}
+	}
+
+	if len(busted) == 0 {
+		return
+	}
+
+	sort.Slice(busted, func(i, j int) bool {
+		if stacks[busted[i]] != stacks[busted[j]] {
+			return stacks[busted[i]] < stacks[busted[j]]
+		}
+		return busted[i] < busted[j]
+	})
+
+	lines := make([]string, 0, len(busted)+1)
+	for i, name := range busted {
+		position := len(alive) + len(busted) - i
+		tournament.Finishers = append(tournament.Finishers, models.Finisher{Name: name, Position: position})
+		lines = append(lines, fmt.Sprintf("%v finishes in position %v.", name, position))
+	}
+
+	if len(alive) == 1 {
+		tournament.Finishers = append(tournament.Finishers, models.Finisher{Name: alive[0], Position: 1})
+		tournament.Finished = true
+		lines = append(lines, fmt.Sprintf("%v wins the tournament!", alive[0]))
+	}
+
+	room.saveTournament()
+	room.broadcastTournament("", strings.Join(lines, " "))
+}
+
+// secondsLeft is the time left in the current level of a running clock, rounded to the second.
+func (room *Room) secondsLeft() int {
+
+	left := int(time.Until(room.levelEndsAt).Round(time.Second).Seconds())
+	if left < 0 {
+		return 0
+	}
+
+	return left
+}
+
+func (room *Room) levelText() string {
+
+	tournament := room.Tournament
+	level := tournament.Levels[tournament.Level]
+
+	return fmt.Sprintf("Level %v: blinds %v/%v, ante %v, %v:%02d left.",
+		tournament.Level+1, level.SmallBlind, level.BigBlind, level.Ante,
+		tournament.Remaining/60, tournament.Remaining%60)
+}
+
+func (room *Room) saveTournament() {
+
+	if err := room.hub.roomService.UpdateTournament(room.Id, room.Tournament); err != nil {
+		log.Printf("Could not save the tournament of room %v: %v\n", room.Uri, err)
+	}
+}
+
+func (room *Room) broadcastTournament(sender string, text string) {
+	room.broadcastClientsInRoom(room.tournamentMessage(sender, text))
+}
+
+func (room *Room) tournamentMessage(sender string, text string) *Message {
+
+	return &Message{
+		Action:     UpdateTournamentAction,
+		Message:    text,
+		Pot:        room.Pot,
+		Sender:     sender,
+		Blinds:     &room.Blinds,
+		Tournament: room.Tournament,
+	}
+}
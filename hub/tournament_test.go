@@ -0,0 +1,236 @@
+package hub
+
+import (
+	"go-pokerchips/models"
+	"go-pokerchips/services"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+var testLevels = []models.BlindLevel{
+	{Minutes: 10, SmallBlind: 5, BigBlind: 10},
+	{Minutes: 15, SmallBlind: 10, BigBlind: 20, Ante: 2},
+}
+
+// newTestTournament loads a tournament hosted by ann with the players at the given stacks into a room
+// that is not run, the test calls it from its own goroutine.
+func newTestTournament(t *testing.T, stacks map[string]int) (*Room, services.RoomService) {
+
+	t.Helper()
+
+	roomService := services.NewMemoryRoomService()
+	dbRoom, err := roomService.CreateRoom(&models.CreateRoomInput{Creator: "ann", Type: models.RoomTypeTournament, Levels: testLevels})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	names := make([]string, 0, len(stacks))
+	for name := range stacks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if name != "ann" {
+			if err = roomService.RegisterUserInRoom(dbRoom.Id.Hex(), name, "", ""); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if change := stacks[name] - models.DefaultStartingStack; change != 0 {
+			if _, err = roomService.AdjustStack(dbRoom.Id.Hex(), "ann", name, change, "test"); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	if dbRoom, err = roomService.FindRoomByUri(dbRoom.Uri); err != nil {
+		t.Fatal(err)
+	}
+
+	return NewRoom(NewHub(roomService, nil, time.Minute, SlowClientResync), dbRoom), roomService
+}
+
+func TestLevelClock(t *testing.T) {
+
+	tests := []struct {
+		name      string
+		level     int
+		started   bool
+		paused    bool
+		left      time.Duration
+		wantLevel int
+		wantLeft  int
+		wantBlind models.Blinds
+	}{
+		{"not started", 0, false, false, -time.Second, 0, 600, testLevels[0].Blinds()},
+		{"counting down", 0, true, false, 30 * time.Second, 0, 30, testLevels[0].Blinds()},
+		{"paused", 0, true, true, -time.Second, 0, 600, testLevels[0].Blinds()},
+		{"next level", 0, true, false, -time.Second, 1, 900, testLevels[1].Blinds()},
+		{"last level has no time limit", 1, true, false, -time.Second, 1, 0, testLevels[0].Blinds()},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			room, roomService := newTestTournament(t, map[string]int{"ann": 1000, "bob": 1000})
+
+			tournament := room.Tournament
+			tournament.Level = test.level
+			tournament.Started = test.started
+			tournament.Paused = test.paused
+			room.levelEndsAt = time.Now().Add(test.left)
+
+			room.tick()
+
+			if tournament.Level != test.wantLevel || tournament.Remaining != test.wantLeft {
+				t.Errorf("level %v with %v seconds left, want level %v with %v", tournament.Level, tournament.Remaining, test.wantLevel, test.wantLeft)
+			}
+			if room.Blinds != test.wantBlind {
+				t.Errorf("blinds are %+v, want %+v", room.Blinds, test.wantBlind)
+			}
+
+			// The next hand is dealt with the blinds of the stored room
+			dbRoom, err := roomService.FindRoomByUri(room.Uri)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if dbRoom.Blinds != test.wantBlind {
+				t.Errorf("stored blinds are %+v, want %+v", dbRoom.Blinds, test.wantBlind)
+			}
+		})
+	}
+}
+
+func TestPauseAndResumeTournament(t *testing.T) {
+
+	room, roomService := newTestTournament(t, map[string]int{"ann": 1000, "bob": 1000})
+	ann := &Client{name: "ann", hub: room.hub, room: room}
+	bob := &Client{name: "bob", hub: room.hub, room: room}
+
+	steps := []struct {
+		name   string
+		client *Client
+		action string
+		err    error
+		paused bool
+	}{
+		{"pause before the start", ann, PauseTournamentAction, errTournamentNotStarted, false},
+		{"started by a player", bob, StartTournamentAction, errNotHost, false},
+		{"start", ann, StartTournamentAction, nil, false},
+		{"start again", ann, StartTournamentAction, errTournamentStarted, false},
+		{"resume a running clock", ann, ResumeTournamentAction, errTournamentRunning, false},
+		{"paused by a player", bob, PauseTournamentAction, errNotHost, false},
+		{"pause", ann, PauseTournamentAction, nil, true},
+		{"pause again", ann, PauseTournamentAction, errTournamentPaused, true},
+		{"resume", ann, ResumeTournamentAction, nil, false},
+	}
+
+	for _, step := range steps {
+
+		var err error
+		switch step.action {
+		case StartTournamentAction:
+			err = room.startTournament(step.client)
+		case PauseTournamentAction:
+			err = room.pauseTournament(step.client, true)
+		case ResumeTournamentAction:
+			err = room.pauseTournament(step.client, false)
+		}
+
+		if err != step.err {
+			t.Fatalf("%v: got error %v, want %v", step.name, err, step.err)
+		}
+		if room.Tournament.Paused != step.paused {
+			t.Fatalf("%v: paused is %v, want %v", step.name, room.Tournament.Paused, step.paused)
+		}
+
+		if step.action == PauseTournamentAction && err == nil {
+			// A paused clock keeps the time that was left, even once it has passed
+			room.levelEndsAt = time.Now().Add(-time.Hour)
+			room.tick()
+			if left := room.Tournament.Remaining; left < 599 || left > 600 {
+				t.Fatalf("%v: %v seconds left, want the whole level", step.name, left)
+			}
+		}
+	}
+
+	if left := room.secondsLeft(); left < 599 || left > 600 {
+		t.Errorf("%v seconds left once resumed, want the whole level", left)
+	}
+
+	dbRoom, err := roomService.FindRoomByUri(room.Uri)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tournament := dbRoom.Tournament; !tournament.Started || tournament.Paused {
+		t.Errorf("stored tournament is started %v and paused %v, want it running", tournament.Started, tournament.Paused)
+	}
+}
+
+func TestEliminationOrder(t *testing.T) {
+
+	tests := []struct {
+		name       string
+		handStacks map[string]int
+		stacks     map[string]int
+		want       []models.Finisher
+		finished   bool
+	}{
+		{
+			name:       "nobody busted",
+			handStacks: map[string]int{"ann": 1000, "bob": 1000, "cat": 1000},
+			stacks:     map[string]int{"ann": 1500, "bob": 500, "cat": 1000},
+			want:       []models.Finisher{},
+		},
+		{
+			name:       "one busted",
+			handStacks: map[string]int{"ann": 1000, "bob": 1000, "cat": 1000},
+			stacks:     map[string]int{"ann": 2000, "bob": 0, "cat": 1000},
+			want:       []models.Finisher{{Name: "bob", Position: 3}},
+		},
+		{
+			name:       "smaller starting stack finishes lower",
+			handStacks: map[string]int{"ann": 300, "bob": 100, "cat": 200, "dan": 50},
+			stacks:     map[string]int{"ann": 650, "bob": 0, "cat": 0, "dan": 0},
+			want: []models.Finisher{
+				{Name: "dan", Position: 4}, {Name: "bob", Position: 3}, {Name: "cat", Position: 2}, {Name: "ann", Position: 1},
+			},
+			finished: true,
+		},
+		{
+			name:       "same starting stack in name order",
+			handStacks: map[string]int{"ann": 500, "bob": 100, "cat": 100},
+			stacks:     map[string]int{"ann": 700, "bob": 0, "cat": 0},
+			want: []models.Finisher{
+				{Name: "bob", Position: 3}, {Name: "cat", Position: 2}, {Name: "ann", Position: 1},
+			},
+			finished: true,
+		},
+		{
+			name:       "players sitting the hand out stay in",
+			handStacks: map[string]int{"ann": 1000, "bob": 1000},
+			stacks:     map[string]int{"ann": 2000, "bob": 0, "cat": 1000},
+			want:       []models.Finisher{{Name: "bob", Position: 3}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			room, _ := newTestTournament(t, test.stacks)
+			room.Tournament.Started = true
+			room.handStacks = test.handStacks
+
+			room.eliminate()
+
+			if finishers := room.Tournament.Finishers; !reflect.DeepEqual(finishers, test.want) {
+				t.Errorf("got finishers %v, want %v", finishers, test.want)
+			}
+			if room.Tournament.Finished != test.finished {
+				t.Errorf("finished is %v, want %v", room.Tournament.Finished, test.finished)
+			}
+		})
+	}
+}
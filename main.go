@@ -2,7 +2,8 @@ package main
 
 import (
 	"context"
-	"encoding/json"
+	"crypto/rand"
+	"crypto/subtle"
 	"fmt"
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
@@ -15,6 +16,9 @@ import (
 	"go.mongodb.org/mongo-driver/mongo"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 )
 
@@ -28,7 +32,9 @@ var (
 	mongoClient *mongo.Client
 
 	roomCollection      *mongo.Collection
+	ledgerCollection    *mongo.Collection
 	roomService         services.RoomService
+	sessionService      *services.SessionService
 	roomController      controllers.RoomController
 	roomRouteController routers.RoomRouteController
 )
@@ -43,22 +49,65 @@ func main() {
 	ctx, cancel := context.WithTimeout(context.Background(), TIMEOUT*time.Second)
 	defer cancel()
 
-	// Get mongodb connection
-	mongoClient = config.InitMongo(cfg, ctx)
-	defer func() {
-		if err = mongoClient.Disconnect(ctx); err != nil {
-			panic(err)
+	// Register all routes, controllers and services
+	switch cfg.Storage {
+	case config.StorageMemory:
+		log.Println("Using in-memory storage, rooms will not survive a restart.")
+		roomService = services.NewMemoryRoomService()
+	case config.StorageSQLite:
+		sqliteDB := config.InitSQLite(cfg)
+		defer sqliteDB.Close()
+
+		if err = services.MigrateSQLite(sqliteDB); err != nil {
+			log.Fatal("Could not migrate SQLite database ", err)
 		}
-	}()
+		roomService = services.NewSQLiteRoomService(sqliteDB)
+	case config.StorageMongo:
+		// Get mongodb connection
+		mongoClient = config.InitMongo(cfg, ctx)
+		defer func() {
+			// ctx only covers the start up, the server has been running for a while by now
+			if err = mongoClient.Disconnect(context.Background()); err != nil {
+				panic(err)
+			}
+		}()
+
+		db := mongoClient.Database("poker-chips")
+		roomCollection = db.Collection("rooms")
+		ledgerCollection = db.Collection("ledger")
+		roomService = services.NewRoomService(roomCollection, ledgerCollection)
+	default:
+		log.Fatalf("Unknown storage backend %q", cfg.Storage)
+	}
 
-	// Register all routes, controllers and services
-	db := mongoClient.Database("poker-chips")
-	roomCollection = db.Collection("rooms")
-	roomService = services.NewRoomService(roomCollection)
-	roomController = controllers.NewRoomController(roomService)
-	roomRouteController = routers.NewRoomRouteController(roomController)
+	secret := []byte(cfg.SessionSecret)
+	if len(secret) == 0 {
+		log.Println("SESSION_SECRET is not set, using a random one. Sessions will not survive a restart.")
+		secret = make([]byte, 32)
+		if _, err = rand.Read(secret); err != nil {
+			log.Fatal("Could not generate a session secret ", err)
+		}
+	}
+	sessionService = services.NewSessionService(secret, time.Duration(cfg.SessionHours)*time.Hour)
+
+	// Without Redis a single instance runs and has no one to share the rooms with
+	var pubsub hub.PubSub
+	if cfg.RedisUri != "" {
+		redisClient := config.InitRedis(cfg, ctx)
+		defer redisClient.Close()
+		pubsub = hub.NewRedisPubSub(redisClient)
+	}
+
+	if cfg.SlowClientPolicy != hub.SlowClientResync && cfg.SlowClientPolicy != hub.SlowClientDisconnect {
+		log.Fatalf("Unknown slow client policy %q", cfg.SlowClientPolicy)
+	}
 
 	// Start the websocket hub
+	h := hub.NewHub(roomService, pubsub, time.Duration(cfg.RoomIdleSeconds)*time.Second, cfg.SlowClientPolicy)
+
+	roomController = controllers.NewRoomController(roomService, sessionService, h, cfg.SessionSecure)
+	roomRouteController = routers.NewRoomRouteController(roomController)
+
 	r = gin.Default()
 	r.Use(cors.New(cors.Config{
 		AllowOrigins:     []string{"http://localhost:8081"},
@@ -67,8 +116,6 @@ func main() {
 		AllowCredentials: true,
 	}))
 
-	h := hub.NewHub(roomService)
-
 	r.GET("/ping", func(c *gin.Context) {
 		c.JSON(http.StatusOK, gin.H{"status": "success", "message": "pong"})
 	})
@@ -78,33 +125,84 @@ func main() {
 		roomRouteController.RoomRoute(apiRouter)
 	}
 
-	r.GET("/ws", func(c *gin.Context) {
+	// JSON Schema of the websocket messages of the latest protocol version
+	r.GET("/ws/schema", func(c *gin.Context) {
+		c.JSON(http.StatusOK, hub.Schema())
+	})
 
-		var session string
-		session, err = c.Cookie("session")
+	// Queue metrics of the connected clients, for operators only
+	if cfg.MetricsToken != "" {
+		r.GET("/ws/metrics", func(c *gin.Context) {
+			token := []byte("Bearer " + cfg.MetricsToken)
+			if subtle.ConstantTimeCompare([]byte(c.GetHeader("Authorization")), token) != 1 {
+				c.JSON(http.StatusUnauthorized, gin.H{"status": "fail", "message": "invalid metrics token"})
+				return
+			}
+			c.JSON(http.StatusOK, gin.H{"status": "success", "data": h.Metrics()})
+		})
+	}
 
-		var roomUser *models.JoinRoomInput
-		if err = json.Unmarshal([]byte(session), &roomUser); err != nil {
-			log.Println(err)
+	r.GET("/ws", func(c *gin.Context) {
+
+		// Only a signed session says who the player is, a forged one could act as anybody in the room
+		session, err := c.Cookie(controllers.SessionCookie)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"status": "fail", "message": services.ErrInvalidSession.Error()})
+			return
 		}
 
-		foundRoom := h.FindRoomByUri(roomUser.Uri)
+		roomUser, err := sessionService.Verify(session)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"status": "fail", "message": err.Error()})
+			return
+		}
 
-		if foundRoom == nil {
+		// Get room from database
+		var room *models.DBRoom
+		room, err = roomService.FindRoomByUri(roomUser.Uri)
+		if err != nil {
+			fmt.Println(err)
+			c.JSON(http.StatusNotFound, gin.H{"status": "fail", "message": err.Error()})
+			return
+		}
 
-			// Get room from database
-			var room *models.DBRoom
-			room, err = roomService.FindRoomByUri(roomUser.Uri)
-			if err != nil {
-				fmt.Println(err)
-			}
+		if room.Closed {
+			c.JSON(http.StatusGone, gin.H{"status": "fail", "message": services.ErrRoomClosed.Error()})
+			return
+		}
 
-			// Create the room in the memory
-			foundRoom = h.CreateRoom(room)
+		// Kicked players keep their signed session but are no longer in the record
+		if _, ok := room.Record[roomUser.User]; !ok {
+			c.JSON(http.StatusForbidden, gin.H{"status": "fail", "message": services.ErrUserNotRegistered.Error()})
+			return
 		}
 
-		hub.ServeWS(h, foundRoom, roomUser.User, c)
+		hub.ServeWS(h, room, roomUser.User, c)
 	})
 
-	log.Fatal(r.Run("localhost:" + cfg.Port))
+	srv := &http.Server{
+		Addr:    "localhost:" + cfg.Port,
+		Handler: r,
+	}
+
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
+
+	// Rooms save their state before the server stops
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	<-quit
+
+	log.Println("Shutting down the server")
+
+	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), TIMEOUT*time.Second)
+	defer cancelShutdown()
+
+	if err = srv.Shutdown(shutdownCtx); err != nil {
+		log.Println("Could not shut down the server gracefully ", err)
+	}
+	h.Shutdown()
 }
@@ -0,0 +1,45 @@
+package models
+
+import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
+
+// Types of chip movements recorded in the ledger.
+const (
+	LedgerRegister = "register"
+	LedgerBet      = "bet"
+	LedgerTake     = "take"
+	LedgerAward    = "award"
+	LedgerAdjust   = "adjust"
+	LedgerKick     = "kick"
+)
+
+// Host actions that move no chips are recorded in the ledger too, so it is the full history of the room.
+const (
+	LedgerTransferHost = "transfer-host"
+	LedgerLock         = "lock"
+	LedgerUnlock       = "unlock"
+	LedgerClose        = "close"
+	LedgerSwapSeats    = "swap-seats"
+)
+
+// LedgerEntry is an immutable record of a single chip movement in a room.
+type LedgerEntry struct {
+	Id            primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
+	RoomId        primitive.ObjectID `json:"roomId" bson:"roomId"`
+	Uri           string             `json:"uri" bson:"uri"`
+	Type          string             `json:"type" bson:"type"`
+	Actor         string             `json:"actor" bson:"actor"`
+	Amount        int                `json:"amount" bson:"amount"`
+	BalanceBefore int                `json:"balanceBefore" bson:"balanceBefore"`
+	BalanceAfter  int                `json:"balanceAfter" bson:"balanceAfter"`
+	PotBefore     int                `json:"potBefore" bson:"potBefore"`
+	PotAfter      int                `json:"potAfter" bson:"potAfter"`
+
+	// The host who made the change and why, for host actions only
+	Host   string `json:"host,omitempty" bson:"host,omitempty"`
+	Reason string `json:"reason,omitempty" bson:"reason,omitempty"`
+
+	CreatedAt time.Time `json:"createdAt" bson:"createdAt"`
+}
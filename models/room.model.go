@@ -6,25 +6,134 @@ import (
 )
 
 type DBRoom struct {
-	Id        primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
-	Uri       string             `json:"uri" bson:"uri"`
-	Pot       int                `json:"pot" bson:"pot"`
-	Record    map[string]int     `json:"record" bson:"record"`
-	CreatedAt time.Time          `json:"createdAt" bson:"createdAt"`
-	UpdatedAt time.Time          `json:"updatedAt" bson:"updatedAt"`
+	Id         primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
+	Uri        string             `json:"uri" bson:"uri"`
+	Creator    string             `json:"creator" bson:"name"`
+	Host       string             `json:"host" bson:"host"`
+	Type       string             `json:"type" bson:"type"`
+	Pot        int                `json:"pot" bson:"pot"`
+	Pots       []Pot              `json:"pots" bson:"pots"`
+	Record     map[string]int     `json:"record" bson:"record"`
+	Blinds     Blinds             `json:"blinds" bson:"blinds"`
+	Dealer     string             `json:"dealer" bson:"dealer"`
+	Settings   RoomSettings       `json:"settings" bson:"settings"`
+	Tournament *Tournament        `json:"tournament,omitempty" bson:"tournament,omitempty"`
+	Locked     bool               `json:"locked" bson:"locked"`
+	Closed     bool               `json:"closed" bson:"closed"`
+	Kicked     []string           `json:"kicked" bson:"kicked"`
+	Seats      []Seat             `json:"seats" bson:"seats"`
+	Version    int                `json:"version" bson:"version"`
+	CreatedAt  time.Time          `json:"createdAt" bson:"createdAt"`
+	UpdatedAt  time.Time          `json:"updatedAt" bson:"updatedAt"`
+
+	// Bcrypt hash of the room password, empty when the room has none
+	PasswordHash string   `json:"-" bson:"passwordHash,omitempty"`
+	Invites      []Invite `json:"-" bson:"invites"`
+}
+
+// Pot is the main pot or a side pot of a hand, with the players who can win it.
+// When DBRoom.Pots is empty the whole DBRoom.Pot is a single undivided pot.
+type Pot struct {
+	Amount   int      `json:"amount" bson:"amount"`
+	Eligible []string `json:"eligible" bson:"eligible"`
+}
+
+// Seat is a place at the table taken by a player. Seats are numbered from 1 to the most players
+// of the room and DBRoom.Seats only lists the taken ones, in seat order, which is the turn order.
+// A player sitting out, or away because they lost their connection, keeps their seat but is not dealt in.
+type Seat struct {
+	Number     int    `json:"number" bson:"number"`
+	Player     string `json:"player" bson:"player"`
+	SittingOut bool   `json:"sittingOut" bson:"sittingOut"`
+	Away       bool   `json:"away" bson:"away"`
+}
+
+// Blinds are the forced bets posted at the start of every hand. Zero means none.
+type Blinds struct {
+	SmallBlind int `json:"smallBlind" bson:"smallBlind"`
+	BigBlind   int `json:"bigBlind" bson:"bigBlind"`
+	Ante       int `json:"ante" bson:"ante"`
+}
+
+// SeatOf returns the seat of a player, or nil when they are not seated.
+func (room *DBRoom) SeatOf(name string) *Seat {
+
+	for i := range room.Seats {
+		if room.Seats[i].Player == name {
+			return &room.Seats[i]
+		}
+	}
+
+	return nil
+}
+
+// HostName is the player who moderates the room. Rooms from before there was a host role
+// are moderated by their creator.
+func (room *DBRoom) HostName() string {
+
+	if room.Host == "" {
+		return room.Creator
+	}
+
+	return room.Host
 }
 
 type CreateRoomInput struct {
-	Creator   string         `json:"name" bson:"name"`
-	Uri       string         `json:"uri" bson:"uri"`
-	Record    map[string]int `json:"record" bson:"record"`
-	CreatedAt time.Time      `json:"createdAt" bson:"createdAt"`
-	UpdatedAt time.Time      `json:"updatedAt" bson:"updatedAt"`
+	Creator string         `json:"name" bson:"name"`
+	Uri     string         `json:"uri" bson:"uri"`
+	Type    string         `json:"type" bson:"type"`
+	Record  map[string]int `json:"record" bson:"record"`
+
+	Settings RoomSettings `json:"settings" bson:"settings"`
+
+	// Blind schedule of a tournament room
+	Levels []BlindLevel `json:"levels" bson:"-"`
+
+	// Optional password players need to join the room
+	Password string `json:"password" bson:"-"`
+
+	// Derived from the input by the service
+	Host         string      `json:"-" bson:"host"`
+	PasswordHash string      `json:"-" bson:"passwordHash,omitempty"`
+	Blinds       Blinds      `json:"-" bson:"blinds"`
+	Tournament   *Tournament `json:"-" bson:"tournament,omitempty"`
+	Seats        []Seat      `json:"-" bson:"seats"`
+
+	CreatedAt time.Time `json:"createdAt" bson:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt" bson:"updatedAt"`
 }
 
 type JoinRoomInput struct {
 	User string `json:"name"`
 	Uri  string `json:"uri"`
+
+	// A password protected room needs either its password or an invite token
+	Password string `json:"password,omitempty"`
+	Invite   string `json:"invite,omitempty"`
+}
+
+// Invite lets one player join a room without its password, until it expires.
+// Only a hash of the token is stored.
+type Invite struct {
+	TokenHash string    `json:"tokenHash" bson:"tokenHash"`
+	CreatedBy string    `json:"createdBy" bson:"createdBy"`
+	ExpiresAt time.Time `json:"expiresAt" bson:"expiresAt"`
+}
+
+type CreateInviteInput struct {
+	Minutes int `json:"minutes"`
+}
+
+// ModerateRoomInput is a host command, Name is the player it applies to.
+// Swapping seats moves the players of Seat and OtherSeat, either of which can be empty.
+// Reason is required to adjust a stack and optional for a kick, both record it in the ledger.
+type ModerateRoomInput struct {
+	Action    string `json:"action" binding:"required"`
+	Name      string `json:"name"`
+	Amount    int    `json:"amount"`
+	Reason    string `json:"reason"`
+	Seat      int    `json:"seat"`
+	OtherSeat int    `json:"otherSeat"`
 }
 
 type UpdatePotResponse struct {
@@ -32,3 +141,11 @@ type UpdatePotResponse struct {
 	Sender       string `json:"name"`
 	CurrentChips int    `json:"currentChips"`
 }
+
+type AwardPotResponse struct {
+	Pot          int            `json:"pot"`
+	Pots         []Pot          `json:"pots"`
+	Amount       int            `json:"amount"`
+	Payouts      map[string]int `json:"payouts"`
+	CurrentChips map[string]int `json:"currentChips"`
+}
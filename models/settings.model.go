@@ -0,0 +1,52 @@
+package models
+
+// Defaults and limits of the room settings
+const (
+	DefaultStartingStack = 1000
+	DefaultMaxPlayers    = 9
+	MaxPlayersLimit      = 10
+	MinActionSeconds     = 5
+	MaxActionSeconds     = 600
+	MaxTimeBankSeconds   = 3600
+)
+
+// RoomSettings are chosen by the creator of a room and cannot change afterwards.
+type RoomSettings struct {
+	// Chips every player gets when joining the room
+	StartingStack int `json:"startingStack" bson:"startingStack"`
+
+	// Most players that can join the room, the creator included
+	MaxPlayers int `json:"maxPlayers" bson:"maxPlayers"`
+
+	// Blinds and ante a cash game starts with, tournaments follow their level schedule
+	Blinds Blinds `json:"blinds" bson:"blinds"`
+
+	// Whether players can still join a tournament once it has started, nobody joins one that is over.
+	// Players can join a cash game at any time.
+	LateJoin bool `json:"lateJoin" bson:"lateJoin"`
+
+	// What one chip is worth in real currency, for settling up after the game. The room state tells
+	// what the stack of each player is worth when it is set.
+	ChipValue float64 `json:"chipValue" bson:"chipValue"`
+
+	// Seconds a player has to act on their turn before the server checks or folds for them, 0 for no clock
+	ActionSeconds int `json:"actionSeconds" bson:"actionSeconds"`
+
+	// Extra seconds each player can ask for on top of the action clock, for as long as the room is loaded
+	TimeBankSeconds int `json:"timeBankSeconds" bson:"timeBankSeconds"`
+}
+
+// WithDefaults fills in the settings that were left out. Rooms created before there were
+// settings get the defaults too.
+func (settings RoomSettings) WithDefaults() RoomSettings {
+
+	if settings.StartingStack == 0 {
+		settings.StartingStack = DefaultStartingStack
+	}
+
+	if settings.MaxPlayers == 0 {
+		settings.MaxPlayers = DefaultMaxPlayers
+	}
+
+	return settings
+}
@@ -0,0 +1,56 @@
+package models
+
+// Room types
+const (
+	RoomTypeCash       = "cash"
+	RoomTypeTournament = "tournament"
+)
+
+// BlindLevel is one step of a tournament blind schedule.
+type BlindLevel struct {
+	Minutes    int `json:"minutes" bson:"minutes"`
+	SmallBlind int `json:"smallBlind" bson:"smallBlind"`
+	BigBlind   int `json:"bigBlind" bson:"bigBlind"`
+	Ante       int `json:"ante" bson:"ante"`
+}
+
+func (level BlindLevel) Blinds() Blinds {
+	return Blinds{SmallBlind: level.SmallBlind, BigBlind: level.BigBlind, Ante: level.Ante}
+}
+
+// Finisher is a player who finished a tournament, the winner has position 1.
+type Finisher struct {
+	Name     string `json:"name" bson:"name"`
+	Position int    `json:"position" bson:"position"`
+}
+
+// Tournament is the blind schedule and progress of a sit-and-go.
+type Tournament struct {
+	Levels []BlindLevel `json:"levels" bson:"levels"`
+
+	// Index in Levels of the current level
+	Level int `json:"level" bson:"level"`
+
+	// Seconds left in the current level. While the clock runs this is only
+	// accurate at the moment it was saved or broadcast.
+	Remaining int `json:"remaining" bson:"remaining"`
+
+	Started  bool `json:"started" bson:"started"`
+	Paused   bool `json:"paused" bson:"paused"`
+	Finished bool `json:"finished" bson:"finished"`
+
+	// Players in the order they finished, last place first
+	Finishers []Finisher `json:"finishers" bson:"finishers"`
+}
+
+// HasFinished reports whether name is already out of the tournament or won it.
+func (tournament *Tournament) HasFinished(name string) bool {
+
+	for _, finisher := range tournament.Finishers {
+		if finisher.Name == name {
+			return true
+		}
+	}
+
+	return false
+}
@@ -16,6 +16,9 @@ func NewRoomRouteController(roomController controllers.RoomController) RoomRoute
 func (rc *RoomRouteController) RoomRoute(rg *gin.RouterGroup) {
 	router := rg.Group("/room")
 	router.GET("/get/:uri", rc.roomController.GetRoom)
+	router.GET("/:uri/ledger", rc.roomController.GetLedger)
+	router.POST("/:uri/moderate", rc.roomController.Moderate)
+	router.POST("/:uri/invite", rc.roomController.CreateInvite)
 	router.POST("/join", rc.roomController.JoinRoom)
 	router.POST("/create", rc.roomController.CreateRoom)
 }
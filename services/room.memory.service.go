@@ -0,0 +1,440 @@
+package services
+
+import (
+	"github.com/dchest/uniuri"
+	"go-pokerchips/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"sync"
+	"time"
+)
+
+// MemoryRoomService keeps rooms and the ledger in process memory.
+// It is meant for tests and offline games; nothing survives a restart.
+type MemoryRoomService struct {
+	mu     sync.Mutex
+	rooms  map[primitive.ObjectID]*models.DBRoom
+	ledger map[primitive.ObjectID][]*models.LedgerEntry
+}
+
+func NewMemoryRoomService() RoomService {
+	return &MemoryRoomService{
+		rooms:  make(map[primitive.ObjectID]*models.DBRoom),
+		ledger: make(map[primitive.ObjectID][]*models.LedgerEntry),
+	}
+}
+
+func (ms *MemoryRoomService) CreateRoom(room *models.CreateRoomInput) (*models.DBRoom, error) {
+
+	if err := prepareRoom(room); err != nil {
+		return nil, err
+	}
+
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	room.Uri = uniuri.NewLen(5)
+	room.CreatedAt = time.Now()
+	room.UpdatedAt = room.CreatedAt
+
+	if ms.findByUri(room.Uri) != nil {
+		return nil, ErrRoomExists
+	}
+
+	newRoom := &models.DBRoom{
+		Id:           primitive.NewObjectID(),
+		Uri:          room.Uri,
+		Creator:      room.Creator,
+		Type:         room.Type,
+		Pots:         []models.Pot{},
+		Record:       make(map[string]int),
+		Host:         room.Host,
+		Blinds:       room.Blinds,
+		PasswordHash: room.PasswordHash,
+		Settings:     room.Settings,
+		Tournament:   copyTournament(room.Tournament),
+		Seats:        append([]models.Seat{}, room.Seats...),
+		CreatedAt:    room.CreatedAt,
+		UpdatedAt:    room.UpdatedAt,
+	}
+	for name, chips := range room.Record {
+		newRoom.Record[name] = chips
+	}
+	ms.rooms[newRoom.Id] = newRoom
+	ms.writeLedger(registerEntries(newRoom))
+
+	return copyRoom(newRoom), nil
+}
+
+func (ms *MemoryRoomService) FindRoomById(id string) (*models.DBRoom, error) {
+
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	room, err := ms.findById(id)
+	if err != nil {
+		return nil, err
+	}
+
+	return copyRoom(room), nil
+}
+
+func (ms *MemoryRoomService) FindRoomByUri(uri string) (*models.DBRoom, error) {
+
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	room := ms.findByUri(uri)
+	if room == nil {
+		return nil, ErrRoomNotFound
+	}
+
+	return copyRoom(room), nil
+}
+
+func (ms *MemoryRoomService) RegisterUserInRoom(id string, name string, password string, invite string) error {
+
+	if !isValidUserName(name) {
+		return ErrInvalidUserName
+	}
+
+	room, err := ms.FindRoomById(id)
+	if err != nil {
+		return err
+	}
+
+	if err = checkPassword(room, password, invite); err != nil {
+		return err
+	}
+
+	_, err = ms.update(id, func(room *models.DBRoom) ([]*models.LedgerEntry, error) {
+		return applyRegister(room, name, invite)
+	})
+
+	return err
+}
+
+func (ms *MemoryRoomService) AddPot(id string, name string, chips int) (*models.UpdatePotResponse, error) {
+
+	if chips <= 0 {
+		return nil, ErrInvalidAmount
+	}
+
+	if !isValidUserName(name) {
+		return nil, ErrInvalidUserName
+	}
+
+	room, err := ms.update(id, func(room *models.DBRoom) ([]*models.LedgerEntry, error) {
+		return applyAddPot(room, name, chips)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return potResponse(room, name), nil
+}
+
+func (ms *MemoryRoomService) TakePot(id string, name string, chips int) (*models.UpdatePotResponse, error) {
+
+	if chips <= 0 {
+		return nil, ErrInvalidAmount
+	}
+
+	if !isValidUserName(name) {
+		return nil, ErrInvalidUserName
+	}
+
+	room, err := ms.update(id, func(room *models.DBRoom) ([]*models.LedgerEntry, error) {
+		return applyTakePot(room, name, chips)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return potResponse(room, name), nil
+}
+
+func (ms *MemoryRoomService) UpdatePots(id string, pots []models.Pot) error {
+
+	_, err := ms.update(id, func(room *models.DBRoom) ([]*models.LedgerEntry, error) {
+		return applyUpdatePots(room, pots)
+	})
+
+	return err
+}
+
+func (ms *MemoryRoomService) AwardPot(id string, index int, winners []string) (*models.AwardPotResponse, error) {
+
+	var resp *models.AwardPotResponse
+
+	_, err := ms.update(id, func(room *models.DBRoom) ([]*models.LedgerEntry, error) {
+		entries, r, err := applyAwardPot(room, index, winners)
+		resp = r
+		return entries, err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return resp, nil
+}
+
+func (ms *MemoryRoomService) UpdateBlinds(id string, blinds models.Blinds) error {
+
+	if !isValidBlinds(blinds) {
+		return ErrInvalidBlinds
+	}
+
+	_, err := ms.update(id, func(room *models.DBRoom) ([]*models.LedgerEntry, error) {
+		room.Blinds = blinds
+		return nil, nil
+	})
+
+	return err
+}
+
+func (ms *MemoryRoomService) UpdateDealer(id string, dealer string) error {
+
+	_, err := ms.update(id, func(room *models.DBRoom) ([]*models.LedgerEntry, error) {
+		room.Dealer = dealer
+		return nil, nil
+	})
+
+	return err
+}
+
+func (ms *MemoryRoomService) UpdateTournament(id string, tournament *models.Tournament) error {
+
+	_, err := ms.update(id, func(room *models.DBRoom) ([]*models.LedgerEntry, error) {
+		room.Tournament = copyTournament(tournament)
+		return nil, nil
+	})
+
+	return err
+}
+
+func (ms *MemoryRoomService) KickUser(id string, host string, name string, reason string) error {
+
+	_, err := ms.update(id, func(room *models.DBRoom) ([]*models.LedgerEntry, error) {
+		return applyKick(room, host, name, reason)
+	})
+
+	return err
+}
+
+func (ms *MemoryRoomService) TransferHost(id string, host string, name string) error {
+
+	_, err := ms.update(id, func(room *models.DBRoom) ([]*models.LedgerEntry, error) {
+		return applyTransferHost(room, host, name)
+	})
+
+	return err
+}
+
+// AdjustStack corrects the stack of a player and returns their new balance.
+func (ms *MemoryRoomService) AdjustStack(id string, host string, name string, amount int, reason string) (int, error) {
+
+	room, err := ms.update(id, func(room *models.DBRoom) ([]*models.LedgerEntry, error) {
+		return applyAdjustStack(room, host, name, amount, reason)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return room.Record[name], nil
+}
+
+func (ms *MemoryRoomService) LockRoom(id string, host string, locked bool) error {
+
+	_, err := ms.update(id, func(room *models.DBRoom) ([]*models.LedgerEntry, error) {
+		return applyLock(room, host, locked)
+	})
+
+	return err
+}
+
+func (ms *MemoryRoomService) CloseRoom(id string, host string) error {
+
+	_, err := ms.update(id, func(room *models.DBRoom) ([]*models.LedgerEntry, error) {
+		return applyClose(room, host)
+	})
+
+	return err
+}
+
+// CreateInvite returns a new invite token for the room that can be used once before it expires.
+func (ms *MemoryRoomService) CreateInvite(id string, host string, ttl time.Duration) (string, time.Time, error) {
+
+	if ttl <= 0 {
+		return "", time.Time{}, ErrInvalidAmount
+	}
+
+	token := uniuri.NewLen(24)
+	expiresAt := time.Now().Add(ttl)
+
+	_, err := ms.update(id, func(room *models.DBRoom) ([]*models.LedgerEntry, error) {
+		return applyCreateInvite(room, host, token, expiresAt)
+	})
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	return token, expiresAt, nil
+}
+
+func (ms *MemoryRoomService) TakeSeat(id string, name string, number int) ([]models.Seat, error) {
+
+	room, err := ms.update(id, func(room *models.DBRoom) ([]*models.LedgerEntry, error) {
+		return applyTakeSeat(room, name, number)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return room.Seats, nil
+}
+
+func (ms *MemoryRoomService) StandUp(id string, name string) ([]models.Seat, error) {
+
+	room, err := ms.update(id, func(room *models.DBRoom) ([]*models.LedgerEntry, error) {
+		return applyStandUp(room, name)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return room.Seats, nil
+}
+
+func (ms *MemoryRoomService) SitOut(id string, name string, sittingOut bool) ([]models.Seat, error) {
+
+	room, err := ms.update(id, func(room *models.DBRoom) ([]*models.LedgerEntry, error) {
+		return applySitOut(room, name, sittingOut)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return room.Seats, nil
+}
+
+func (ms *MemoryRoomService) SetAway(id string, name string, away bool) ([]models.Seat, error) {
+
+	room, err := ms.update(id, func(room *models.DBRoom) ([]*models.LedgerEntry, error) {
+		return applySetAway(room, name, away)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return room.Seats, nil
+}
+
+func (ms *MemoryRoomService) SwapSeats(id string, host string, first int, second int) ([]models.Seat, error) {
+
+	room, err := ms.update(id, func(room *models.DBRoom) ([]*models.LedgerEntry, error) {
+		return applySwapSeats(room, host, first, second)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return room.Seats, nil
+}
+
+func (ms *MemoryRoomService) FindLedgerByUri(uri string, page int64, limit int64) ([]*models.LedgerEntry, error) {
+
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	room := ms.findByUri(uri)
+	if room == nil {
+		return nil, ErrRoomNotFound
+	}
+
+	if page < 1 {
+		page = 1
+	}
+
+	entries := make([]*models.LedgerEntry, 0)
+	all := ms.ledger[room.Id]
+
+	start := (page - 1) * limit
+	if start >= int64(len(all)) {
+		return entries, nil
+	}
+
+	end := start + limit
+	if end > int64(len(all)) {
+		end = int64(len(all))
+	}
+
+	for _, entry := range all[start:end] {
+		e := *entry
+		entries = append(entries, &e)
+	}
+
+	return entries, nil
+}
+
+// findById returns the stored room, not a copy. The caller must hold ms.mu.
+func (ms *MemoryRoomService) findById(id string) (*models.DBRoom, error) {
+
+	objId, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+
+	room, ok := ms.rooms[objId]
+	if !ok {
+		return nil, ErrRoomNotFound
+	}
+
+	return room, nil
+}
+
+// findByUri returns the stored room, not a copy. The caller must hold ms.mu.
+func (ms *MemoryRoomService) findByUri(uri string) *models.DBRoom {
+
+	for _, room := range ms.rooms {
+		if room.Uri == uri {
+			return room
+		}
+	}
+
+	return nil
+}
+
+// update applies the mutation to a copy of the room and only stores the copy if the mutation succeeds.
+// It returns a copy of the updated room.
+func (ms *MemoryRoomService) update(id string, mutate roomMutation) (*models.DBRoom, error) {
+
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	stored, err := ms.findById(id)
+	if err != nil {
+		return nil, err
+	}
+
+	room := copyRoom(stored)
+	entries, err := mutate(room)
+	if err != nil {
+		return nil, err
+	}
+
+	room.Version++
+	room.UpdatedAt = time.Now()
+	ms.rooms[room.Id] = room
+	ms.writeLedger(entries)
+
+	return copyRoom(room), nil
+}
+
+// writeLedger appends entries to their room's ledger. The caller must hold ms.mu.
+func (ms *MemoryRoomService) writeLedger(entries []*models.LedgerEntry) {
+	for _, entry := range entries {
+		entry.Id = primitive.NewObjectID()
+		entry.CreatedAt = time.Now()
+		ms.ledger[entry.RoomId] = append(ms.ledger[entry.RoomId], entry)
+	}
+}
@@ -0,0 +1,797 @@
+package services
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"go-pokerchips/models"
+	"golang.org/x/crypto/bcrypt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// prepareRoom validates a new room and fills in what is derived from the input.
+func prepareRoom(room *models.CreateRoomInput) error {
+
+	if !isValidUserName(room.Creator) {
+		return ErrInvalidUserName
+	}
+
+	if room.Password != "" {
+		// bcrypt ignores everything after the first 72 bytes
+		if len(room.Password) > 72 {
+			return ErrInvalidPassword
+		}
+
+		hash, err := bcrypt.GenerateFromPassword([]byte(room.Password), bcrypt.DefaultCost)
+		if err != nil {
+			return err
+		}
+		room.PasswordHash = string(hash)
+		room.Password = ""
+	}
+
+	room.Settings = room.Settings.WithDefaults()
+	if !isValidSettings(room.Settings) {
+		return ErrInvalidSettings
+	}
+
+	// The creator is the first player and the host of the room, sitting in the first seat
+	room.Record = map[string]int{room.Creator: room.Settings.StartingStack}
+	room.Host = room.Creator
+	room.Seats = []models.Seat{{Number: 1, Player: room.Creator}}
+
+	switch room.Type {
+	case "", models.RoomTypeCash:
+		room.Type = models.RoomTypeCash
+		room.Blinds = room.Settings.Blinds
+		room.Tournament = nil
+	case models.RoomTypeTournament:
+		if len(room.Levels) == 0 {
+			return ErrInvalidLevels
+		}
+		for _, level := range room.Levels {
+			if level.Minutes <= 0 || level.BigBlind <= 0 || !isValidBlinds(level.Blinds()) {
+				return ErrInvalidLevels
+			}
+		}
+		room.Blinds = room.Levels[0].Blinds()
+		room.Tournament = &models.Tournament{
+			Levels:    room.Levels,
+			Remaining: room.Levels[0].Minutes * 60,
+			Finishers: []models.Finisher{},
+		}
+	default:
+		return ErrInvalidRoomType
+	}
+
+	return nil
+}
+
+// roomMutation changes a room in place and returns the ledger entries describing the change.
+// Storage backends that load a whole room run mutations inside their own lock or transaction,
+// and discard the changes if the mutation returns an error.
+type roomMutation func(room *models.DBRoom) ([]*models.LedgerEntry, error)
+
+// checkPassword makes sure a player may join a password protected room. Invite tokens are
+// checked, and used up, by applyRegister instead.
+// Comparing bcrypt hashes is slow on purpose, so backends call this before taking any lock.
+func checkPassword(room *models.DBRoom, password string, invite string) error {
+
+	if room.PasswordHash == "" || invite != "" {
+		return nil
+	}
+
+	if password == "" {
+		return ErrPasswordRequired
+	}
+
+	if bcrypt.CompareHashAndPassword([]byte(room.PasswordHash), []byte(password)) != nil {
+		return ErrWrongPassword
+	}
+
+	return nil
+}
+
+// applyRegister gives a new player the starting stack of the room and the first free seat, if there is one left.
+// A non-empty invite must be a valid token and is used up.
+func applyRegister(room *models.DBRoom, name string, invite string) ([]*models.LedgerEntry, error) {
+
+	if _, ok := room.Record[name]; ok {
+		return nil, ErrUserRegistered
+	}
+
+	if room.Closed {
+		return nil, ErrRoomClosed
+	}
+
+	if room.Locked {
+		return nil, ErrRoomLocked
+	}
+
+	for _, kicked := range room.Kicked {
+		if kicked == name {
+			return nil, ErrUserKicked
+		}
+	}
+
+	settings := room.Settings.WithDefaults()
+
+	if len(room.Record) >= settings.MaxPlayers {
+		return nil, ErrRoomFull
+	}
+
+	if room.Tournament != nil && room.Tournament.Finished {
+		return nil, ErrTournamentOver
+	}
+
+	if room.Tournament != nil && room.Tournament.Started && !settings.LateJoin {
+		return nil, ErrLateJoin
+	}
+
+	if invite != "" {
+		if err := useInvite(room, invite); err != nil {
+			return nil, err
+		}
+	}
+
+	chips := settings.StartingStack
+	room.Record[name] = chips
+
+	// The players of a room from before there were seats are seated all at once when it is loaded
+	if number := freeSeat(room); number > 0 && room.Seats != nil {
+		addSeat(room, models.Seat{Number: number, Player: name})
+	}
+
+	entry := &models.LedgerEntry{
+		RoomId:       room.Id,
+		Uri:          room.Uri,
+		Type:         models.LedgerRegister,
+		Actor:        name,
+		Amount:       chips,
+		BalanceAfter: chips,
+		PotBefore:    room.Pot,
+		PotAfter:     room.Pot,
+	}
+
+	return []*models.LedgerEntry{entry}, nil
+}
+
+func applyAddPot(room *models.DBRoom, name string, chips int) ([]*models.LedgerEntry, error) {
+
+	balance, ok := room.Record[name]
+	if !ok {
+		return nil, ErrUserNotRegistered
+	}
+	if balance < chips {
+		return nil, ErrNotEnoughChips
+	}
+
+	room.Record[name] -= chips
+	room.Pot += chips
+	room.Pots = []models.Pot{}
+
+	entry := &models.LedgerEntry{
+		RoomId:        room.Id,
+		Uri:           room.Uri,
+		Type:          models.LedgerBet,
+		Actor:         name,
+		Amount:        chips,
+		BalanceBefore: balance,
+		BalanceAfter:  room.Record[name],
+		PotBefore:     room.Pot - chips,
+		PotAfter:      room.Pot,
+	}
+
+	return []*models.LedgerEntry{entry}, nil
+}
+
+func applyTakePot(room *models.DBRoom, name string, chips int) ([]*models.LedgerEntry, error) {
+
+	balance, ok := room.Record[name]
+	if !ok {
+		return nil, ErrUserNotRegistered
+	}
+	if room.Pot < chips {
+		return nil, ErrNotEnoughPot
+	}
+
+	room.Record[name] += chips
+	room.Pot -= chips
+	room.Pots = []models.Pot{}
+
+	entry := &models.LedgerEntry{
+		RoomId:        room.Id,
+		Uri:           room.Uri,
+		Type:          models.LedgerTake,
+		Actor:         name,
+		Amount:        chips,
+		BalanceBefore: balance,
+		BalanceAfter:  room.Record[name],
+		PotBefore:     room.Pot + chips,
+		PotAfter:      room.Pot,
+	}
+
+	return []*models.LedgerEntry{entry}, nil
+}
+
+func applyUpdatePots(room *models.DBRoom, pots []models.Pot) ([]*models.LedgerEntry, error) {
+
+	if sumPots(pots) != room.Pot {
+		return nil, ErrPotsMismatch
+	}
+
+	room.Pots = copyPots(pots)
+
+	return nil, nil
+}
+
+// applyAwardPot pays the pot at index out to its winners. A split pot is shared equally and
+// the odd chips left over go one each to the winners in seat order, starting left of the dealer.
+// When the room has no pot breakdown, the whole pot counts as pot 0 and every registered player is eligible.
+func applyAwardPot(room *models.DBRoom, index int, winners []string) ([]*models.LedgerEntry, *models.AwardPotResponse, error) {
+
+	pots := room.Pots
+	if len(pots) == 0 && room.Pot > 0 {
+		pot := models.Pot{Amount: room.Pot}
+		for name := range room.Record {
+			pot.Eligible = append(pot.Eligible, name)
+		}
+		sort.Strings(pot.Eligible)
+		pots = []models.Pot{pot}
+	}
+
+	if index < 0 || index >= len(pots) {
+		return nil, nil, ErrPotNotFound
+	}
+	pot := pots[index]
+
+	if len(winners) == 0 {
+		return nil, nil, ErrNoWinners
+	}
+
+	// Order the winners by their position at the table, rejecting duplicates and ineligible players
+	position := tablePositions(room, pot.Eligible)
+
+	ordered := make([]string, 0, len(winners))
+	seen := make(map[string]bool)
+	for _, name := range winners {
+		if _, ok := position[name]; !ok || seen[name] {
+			return nil, nil, ErrNotEligible
+		}
+		seen[name] = true
+		ordered = append(ordered, name)
+	}
+	sort.Slice(ordered, func(i, j int) bool { return position[ordered[i]] < position[ordered[j]] })
+
+	share := pot.Amount / len(ordered)
+	odd := pot.Amount % len(ordered)
+
+	resp := &models.AwardPotResponse{
+		Amount:       pot.Amount,
+		Payouts:      make(map[string]int),
+		CurrentChips: make(map[string]int),
+	}
+
+	entries := make([]*models.LedgerEntry, 0, len(ordered))
+	for i, name := range ordered {
+		chips := share
+		if i < odd {
+			chips++
+		}
+
+		entry := &models.LedgerEntry{
+			RoomId:        room.Id,
+			Uri:           room.Uri,
+			Type:          models.LedgerAward,
+			Actor:         name,
+			Amount:        chips,
+			BalanceBefore: room.Record[name],
+			PotBefore:     room.Pot,
+		}
+
+		room.Record[name] += chips
+		room.Pot -= chips
+
+		entry.BalanceAfter = room.Record[name]
+		entry.PotAfter = room.Pot
+		entries = append(entries, entry)
+
+		resp.Payouts[name] = chips
+		resp.CurrentChips[name] = room.Record[name]
+	}
+
+	room.Pots = append(copyPots(pots[:index]), copyPots(pots[index+1:])...)
+
+	resp.Pot = room.Pot
+	resp.Pots = copyPots(room.Pots)
+
+	return entries, resp, nil
+}
+
+// tablePositions numbers the players eligible for a pot in seat order, starting with the first seat left of
+// the dealer. Players without a seat, in rooms from before there were seats, come last in eligibility order.
+func tablePositions(room *models.DBRoom, eligible []string) map[string]int {
+
+	start := 0
+	for i, seat := range room.Seats {
+		if seat.Player == room.Dealer {
+			start = i + 1
+		}
+	}
+
+	seated := make(map[string]int)
+	for i := range room.Seats {
+		seat := room.Seats[(start+i)%len(room.Seats)]
+		seated[seat.Player] = i
+	}
+
+	position := make(map[string]int, len(eligible))
+	for i, name := range eligible {
+		if seat, ok := seated[name]; ok {
+			position[name] = seat
+		} else {
+			position[name] = len(room.Seats) + i
+		}
+	}
+
+	return position
+}
+
+// checkHost makes sure a host command comes from the host of a room that is still open.
+func checkHost(room *models.DBRoom, host string) error {
+
+	if room.HostName() != host {
+		return ErrNotHost
+	}
+
+	if room.Closed {
+		return ErrRoomClosed
+	}
+
+	return nil
+}
+
+// applyCreateInvite adds a single-use invite token, dropping the ones that expired.
+func applyCreateInvite(room *models.DBRoom, host string, token string, expiresAt time.Time) ([]*models.LedgerEntry, error) {
+
+	if err := checkHost(room, host); err != nil {
+		return nil, err
+	}
+
+	room.Invites = append(liveInvites(room.Invites), models.Invite{
+		TokenHash: hashToken(token),
+		CreatedBy: host,
+		ExpiresAt: expiresAt,
+	})
+
+	return nil, nil
+}
+
+func useInvite(room *models.DBRoom, token string) error {
+
+	invites := liveInvites(room.Invites)
+	hash := hashToken(token)
+
+	for i, invite := range invites {
+		if subtle.ConstantTimeCompare([]byte(invite.TokenHash), []byte(hash)) == 1 {
+			room.Invites = append(invites[:i], invites[i+1:]...)
+			return nil
+		}
+	}
+
+	return ErrInvalidInvite
+}
+
+func liveInvites(invites []models.Invite) []models.Invite {
+
+	now := time.Now()
+	live := make([]models.Invite, 0, len(invites))
+	for _, invite := range invites {
+		if invite.ExpiresAt.After(now) {
+			live = append(live, invite)
+		}
+	}
+
+	return live
+}
+
+func hashToken(token string) string {
+
+	sum := sha256.Sum256([]byte(token))
+
+	return hex.EncodeToString(sum[:])
+}
+
+// applyKick removes a player from the room for good. Their chips are cashed out: the stack leaves
+// the table with them and the ledger entry records what they left with, and why when the host said.
+func applyKick(room *models.DBRoom, host string, name string, reason string) ([]*models.LedgerEntry, error) {
+
+	if err := checkHost(room, host); err != nil {
+		return nil, err
+	}
+
+	if name == host {
+		return nil, ErrKickHost
+	}
+
+	balance, ok := room.Record[name]
+	if !ok {
+		return nil, ErrUserNotRegistered
+	}
+
+	delete(room.Record, name)
+	room.Kicked = append(room.Kicked, name)
+	removeSeat(room, name)
+
+	entry := &models.LedgerEntry{
+		RoomId:        room.Id,
+		Uri:           room.Uri,
+		Type:          models.LedgerKick,
+		Actor:         name,
+		Amount:        balance,
+		BalanceBefore: balance,
+		PotBefore:     room.Pot,
+		PotAfter:      room.Pot,
+		Host:          host,
+		Reason:        reason,
+	}
+
+	return []*models.LedgerEntry{entry}, nil
+}
+
+func applyTransferHost(room *models.DBRoom, host string, name string) ([]*models.LedgerEntry, error) {
+
+	if err := checkHost(room, host); err != nil {
+		return nil, err
+	}
+
+	balance, ok := room.Record[name]
+	if !ok {
+		return nil, ErrUserNotRegistered
+	}
+
+	room.Host = name
+
+	entry := &models.LedgerEntry{
+		RoomId:        room.Id,
+		Uri:           room.Uri,
+		Type:          models.LedgerTransferHost,
+		Actor:         name,
+		BalanceBefore: balance,
+		BalanceAfter:  balance,
+		PotBefore:     room.Pot,
+		PotAfter:      room.Pot,
+		Host:          host,
+	}
+
+	return []*models.LedgerEntry{entry}, nil
+}
+
+// applyAdjustStack adds chips to or, with a negative amount, removes chips from a player's stack.
+func applyAdjustStack(room *models.DBRoom, host string, name string, amount int, reason string) ([]*models.LedgerEntry, error) {
+
+	if err := checkHost(room, host); err != nil {
+		return nil, err
+	}
+
+	if amount == 0 {
+		return nil, ErrInvalidAmount
+	}
+
+	if strings.TrimSpace(reason) == "" {
+		return nil, ErrReasonRequired
+	}
+
+	balance, ok := room.Record[name]
+	if !ok {
+		return nil, ErrUserNotRegistered
+	}
+	if balance+amount < 0 {
+		return nil, ErrNotEnoughChips
+	}
+
+	room.Record[name] += amount
+
+	entry := &models.LedgerEntry{
+		RoomId:        room.Id,
+		Uri:           room.Uri,
+		Type:          models.LedgerAdjust,
+		Actor:         name,
+		Amount:        amount,
+		BalanceBefore: balance,
+		BalanceAfter:  room.Record[name],
+		PotBefore:     room.Pot,
+		PotAfter:      room.Pot,
+		Host:          host,
+		Reason:        reason,
+	}
+
+	return []*models.LedgerEntry{entry}, nil
+}
+
+// applyTakeSeat sits a player down at a free seat, or moves them there when they sit elsewhere.
+// Taking a seat sits the player in, even when it is their own.
+func applyTakeSeat(room *models.DBRoom, name string, number int) ([]*models.LedgerEntry, error) {
+
+	if _, ok := room.Record[name]; !ok {
+		return nil, ErrUserNotRegistered
+	}
+
+	if room.Closed {
+		return nil, ErrRoomClosed
+	}
+
+	if !isValidSeat(room, number) {
+		return nil, ErrInvalidSeat
+	}
+
+	if seat := seatAt(room, number); seat != nil && seat.Player != name {
+		return nil, ErrSeatTaken
+	}
+
+	removeSeat(room, name)
+	addSeat(room, models.Seat{Number: number, Player: name})
+
+	return nil, nil
+}
+
+// applyStandUp frees the seat of a player, who stays in the room with their chips.
+func applyStandUp(room *models.DBRoom, name string) ([]*models.LedgerEntry, error) {
+
+	if room.SeatOf(name) == nil {
+		return nil, ErrNotSeated
+	}
+
+	removeSeat(room, name)
+
+	return nil, nil
+}
+
+// applySitOut keeps the seat of a player while they are not dealt in, or sits them back in.
+func applySitOut(room *models.DBRoom, name string, sittingOut bool) ([]*models.LedgerEntry, error) {
+
+	seat := room.SeatOf(name)
+	if seat == nil {
+		return nil, ErrNotSeated
+	}
+
+	if seat.SittingOut == sittingOut {
+		if sittingOut {
+			return nil, ErrSittingOut
+		}
+		return nil, ErrNotSittingOut
+	}
+
+	seat.SittingOut = sittingOut
+
+	return nil, nil
+}
+
+// applySetAway marks a seated player away while they have no connection to the room.
+func applySetAway(room *models.DBRoom, name string, away bool) ([]*models.LedgerEntry, error) {
+
+	seat := room.SeatOf(name)
+	if seat == nil {
+		return nil, ErrNotSeated
+	}
+
+	seat.Away = away
+
+	return nil, nil
+}
+
+// applySwapSeats moves the player of each seat to the other one. One of the seats can be empty,
+// which moves a single player.
+func applySwapSeats(room *models.DBRoom, host string, first int, second int) ([]*models.LedgerEntry, error) {
+
+	if err := checkHost(room, host); err != nil {
+		return nil, err
+	}
+
+	if !isValidSeat(room, first) || !isValidSeat(room, second) || first == second {
+		return nil, ErrInvalidSeat
+	}
+
+	a, b := seatAt(room, first), seatAt(room, second)
+	if a == nil && b == nil {
+		return nil, ErrNotSeated
+	}
+
+	if a != nil {
+		a.Number = second
+	}
+	if b != nil {
+		b.Number = first
+	}
+	sortSeats(room)
+
+	return []*models.LedgerEntry{hostEntry(room, host, models.LedgerSwapSeats)}, nil
+}
+
+func isValidSeat(room *models.DBRoom, number int) bool {
+	return number >= 1 && number <= room.Settings.WithDefaults().MaxPlayers
+}
+
+func seatAt(room *models.DBRoom, number int) *models.Seat {
+
+	for i := range room.Seats {
+		if room.Seats[i].Number == number {
+			return &room.Seats[i]
+		}
+	}
+
+	return nil
+}
+
+// freeSeat returns the lowest free seat number, or 0 when the table is full.
+func freeSeat(room *models.DBRoom) int {
+
+	for number := 1; isValidSeat(room, number); number++ {
+		if seatAt(room, number) == nil {
+			return number
+		}
+	}
+
+	return 0
+}
+
+func addSeat(room *models.DBRoom, seat models.Seat) {
+	room.Seats = append(room.Seats, seat)
+	sortSeats(room)
+}
+
+func removeSeat(room *models.DBRoom, name string) {
+
+	seats := make([]models.Seat, 0, len(room.Seats))
+	for _, seat := range room.Seats {
+		if seat.Player != name {
+			seats = append(seats, seat)
+		}
+	}
+
+	room.Seats = seats
+}
+
+func sortSeats(room *models.DBRoom) {
+	sort.Slice(room.Seats, func(i, j int) bool { return room.Seats[i].Number < room.Seats[j].Number })
+}
+
+func applyLock(room *models.DBRoom, host string, locked bool) ([]*models.LedgerEntry, error) {
+
+	if err := checkHost(room, host); err != nil {
+		return nil, err
+	}
+
+	room.Locked = locked
+
+	entryType := models.LedgerUnlock
+	if locked {
+		entryType = models.LedgerLock
+	}
+
+	return []*models.LedgerEntry{hostEntry(room, host, entryType)}, nil
+}
+
+// applyClose ends the game, the room and its history stay readable but nothing can change anymore.
+func applyClose(room *models.DBRoom, host string) ([]*models.LedgerEntry, error) {
+
+	if err := checkHost(room, host); err != nil {
+		return nil, err
+	}
+
+	room.Closed = true
+
+	return []*models.LedgerEntry{hostEntry(room, host, models.LedgerClose)}, nil
+}
+
+// hostEntry records a host action on the whole room.
+func hostEntry(room *models.DBRoom, host string, entryType string) *models.LedgerEntry {
+	return &models.LedgerEntry{
+		RoomId:        room.Id,
+		Uri:           room.Uri,
+		Type:          entryType,
+		Actor:         host,
+		BalanceBefore: room.Record[host],
+		BalanceAfter:  room.Record[host],
+		PotBefore:     room.Pot,
+		PotAfter:      room.Pot,
+		Host:          host,
+	}
+}
+
+func isValidSettings(settings models.RoomSettings) bool {
+	return settings.StartingStack > 0 &&
+		settings.MaxPlayers >= 2 && settings.MaxPlayers <= models.MaxPlayersLimit &&
+		settings.ChipValue >= 0 &&
+		(settings.ActionSeconds == 0 || settings.ActionSeconds >= models.MinActionSeconds && settings.ActionSeconds <= models.MaxActionSeconds) &&
+		settings.TimeBankSeconds >= 0 && settings.TimeBankSeconds <= models.MaxTimeBankSeconds &&
+		isValidBlinds(settings.Blinds) && settings.Blinds.BigBlind <= settings.StartingStack
+}
+
+func isValidBlinds(blinds models.Blinds) bool {
+	return blinds.SmallBlind >= 0 && blinds.BigBlind >= 0 && blinds.Ante >= 0 && blinds.SmallBlind <= blinds.BigBlind
+}
+
+// registerEntries returns one register entry per player already seated when the room was created.
+func registerEntries(room *models.DBRoom) []*models.LedgerEntry {
+
+	entries := make([]*models.LedgerEntry, 0, len(room.Record))
+	for name, chips := range room.Record {
+		entries = append(entries, &models.LedgerEntry{
+			RoomId:       room.Id,
+			Uri:          room.Uri,
+			Type:         models.LedgerRegister,
+			Actor:        name,
+			Amount:       chips,
+			BalanceAfter: chips,
+			PotBefore:    room.Pot,
+			PotAfter:     room.Pot,
+		})
+	}
+
+	return entries
+}
+
+func potResponse(room *models.DBRoom, name string) *models.UpdatePotResponse {
+	return &models.UpdatePotResponse{
+		Pot:          room.Pot,
+		CurrentChips: room.Record[name],
+		Sender:       name,
+	}
+}
+
+func sumPots(pots []models.Pot) int {
+
+	total := 0
+	for _, pot := range pots {
+		total += pot.Amount
+	}
+
+	return total
+}
+
+// copyRoom returns a deep copy so callers cannot mutate the stored room.
+func copyRoom(room *models.DBRoom) *models.DBRoom {
+
+	c := *room
+	c.Record = make(map[string]int, len(room.Record))
+	for name, chips := range room.Record {
+		c.Record[name] = chips
+	}
+	c.Pots = copyPots(room.Pots)
+	c.Tournament = copyTournament(room.Tournament)
+	c.Kicked = append([]string{}, room.Kicked...)
+	c.Invites = append([]models.Invite{}, room.Invites...)
+
+	// Rooms from before there were seats have none, not an empty table
+	if room.Seats != nil {
+		c.Seats = append([]models.Seat{}, room.Seats...)
+	}
+
+	return &c
+}
+
+func copyTournament(tournament *models.Tournament) *models.Tournament {
+
+	if tournament == nil {
+		return nil
+	}
+
+	c := *tournament
+	c.Levels = append([]models.BlindLevel{}, tournament.Levels...)
+	c.Finishers = append([]models.Finisher{}, tournament.Finishers...)
+
+	return &c
+}
+
+func copyPots(pots []models.Pot) []models.Pot {
+
+	c := make([]models.Pot, len(pots))
+	for i, pot := range pots {
+		c[i] = models.Pot{Amount: pot.Amount, Eligible: append([]string{}, pot.Eligible...)}
+	}
+
+	return c
+}
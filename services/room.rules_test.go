@@ -0,0 +1,261 @@
+package services
+
+import (
+	"go-pokerchips/models"
+	"reflect"
+	"testing"
+)
+
+func TestApplyAwardPot(t *testing.T) {
+
+	seats := []models.Seat{{Number: 1, Player: "ann"}, {Number: 2, Player: "bob"}, {Number: 4, Player: "cat"}}
+	everybody := []string{"ann", "bob", "cat"}
+
+	tests := []struct {
+		name    string
+		pot     int
+		pots    []models.Pot
+		seats   []models.Seat
+		dealer  string
+		index   int
+		winners []string
+		payouts map[string]int
+		left    []models.Pot
+		err     error
+	}{
+		{
+			name:    "single winner",
+			pot:     100,
+			pots:    []models.Pot{{Amount: 100, Eligible: everybody}},
+			seats:   seats,
+			winners: []string{"bob"},
+			payouts: map[string]int{"bob": 100},
+			left:    []models.Pot{},
+		},
+		{
+			name:    "even split",
+			pot:     100,
+			pots:    []models.Pot{{Amount: 100, Eligible: everybody}},
+			seats:   seats,
+			dealer:  "ann",
+			winners: []string{"cat", "ann"},
+			payouts: map[string]int{"ann": 50, "cat": 50},
+			left:    []models.Pot{},
+		},
+		{
+			name:    "odd chip left of the dealer",
+			pot:     101,
+			pots:    []models.Pot{{Amount: 101, Eligible: everybody}},
+			seats:   seats,
+			dealer:  "bob",
+			winners: []string{"ann", "bob"},
+			payouts: map[string]int{"ann": 51, "bob": 50},
+			left:    []models.Pot{},
+		},
+		{
+			name:    "odd chips go round from the dealer",
+			pot:     101,
+			pots:    []models.Pot{{Amount: 101, Eligible: everybody}},
+			seats:   seats,
+			dealer:  "ann",
+			winners: everybody,
+			payouts: map[string]int{"ann": 33, "bob": 34, "cat": 34},
+			left:    []models.Pot{},
+		},
+		{
+			name:    "dealer last to get an odd chip",
+			pot:     100,
+			pots:    []models.Pot{{Amount: 100, Eligible: everybody}},
+			seats:   seats,
+			dealer:  "cat",
+			winners: everybody,
+			payouts: map[string]int{"ann": 34, "bob": 33, "cat": 33},
+			left:    []models.Pot{},
+		},
+		{
+			name:    "without seats in eligibility order",
+			pot:     101,
+			pots:    []models.Pot{{Amount: 101, Eligible: []string{"cat", "ann"}}},
+			dealer:  "cat",
+			winners: []string{"ann", "cat"},
+			payouts: map[string]int{"ann": 50, "cat": 51},
+			left:    []models.Pot{},
+		},
+		{
+			name:    "side pot",
+			pot:     250,
+			pots:    []models.Pot{{Amount: 150, Eligible: everybody}, {Amount: 100, Eligible: []string{"ann", "bob"}}},
+			seats:   seats,
+			index:   1,
+			winners: []string{"bob"},
+			payouts: map[string]int{"bob": 100},
+			left:    []models.Pot{{Amount: 150, Eligible: everybody}},
+		},
+		{
+			name:    "split side pot",
+			pot:     251,
+			pots:    []models.Pot{{Amount: 150, Eligible: everybody}, {Amount: 101, Eligible: []string{"ann", "bob"}}},
+			seats:   seats,
+			dealer:  "cat",
+			index:   1,
+			winners: []string{"bob", "ann"},
+			payouts: map[string]int{"ann": 51, "bob": 50},
+			left:    []models.Pot{{Amount: 150, Eligible: everybody}},
+		},
+		{
+			name:    "whole pot without a breakdown",
+			pot:     90,
+			seats:   seats,
+			winners: []string{"cat"},
+			payouts: map[string]int{"cat": 90},
+			left:    []models.Pot{},
+		},
+		{
+			name:    "not eligible for the side pot",
+			pot:     250,
+			pots:    []models.Pot{{Amount: 150, Eligible: everybody}, {Amount: 100, Eligible: []string{"ann", "bob"}}},
+			index:   1,
+			winners: []string{"cat"},
+			err:     ErrNotEligible,
+		},
+		{
+			name:    "same winner twice",
+			pot:     100,
+			winners: []string{"ann", "ann"},
+			err:     ErrNotEligible,
+		},
+		{
+			name: "no winners",
+			pot:  100,
+			err:  ErrNoWinners,
+		},
+		{
+			name:    "no such pot",
+			pot:     100,
+			index:   1,
+			winners: []string{"ann"},
+			err:     ErrPotNotFound,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			room := &models.DBRoom{
+				Pot:    test.pot,
+				Pots:   test.pots,
+				Seats:  test.seats,
+				Dealer: test.dealer,
+				Record: map[string]int{"ann": 1000, "bob": 1000, "cat": 1000},
+			}
+
+			entries, resp, err := applyAwardPot(room, test.index, test.winners)
+			if err != test.err {
+				t.Fatalf("got error %v, want %v", err, test.err)
+			}
+			if err != nil {
+				return
+			}
+
+			if !reflect.DeepEqual(resp.Payouts, test.payouts) {
+				t.Errorf("got payouts %v, want %v", resp.Payouts, test.payouts)
+			}
+			if !reflect.DeepEqual(room.Pots, test.left) {
+				t.Errorf("pots left are %v, want %v", room.Pots, test.left)
+			}
+
+			paid := 0
+			for name, chips := range test.payouts {
+				paid += chips
+				if room.Record[name] != 1000+chips {
+					t.Errorf("%v has %v chips, want %v", name, room.Record[name], 1000+chips)
+				}
+			}
+			if room.Pot != test.pot-paid || resp.Pot != room.Pot {
+				t.Errorf("pot is %v, want %v", room.Pot, test.pot-paid)
+			}
+			if len(entries) != len(test.payouts) {
+				t.Errorf("got %v ledger entries, want one per winner", len(entries))
+			}
+		})
+	}
+}
+
+func TestIsValidSettings(t *testing.T) {
+
+	valid := models.RoomSettings{}.WithDefaults()
+
+	tests := []struct {
+		name   string
+		change func(settings *models.RoomSettings)
+		want   bool
+	}{
+		{"defaults", func(settings *models.RoomSettings) {}, true},
+		{"no starting stack", func(settings *models.RoomSettings) { settings.StartingStack = 0 }, false},
+		{"negative starting stack", func(settings *models.RoomSettings) { settings.StartingStack = -100 }, false},
+		{"heads-up", func(settings *models.RoomSettings) { settings.MaxPlayers = 2 }, true},
+		{"one player", func(settings *models.RoomSettings) { settings.MaxPlayers = 1 }, false},
+		{"full table", func(settings *models.RoomSettings) { settings.MaxPlayers = models.MaxPlayersLimit }, true},
+		{"too many players", func(settings *models.RoomSettings) { settings.MaxPlayers = models.MaxPlayersLimit + 1 }, false},
+		{"chip value", func(settings *models.RoomSettings) { settings.ChipValue = 0.25 }, true},
+		{"negative chip value", func(settings *models.RoomSettings) { settings.ChipValue = -0.25 }, false},
+		{"late join", func(settings *models.RoomSettings) { settings.LateJoin = true }, true},
+		{"blinds", func(settings *models.RoomSettings) {
+			settings.Blinds = models.Blinds{SmallBlind: 5, BigBlind: 10, Ante: 1}
+		}, true},
+		{"small blind above the big blind", func(settings *models.RoomSettings) { settings.Blinds = models.Blinds{SmallBlind: 10, BigBlind: 5} }, false},
+		{"negative ante", func(settings *models.RoomSettings) { settings.Blinds = models.Blinds{Ante: -1} }, false},
+		{"big blind above the starting stack", func(settings *models.RoomSettings) {
+			settings.Blinds = models.Blinds{BigBlind: settings.StartingStack + 1}
+		}, false},
+		{"action clock", func(settings *models.RoomSettings) { settings.ActionSeconds = models.MinActionSeconds }, true},
+		{"action clock too short", func(settings *models.RoomSettings) { settings.ActionSeconds = models.MinActionSeconds - 1 }, false},
+		{"action clock too long", func(settings *models.RoomSettings) { settings.ActionSeconds = models.MaxActionSeconds + 1 }, false},
+		{"time bank", func(settings *models.RoomSettings) { settings.TimeBankSeconds = models.MaxTimeBankSeconds }, true},
+		{"negative time bank", func(settings *models.RoomSettings) { settings.TimeBankSeconds = -1 }, false},
+		{"time bank too long", func(settings *models.RoomSettings) { settings.TimeBankSeconds = models.MaxTimeBankSeconds + 1 }, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			settings := valid
+			test.change(&settings)
+
+			if got := isValidSettings(settings); got != test.want {
+				t.Errorf("isValidSettings(%+v) = %v, want %v", settings, got, test.want)
+			}
+		})
+	}
+}
+
+func TestLateJoin(t *testing.T) {
+
+	tests := []struct {
+		name       string
+		tournament *models.Tournament
+		lateJoin   bool
+		err        error
+	}{
+		{"cash game", nil, false, nil},
+		{"tournament not started", &models.Tournament{}, false, nil},
+		{"tournament started", &models.Tournament{Started: true}, false, ErrLateJoin},
+		{"late join allowed", &models.Tournament{Started: true}, true, nil},
+		{"tournament over", &models.Tournament{Started: true, Finished: true}, true, ErrTournamentOver},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			room := &models.DBRoom{
+				Record:     map[string]int{"ann": 1000},
+				Tournament: test.tournament,
+				Settings:   models.RoomSettings{LateJoin: test.lateJoin},
+			}
+
+			if _, err := applyRegister(room, "bob", ""); err != test.err {
+				t.Errorf("got error %v, want %v", err, test.err)
+			}
+		})
+	}
+}
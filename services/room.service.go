@@ -9,38 +9,122 @@ import (
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"log"
+	"strings"
 	"time"
 )
 
+// Errors shared by every RoomService implementation.
+var (
+	ErrRoomExists        = errors.New("room already exists")
+	ErrRoomNotFound      = errors.New("room not found")
+	ErrInvalidUserName   = errors.New("invalid username")
+	ErrUserRegistered    = errors.New("username has been registered in room")
+	ErrUserNotRegistered = errors.New("username is not registered in room")
+	ErrInvalidAmount     = errors.New("invalid chip amount")
+	ErrNotEnoughChips    = errors.New("not enough chips to pay the bet")
+	ErrNotEnoughPot      = errors.New("pot is not enough")
+	ErrPotsMismatch      = errors.New("pots do not add up to the pot")
+	ErrPotNotFound       = errors.New("pot not found")
+	ErrNoWinners         = errors.New("at least one winner is needed")
+	ErrNotEligible       = errors.New("player is not eligible for the pot")
+	ErrConcurrentUpdate  = errors.New("room was updated by someone else, try again")
+	ErrInvalidBlinds     = errors.New("blinds and ante must not be negative and the small blind must not exceed the big blind")
+	ErrInvalidRoomType   = errors.New("room type must be cash or tournament")
+	ErrInvalidLevels     = errors.New("a tournament needs at least one blind level with a duration and a big blind")
+	ErrInvalidSettings   = errors.New("invalid room settings")
+	ErrRoomFull          = errors.New("room is full")
+	ErrLateJoin          = errors.New("the tournament has started and does not allow late joining")
+	ErrTournamentOver    = errors.New("the tournament is over")
+	ErrNotHost           = errors.New("only the host of the room can do that")
+	ErrKickHost          = errors.New("the host cannot be kicked, transfer the host role first")
+	ErrUserKicked        = errors.New("user was kicked from the room")
+	ErrReasonRequired    = errors.New("a reason is required")
+	ErrRoomLocked        = errors.New("room is locked")
+	ErrRoomClosed        = errors.New("room is closed")
+	ErrInvalidPassword   = errors.New("password must not be longer than 72 bytes")
+	ErrPasswordRequired  = errors.New("room is password protected")
+	ErrWrongPassword     = errors.New("wrong password")
+	ErrInvalidInvite     = errors.New("invite is invalid or has expired")
+	ErrInvalidSeat       = errors.New("no such seat at the table")
+	ErrSeatTaken         = errors.New("seat is taken")
+	ErrNotSeated         = errors.New("player is not seated")
+	ErrSittingOut        = errors.New("player is already sitting out")
+	ErrNotSittingOut     = errors.New("player is not sitting out")
+)
+
+// maxUpdateRetries bounds how often a versioned update is retried after losing a race.
+const maxUpdateRetries = 5
+
 type RoomService interface {
 	CreateRoom(*models.CreateRoomInput) (*models.DBRoom, error)
 	FindRoomByUri(string) (*models.DBRoom, error)
-	RegisterUserInRoom(string, string) error
+	RegisterUserInRoom(string, string, string, string) error
 	AddPot(string, string, int) (*models.UpdatePotResponse, error)
 	TakePot(string, string, int) (*models.UpdatePotResponse, error)
+	UpdatePots(string, []models.Pot) error
+	AwardPot(string, int, []string) (*models.AwardPotResponse, error)
+	UpdateBlinds(string, models.Blinds) error
+	UpdateDealer(string, string) error
+	UpdateTournament(string, *models.Tournament) error
+	KickUser(string, string, string, string) error
+	TransferHost(string, string, string) error
+	AdjustStack(string, string, string, int, string) (int, error)
+	LockRoom(string, string, bool) error
+	CloseRoom(string, string) error
+	CreateInvite(string, string, time.Duration) (string, time.Time, error)
+	TakeSeat(string, string, int) ([]models.Seat, error)
+	StandUp(string, string) ([]models.Seat, error)
+	SitOut(string, string, bool) ([]models.Seat, error)
+	SetAway(string, string, bool) ([]models.Seat, error)
+	SwapSeats(string, string, int, int) ([]models.Seat, error)
+	FindLedgerByUri(string, int64, int64) ([]*models.LedgerEntry, error)
 }
 
+// RoomServiceImpl stores rooms in Mongo. Ledger entries are staged in the outbox of the room document
+// by the same update that moves the chips, then copied to the ledger collection, so a movement is
+// never applied without its entry or recorded without being applied.
 type RoomServiceImpl struct {
 	collection *mongo.Collection
+	ledger     *mongo.Collection
+}
+
+// roomOutbox is the part of a room document holding the ledger entries not yet in the ledger collection.
+type roomOutbox struct {
+	Outbox []*models.LedgerEntry `bson:"outbox"`
 }
 
-func NewRoomService(collection *mongo.Collection) RoomService {
-	return &RoomServiceImpl{collection}
+func NewRoomService(collection *mongo.Collection, ledger *mongo.Collection) RoomService {
+	return &RoomServiceImpl{collection, ledger}
 }
 
 func (rs *RoomServiceImpl) CreateRoom(room *models.CreateRoomInput) (*models.DBRoom, error) {
 
 	ctx := context.Background()
 
+	if err := prepareRoom(room); err != nil {
+		return nil, err
+	}
+
 	room.Uri = uniuri.NewLen(5)
 	room.CreatedAt = time.Now()
 	room.UpdatedAt = room.CreatedAt
 
-	res, err := rs.collection.InsertOne(ctx, room)
+	// The register entries are inserted with the room
+	newId := primitive.NewObjectID()
+	entries := stageLedger(registerEntries(&models.DBRoom{Id: newId, Uri: room.Uri, Record: room.Record}))
+
+	document := struct {
+		Id                     primitive.ObjectID `bson:"_id"`
+		models.CreateRoomInput `bson:",inline"`
+		Outbox                 []*models.LedgerEntry `bson:"outbox"`
+	}{newId, *room, entries}
+
+	res, err := rs.collection.InsertOne(ctx, document)
 
 	if err != nil {
 		if er, ok := err.(mongo.WriteException); ok && er.WriteErrors[0].Code == 11000 {
-			return nil, errors.New("room already exists")
+			return nil, ErrRoomExists
 		}
 		return nil, err
 	}
@@ -51,6 +135,12 @@ func (rs *RoomServiceImpl) CreateRoom(room *models.CreateRoomInput) (*models.DBR
 		return nil, errors.New("could not create index for title")
 	}
 
+	ledgerIndex := mongo.IndexModel{Keys: bson.D{{Key: "roomId", Value: 1}, {Key: "createdAt", Value: 1}}}
+
+	if _, err := rs.ledger.Indexes().CreateOne(ctx, ledgerIndex); err != nil {
+		return nil, errors.New("could not create index for ledger")
+	}
+
 	var newRoom *models.DBRoom
 	query := bson.M{"_id": res.InsertedID}
 
@@ -59,6 +149,8 @@ func (rs *RoomServiceImpl) CreateRoom(room *models.CreateRoomInput) (*models.DBR
 		return nil, err
 	}
 
+	rs.flushLedger(ctx, newRoom.Id)
+
 	return newRoom, nil
 }
 
@@ -76,6 +168,10 @@ func (rs *RoomServiceImpl) FindRoomById(id string) (*models.DBRoom, error) {
 	query := bson.M{"_id": objId}
 	err = rs.collection.FindOne(ctx, query).Decode(&room)
 
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrRoomNotFound
+	}
+
 	if err != nil {
 		return nil, err
 	}
@@ -92,117 +188,537 @@ func (rs *RoomServiceImpl) FindRoomByUri(uri string) (*models.DBRoom, error) {
 	err := rs.collection.FindOne(ctx, query).Decode(&room)
 
 	if err != nil {
-		return nil, errors.New("room not found")
+		return nil, ErrRoomNotFound
 	}
 
 	return room, nil
 }
 
-func (rs *RoomServiceImpl) RegisterUserInRoom(id string, name string) error {
+func (rs *RoomServiceImpl) RegisterUserInRoom(id string, name string, password string, invite string) error {
 
-	ctx := context.Background()
+	if !isValidUserName(name) {
+		return ErrInvalidUserName
+	}
 
 	room, err := rs.FindRoomById(id)
 	if err != nil {
 		return err
 	}
 
-	if _, ok := room.Record[name]; !ok {
-		room.Record[name] = 1000
-	} else {
-		return errors.New("username has been registered in room")
+	if err = checkPassword(room, password, invite); err != nil {
+		return err
 	}
 
-	obId, _ := primitive.ObjectIDFromHex(id)
-	query := bson.D{{"_id", obId}}
-	update := bson.D{{"$set", bson.D{
-		{"record", room.Record},
-	}}}
+	// The versioned update makes sure two concurrent joins cannot both take the last seat
+	// or register the same name twice.
+	_, err = rs.updateVersioned(id, func(room *models.DBRoom) ([]*models.LedgerEntry, error) {
+		return applyRegister(room, name, invite)
+	})
 
-	_, err = rs.collection.UpdateOne(ctx, query, update)
+	return err
+}
+
+func (rs *RoomServiceImpl) AddPot(id string, name string, chips int) (*models.UpdatePotResponse, error) {
 
+	ctx := context.Background()
+
+	if chips <= 0 {
+		return nil, ErrInvalidAmount
+	}
+
+	if !isValidUserName(name) {
+		return nil, ErrInvalidUserName
+	}
+
+	objId, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	return nil
+	// The balance guard, both moves and the ledger entry are applied in a single update,
+	// so concurrent bets can never overdraw a stack or lose chips.
+	query := bson.M{"_id": objId, "record." + name: bson.M{"$gte": chips}}
+	update := potUpdate(name, -chips, models.LedgerBet)
+
+	var room *models.DBRoom
+	err = rs.collection.FindOneAndUpdate(ctx, query, update, options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&room)
+
+	if err == mongo.ErrNoDocuments {
+		room, err = rs.FindRoomById(id)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := room.Record[name]; !ok {
+			return nil, ErrUserNotRegistered
+		}
+		return nil, ErrNotEnoughChips
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	rs.flushLedger(ctx, room.Id)
+
+	return potResponse(room, name), nil
 }
 
-func (rs *RoomServiceImpl) AddPot(id string, name string, chips int) (*models.UpdatePotResponse, error) {
+func (rs *RoomServiceImpl) TakePot(id string, name string, chips int) (*models.UpdatePotResponse, error) {
 
 	ctx := context.Background()
 
-	room, err := rs.FindRoomById(id)
+	if chips <= 0 {
+		return nil, ErrInvalidAmount
+	}
+
+	if !isValidUserName(name) {
+		return nil, ErrInvalidUserName
+	}
+
+	objId, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
 		return nil, err
 	}
 
-	if _, ok := room.Record[name]; ok {
-		if room.Record[name] < chips {
-			return nil, errors.New("not enough chips to pay the bet")
+	query := bson.M{"_id": objId, "pot": bson.M{"$gte": chips}, "record." + name: bson.M{"$exists": true}}
+	update := potUpdate(name, chips, models.LedgerTake)
+
+	var room *models.DBRoom
+	err = rs.collection.FindOneAndUpdate(ctx, query, update, options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&room)
+
+	if err == mongo.ErrNoDocuments {
+		room, err = rs.FindRoomById(id)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := room.Record[name]; !ok {
+			return nil, ErrUserNotRegistered
 		}
-		room.Record[name] -= chips
+		return nil, ErrNotEnoughPot
 	}
-	room.Pot += chips
 
-	obId, _ := primitive.ObjectIDFromHex(id)
-	query := bson.D{{"_id", obId}}
-	update := bson.D{{"$set", bson.D{
-		{"pot", room.Pot},
-		{"record", room.Record},
-	}}}
+	if err != nil {
+		return nil, err
+	}
 
-	_, err = rs.collection.UpdateOne(ctx, query, update)
+	rs.flushLedger(ctx, room.Id)
 
+	return potResponse(room, name), nil
+}
+
+// UpdatePots stores how the pot is split into a main pot and side pots.
+// The update only applies if the pots add up to the chips currently in the pot.
+func (rs *RoomServiceImpl) UpdatePots(id string, pots []models.Pot) error {
+
+	ctx := context.Background()
+
+	objId, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+
+	query := bson.M{"_id": objId, "pot": sumPots(pots)}
+	update := bson.M{
+		"$set": bson.M{"pots": pots, "updatedAt": time.Now()},
+		"$inc": bson.M{"version": 1},
+	}
+
+	res, err := rs.collection.UpdateOne(ctx, query, update)
+	if err != nil {
+		return err
+	}
+
+	if res.MatchedCount == 0 {
+		if _, err = rs.FindRoomById(id); err != nil {
+			return err
+		}
+		return ErrPotsMismatch
+	}
+
+	return nil
+}
+
+// AwardPot pays a pot out to its winners. Winners must be eligible for the pot.
+func (rs *RoomServiceImpl) AwardPot(id string, index int, winners []string) (*models.AwardPotResponse, error) {
+
+	var resp *models.AwardPotResponse
+
+	_, err := rs.updateVersioned(id, func(room *models.DBRoom) ([]*models.LedgerEntry, error) {
+		entries, r, err := applyAwardPot(room, index, winners)
+		resp = r
+		return entries, err
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	updatePotResp := &models.UpdatePotResponse{
-		Pot:          room.Pot,
-		CurrentChips: room.Record[name],
-		Sender:       name,
+	return resp, nil
+}
+
+func (rs *RoomServiceImpl) UpdateBlinds(id string, blinds models.Blinds) error {
+
+	if !isValidBlinds(blinds) {
+		return ErrInvalidBlinds
 	}
 
-	return updatePotResp, err
+	return rs.set(id, bson.M{"blinds": blinds})
 }
 
-func (rs *RoomServiceImpl) TakePot(id string, name string, chips int) (*models.UpdatePotResponse, error) {
+func (rs *RoomServiceImpl) UpdateDealer(id string, dealer string) error {
+	return rs.set(id, bson.M{"dealer": dealer})
+}
 
-	ctx := context.Background()
+func (rs *RoomServiceImpl) UpdateTournament(id string, tournament *models.Tournament) error {
+	return rs.set(id, bson.M{"tournament": tournament})
+}
 
-	room, err := rs.FindRoomById(id)
+func (rs *RoomServiceImpl) KickUser(id string, host string, name string, reason string) error {
+
+	_, err := rs.updateVersioned(id, func(room *models.DBRoom) ([]*models.LedgerEntry, error) {
+		return applyKick(room, host, name, reason)
+	})
+
+	return err
+}
+
+func (rs *RoomServiceImpl) TransferHost(id string, host string, name string) error {
+
+	_, err := rs.updateVersioned(id, func(room *models.DBRoom) ([]*models.LedgerEntry, error) {
+		return applyTransferHost(room, host, name)
+	})
+
+	return err
+}
+
+// AdjustStack corrects the stack of a player and returns their new balance.
+func (rs *RoomServiceImpl) AdjustStack(id string, host string, name string, amount int, reason string) (int, error) {
+
+	room, err := rs.updateVersioned(id, func(room *models.DBRoom) ([]*models.LedgerEntry, error) {
+		return applyAdjustStack(room, host, name, amount, reason)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return room.Record[name], nil
+}
+
+func (rs *RoomServiceImpl) LockRoom(id string, host string, locked bool) error {
+
+	_, err := rs.updateVersioned(id, func(room *models.DBRoom) ([]*models.LedgerEntry, error) {
+		return applyLock(room, host, locked)
+	})
+
+	return err
+}
+
+func (rs *RoomServiceImpl) CloseRoom(id string, host string) error {
+
+	_, err := rs.updateVersioned(id, func(room *models.DBRoom) ([]*models.LedgerEntry, error) {
+		return applyClose(room, host)
+	})
+
+	return err
+}
+
+// CreateInvite returns a new invite token for the room that can be used once before it expires.
+func (rs *RoomServiceImpl) CreateInvite(id string, host string, ttl time.Duration) (string, time.Time, error) {
+
+	if ttl <= 0 {
+		return "", time.Time{}, ErrInvalidAmount
+	}
+
+	token := uniuri.NewLen(24)
+	expiresAt := time.Now().Add(ttl)
+
+	_, err := rs.updateVersioned(id, func(room *models.DBRoom) ([]*models.LedgerEntry, error) {
+		return applyCreateInvite(room, host, token, expiresAt)
+	})
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	return token, expiresAt, nil
+}
+
+// TakeSeat sits a player down at a free seat and returns the seats of the room.
+func (rs *RoomServiceImpl) TakeSeat(id string, name string, number int) ([]models.Seat, error) {
+
+	room, err := rs.updateVersioned(id, func(room *models.DBRoom) ([]*models.LedgerEntry, error) {
+		return applyTakeSeat(room, name, number)
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	room.Pot -= chips
-	if room.Pot < 0 {
-		return nil, errors.New("pot is not enough ")
+	return room.Seats, nil
+}
+
+func (rs *RoomServiceImpl) StandUp(id string, name string) ([]models.Seat, error) {
+
+	room, err := rs.updateVersioned(id, func(room *models.DBRoom) ([]*models.LedgerEntry, error) {
+		return applyStandUp(room, name)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return room.Seats, nil
+}
+
+func (rs *RoomServiceImpl) SitOut(id string, name string, sittingOut bool) ([]models.Seat, error) {
+
+	room, err := rs.updateVersioned(id, func(room *models.DBRoom) ([]*models.LedgerEntry, error) {
+		return applySitOut(room, name, sittingOut)
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	if _, ok := room.Record[name]; ok {
-		room.Record[name] += chips
+	return room.Seats, nil
+}
+
+func (rs *RoomServiceImpl) SetAway(id string, name string, away bool) ([]models.Seat, error) {
+
+	room, err := rs.updateVersioned(id, func(room *models.DBRoom) ([]*models.LedgerEntry, error) {
+		return applySetAway(room, name, away)
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	obId, _ := primitive.ObjectIDFromHex(id)
-	query := bson.D{{"_id", obId}}
-	update := bson.D{{"$set", bson.D{
-		{"pot", room.Pot},
-		{"record", room.Record},
-	}}}
+	return room.Seats, nil
+}
 
-	_, err = rs.collection.UpdateOne(ctx, query, update)
+func (rs *RoomServiceImpl) SwapSeats(id string, host string, first int, second int) ([]models.Seat, error) {
 
+	room, err := rs.updateVersioned(id, func(room *models.DBRoom) ([]*models.LedgerEntry, error) {
+		return applySwapSeats(room, host, first, second)
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	updatePotResp := &models.UpdatePotResponse{
-		Pot:          room.Pot,
-		CurrentChips: room.Record[name],
-		Sender:       name,
+	return room.Seats, nil
+}
+
+// FindLedgerByUri returns one page of the room's ledger, oldest entries first.
+func (rs *RoomServiceImpl) FindLedgerByUri(uri string, page int64, limit int64) ([]*models.LedgerEntry, error) {
+
+	ctx := context.Background()
+
+	room, err := rs.FindRoomByUri(uri)
+	if err != nil {
+		return nil, err
+	}
+
+	// Entries still in the outbox belong to the history being read
+	if err = rs.drainLedger(ctx, room.Id); err != nil {
+		return nil, err
+	}
+
+	if page < 1 {
+		page = 1
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "createdAt", Value: 1}, {Key: "_id", Value: 1}}).
+		SetSkip((page - 1) * limit).
+		SetLimit(limit)
+
+	cursor, err := rs.ledger.Find(ctx, bson.M{"roomId": room.Id}, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]*models.LedgerEntry, 0)
+	if err = cursor.All(ctx, &entries); err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+// set overwrites room fields that no other update depends on.
+func (rs *RoomServiceImpl) set(id string, fields bson.M) error {
+
+	ctx := context.Background()
+
+	objId, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+
+	fields["updatedAt"] = time.Now()
+	update := bson.M{"$set": fields, "$inc": bson.M{"version": 1}}
+
+	res, err := rs.collection.UpdateOne(ctx, bson.M{"_id": objId}, update)
+	if err != nil {
+		return err
 	}
-	
-	return updatePotResp, err
+
+	if res.MatchedCount == 0 {
+		return ErrRoomNotFound
+	}
+
+	return nil
+}
+
+// updateVersioned reads the room, applies the mutation and writes the result back only if
+// the room version did not change in between, retrying with a fresh copy when it did.
+// Use it for changes that cannot be expressed as a single conditional update.
+func (rs *RoomServiceImpl) updateVersioned(id string, mutate roomMutation) (*models.DBRoom, error) {
+
+	ctx := context.Background()
+
+	for attempt := 0; attempt < maxUpdateRetries; attempt++ {
+
+		room, err := rs.FindRoomById(id)
+		if err != nil {
+			return nil, err
+		}
+
+		// Rooms that were never updated have no version field yet
+		var version interface{} = room.Version
+		if room.Version == 0 {
+			version = bson.M{"$in": bson.A{0, nil}}
+		}
+
+		entries, err := mutate(room)
+		if err != nil {
+			return nil, err
+		}
+
+		room.Version++
+		room.UpdatedAt = time.Now()
+
+		query := bson.M{"_id": room.Id, "version": version}
+		update := bson.M{"$set": bson.M{
+			"pot":       room.Pot,
+			"pots":      room.Pots,
+			"record":    room.Record,
+			"host":      room.Host,
+			"locked":    room.Locked,
+			"closed":    room.Closed,
+			"kicked":    room.Kicked,
+			"invites":   room.Invites,
+			"seats":     room.Seats,
+			"version":   room.Version,
+			"updatedAt": room.UpdatedAt,
+		}}
+		if len(entries) > 0 {
+			update["$push"] = bson.M{"outbox": bson.M{"$each": stageLedger(entries)}}
+		}
+
+		res, err := rs.collection.UpdateOne(ctx, query, update)
+		if err != nil {
+			return nil, err
+		}
+
+		if res.MatchedCount == 0 {
+			continue
+		}
+
+		if len(entries) > 0 {
+			rs.flushLedger(ctx, room.Id)
+		}
+
+		return room, nil
+	}
+
+	return nil, ErrConcurrentUpdate
+}
+
+// isValidUserName reports whether name can be used as a key in the room record.
+// Mongo treats dots and leading dollar signs in field paths specially.
+func isValidUserName(name string) bool {
+	return name != "" && !strings.Contains(name, ".") && !strings.HasPrefix(name, "$")
+}
+
+// potUpdate moves chips between the stack of a player and the pot, stack being the change to the stack.
+// The ledger entry is computed from the room as it was before the move and staged in the outbox by the
+// same pipeline update, which every field reference of a single stage sees unchanged.
+func potUpdate(name string, stack int, entryType string) mongo.Pipeline {
+
+	balance := "$record." + name
+
+	amount := stack
+	if amount < 0 {
+		amount = -amount
+	}
+
+	now := time.Now()
+	entry := bson.M{
+		"_id":           primitive.NewObjectID(),
+		"roomId":        "$_id",
+		"uri":           "$uri",
+		"type":          entryType,
+		"actor":         bson.M{"$literal": name},
+		"amount":        amount,
+		"balanceBefore": balance,
+		"balanceAfter":  bson.M{"$add": bson.A{balance, stack}},
+		"potBefore":     "$pot",
+		"potAfter":      bson.M{"$subtract": bson.A{"$pot", stack}},
+		"createdAt":     now,
+	}
+
+	return mongo.Pipeline{{{Key: "$set", Value: bson.M{
+		"record." + name: bson.M{"$add": bson.A{balance, stack}},
+		"pot":            bson.M{"$subtract": bson.A{"$pot", stack}},
+		"pots":           bson.M{"$literal": bson.A{}},
+		"version":        bson.M{"$add": bson.A{bson.M{"$ifNull": bson.A{"$version", 0}}, 1}},
+		"updatedAt":      now,
+		"outbox":         bson.M{"$concatArrays": bson.A{bson.M{"$ifNull": bson.A{"$outbox", bson.A{}}}, bson.A{entry}}},
+	}}}}
+}
+
+// stageLedger gives new ledger entries the id and time they keep in the ledger collection.
+func stageLedger(entries []*models.LedgerEntry) []*models.LedgerEntry {
+
+	now := time.Now()
+	for _, entry := range entries {
+		entry.Id = primitive.NewObjectID()
+		entry.CreatedAt = now
+	}
+
+	return entries
+}
+
+// flushLedger drains the outbox of a room after an update. The update already moved the chips, so a
+// failure is only logged and the entries wait in the outbox for the next write or read of the ledger.
+func (rs *RoomServiceImpl) flushLedger(ctx context.Context, id primitive.ObjectID) {
+
+	if err := rs.drainLedger(ctx, id); err != nil {
+		log.Printf("Could not move the ledger entries of room %v out of its outbox: %v\n", id.Hex(), err)
+	}
+}
+
+// drainLedger appends the entries staged in the outbox of a room to the ledger collection and removes
+// them from the outbox. Entries keep their id, so one copied twice after a crash is only stored once.
+// Ledger entries are never updated or deleted once written.
+func (rs *RoomServiceImpl) drainLedger(ctx context.Context, id primitive.ObjectID) error {
+
+	var room roomOutbox
+	opts := options.FindOne().SetProjection(bson.M{"outbox": 1})
+
+	if err := rs.collection.FindOne(ctx, bson.M{"_id": id}, opts).Decode(&room); err != nil {
+		return err
+	}
+
+	if len(room.Outbox) == 0 {
+		return nil
+	}
+
+	ids := make(bson.A, 0, len(room.Outbox))
+	for _, entry := range room.Outbox {
+		if _, err := rs.ledger.InsertOne(ctx, entry); err != nil && !mongo.IsDuplicateKeyError(err) {
+			return err
+		}
+		ids = append(ids, entry.Id)
+	}
+
+	update := bson.M{"$pull": bson.M{"outbox": bson.M{"_id": bson.M{"$in": ids}}}}
+	_, err := rs.collection.UpdateOne(ctx, bson.M{"_id": id}, update)
+
+	return err
 }
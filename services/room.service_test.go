@@ -0,0 +1,367 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go-pokerchips/config"
+	"go-pokerchips/models"
+	"golang.org/x/crypto/bcrypt"
+	"math/rand"
+	"os"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Set MONGO_TEST_URI to run the tests against Mongo too. The database is dropped afterwards.
+const mongoTestUriEnv = "MONGO_TEST_URI"
+
+// roomServices opens every backend the tests can run against.
+func roomServices() map[string]func(t *testing.T) RoomService {
+
+	return map[string]func(t *testing.T) RoomService{
+		config.StorageMemory: func(t *testing.T) RoomService {
+			return NewMemoryRoomService()
+		},
+		config.StorageSQLite: func(t *testing.T) RoomService {
+			db := openTestSQLite(t)
+			if err := MigrateSQLite(db); err != nil {
+				t.Fatal(err)
+			}
+			return NewSQLiteRoomService(db)
+		},
+		config.StorageMongo: func(t *testing.T) RoomService {
+			uri := os.Getenv(mongoTestUriEnv)
+			if uri == "" {
+				t.Skipf("%v is not set", mongoTestUriEnv)
+			}
+
+			ctx := context.Background()
+			client := config.InitMongo(config.Config{DBUri: uri}, ctx)
+			db := client.Database(fmt.Sprintf("poker-chips-test-%d", rand.Int()))
+			t.Cleanup(func() {
+				db.Drop(ctx)
+				client.Disconnect(ctx)
+			})
+
+			return NewRoomService(db.Collection("rooms"), db.Collection("ledger"))
+		},
+	}
+}
+
+// TestConcurrentPotUpdates hammers one room with bets, takes and joins from many goroutines.
+// No chip may be created or lost, and the ledger must account for every chip in the pot.
+func TestConcurrentPotUpdates(t *testing.T) {
+
+	const (
+		workers = 16
+		moves   = 50
+		stack   = 100
+	)
+
+	for name, open := range roomServices() {
+		t.Run(name, func(t *testing.T) {
+
+			rs := open(t)
+
+			room, err := rs.CreateRoom(&models.CreateRoomInput{
+				Creator:  "p0",
+				Settings: models.RoomSettings{StartingStack: stack, MaxPlayers: models.MaxPlayersLimit},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			id := room.Id.Hex()
+
+			players := []string{"p0", "p1", "p2", "p3"}
+			for _, player := range players[1:] {
+				if err = rs.RegisterUserInRoom(id, player, "", ""); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			var wg sync.WaitGroup
+			errs := make(chan error, workers*(moves+1))
+
+			for w := 0; w < workers; w++ {
+				wg.Add(1)
+				go func(w int) {
+					defer wg.Done()
+
+					// Two workers race for every late seat, only one of them may get it
+					late := fmt.Sprintf("late%d", w%6)
+					if err := rs.RegisterUserInRoom(id, late, "", ""); err != nil &&
+						!errors.Is(err, ErrUserRegistered) && !errors.Is(err, ErrRoomFull) && !errors.Is(err, ErrConcurrentUpdate) {
+						errs <- fmt.Errorf("register %v: %w", late, err)
+					}
+
+					random := rand.New(rand.NewSource(int64(w)))
+					for i := 0; i < moves; i++ {
+						player := players[random.Intn(len(players))]
+						chips := random.Intn(30) + 1
+
+						if random.Intn(2) == 0 {
+							if _, err := rs.AddPot(id, player, chips); err != nil && !errors.Is(err, ErrNotEnoughChips) {
+								errs <- fmt.Errorf("add %v for %v: %w", chips, player, err)
+							}
+						} else {
+							if _, err := rs.TakePot(id, player, chips); err != nil && !errors.Is(err, ErrNotEnoughPot) {
+								errs <- fmt.Errorf("take %v for %v: %w", chips, player, err)
+							}
+						}
+					}
+				}(w)
+			}
+
+			wg.Wait()
+			close(errs)
+
+			for err := range errs {
+				t.Error(err)
+			}
+
+			room, err = rs.FindRoomByUri(room.Uri)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			total := room.Pot
+			for player, chips := range room.Record {
+				if chips < 0 {
+					t.Errorf("%v has %v chips", player, chips)
+				}
+				total += chips
+			}
+
+			if want := len(room.Record) * stack; total != want {
+				t.Errorf("players and pot hold %v chips, want %v", total, want)
+			}
+
+			entries, err := rs.FindLedgerByUri(room.Uri, 1, 100000)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			pot, registered := 0, 0
+			for _, entry := range entries {
+				switch entry.Type {
+				case models.LedgerRegister:
+					registered++
+				case models.LedgerBet:
+					pot += entry.Amount
+				case models.LedgerTake:
+					pot -= entry.Amount
+				}
+			}
+
+			if pot != room.Pot {
+				t.Errorf("ledger accounts for a pot of %v, the room has %v", pot, room.Pot)
+			}
+
+			if registered != len(room.Record) {
+				t.Errorf("ledger registered %v players, the room has %v", registered, len(room.Record))
+			}
+		})
+	}
+}
+
+// TestLedgerPaging reads the ledger one page at a time, the pages put together must be every entry in order.
+func TestLedgerPaging(t *testing.T) {
+
+	for name, open := range roomServices() {
+		t.Run(name, func(t *testing.T) {
+
+			rs := open(t)
+
+			room, err := rs.CreateRoom(&models.CreateRoomInput{Creator: "ann"})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// The register entry of the creator and one bet of each amount
+			for amount := 1; amount <= 6; amount++ {
+				if _, err = rs.AddPot(room.Id.Hex(), "ann", amount); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			var all []*models.LedgerEntry
+			for page, want := range []int{3, 3, 1, 0} {
+				entries, err := rs.FindLedgerByUri(room.Uri, int64(page+1), 3)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(entries) != want {
+					t.Fatalf("page %v has %v entries, want %v", page+1, len(entries), want)
+				}
+				all = append(all, entries...)
+			}
+
+			if all[0].Type != models.LedgerRegister || all[0].Actor != "ann" {
+				t.Errorf("first entry is %v of %v, want the register of ann", all[0].Type, all[0].Actor)
+			}
+			for i, entry := range all[1:] {
+				if entry.Type != models.LedgerBet || entry.Amount != i+1 {
+					t.Errorf("entry %v is %v of %v, want a bet of %v", i+2, entry.Type, entry.Amount, i+1)
+				}
+			}
+		})
+	}
+}
+
+// TestPasswordAndInvites joins a password protected room with its password and with invites,
+// which can only be used once.
+func TestPasswordAndInvites(t *testing.T) {
+
+	for name, open := range roomServices() {
+		t.Run(name, func(t *testing.T) {
+
+			rs := open(t)
+
+			room, err := rs.CreateRoom(&models.CreateRoomInput{Creator: "ann", Password: "secret"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			id := room.Id.Hex()
+
+			// Only a bcrypt hash of the password is stored
+			stored, err := rs.FindRoomByUri(room.Uri)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if bcrypt.CompareHashAndPassword([]byte(stored.PasswordHash), []byte("secret")) != nil {
+				t.Fatalf("stored password hash %q is not the bcrypt hash of the password", stored.PasswordHash)
+			}
+
+			invite, _, err := rs.CreateInvite(id, "ann", time.Hour)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, _, err = rs.CreateInvite(id, "bob", time.Hour); err != ErrNotHost {
+				t.Errorf("a player created an invite with error %v, want %v", err, ErrNotHost)
+			}
+
+			steps := []struct {
+				name     string
+				player   string
+				password string
+				invite   string
+				err      error
+			}{
+				{"no password", "bob", "", "", ErrPasswordRequired},
+				{"wrong password", "bob", "guess", "", ErrWrongPassword},
+				{"password", "bob", "secret", "", nil},
+				{"unknown invite", "cat", "", "not an invite", ErrInvalidInvite},
+				{"invite", "cat", "", invite, nil},
+				{"invite used again", "dan", "", invite, ErrInvalidInvite},
+				{"invite used again with the password", "dan", "secret", invite, ErrInvalidInvite},
+			}
+
+			for _, step := range steps {
+				if err = rs.RegisterUserInRoom(id, step.player, step.password, step.invite); err != step.err {
+					t.Errorf("%v: got error %v, want %v", step.name, err, step.err)
+				}
+			}
+
+			if room, err = rs.FindRoomByUri(room.Uri); err != nil {
+				t.Fatal(err)
+			}
+			if len(room.Record) != 3 || len(room.Invites) != 0 {
+				t.Errorf("room has %v players and %v invites, want ann, bob and cat and no invite left", len(room.Record), len(room.Invites))
+			}
+		})
+	}
+}
+
+// TestSeats moves the players around the table. Every step is stored, the seats read back after it
+// must be the ones the step returned.
+func TestSeats(t *testing.T) {
+
+	seat := func(number int, player string) models.Seat {
+		return models.Seat{Number: number, Player: player}
+	}
+
+	for name, open := range roomServices() {
+		t.Run(name, func(t *testing.T) {
+
+			rs := open(t)
+
+			room, err := rs.CreateRoom(&models.CreateRoomInput{Creator: "ann", Settings: models.RoomSettings{MaxPlayers: 4}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			id := room.Id.Hex()
+
+			steps := []struct {
+				name  string
+				step  func() ([]models.Seat, error)
+				err   error
+				seats []models.Seat
+			}{
+				{"players are seated as they join", func() ([]models.Seat, error) {
+					for _, player := range []string{"bob", "cat", "dan"} {
+						if err := rs.RegisterUserInRoom(id, player, "", ""); err != nil {
+							return nil, err
+						}
+					}
+					return nil, nil
+				}, nil, []models.Seat{seat(1, "ann"), seat(2, "bob"), seat(3, "cat"), seat(4, "dan")}},
+				{"no seat 0", func() ([]models.Seat, error) { return rs.TakeSeat(id, "bob", 0) }, ErrInvalidSeat, nil},
+				{"no seat past the most players", func() ([]models.Seat, error) { return rs.TakeSeat(id, "bob", 5) }, ErrInvalidSeat, nil},
+				{"seat taken", func() ([]models.Seat, error) { return rs.TakeSeat(id, "bob", 1) }, ErrSeatTaken, nil},
+				{"not in the room", func() ([]models.Seat, error) { return rs.TakeSeat(id, "eve", 1) }, ErrUserNotRegistered, nil},
+				{"stand up", func() ([]models.Seat, error) { return rs.StandUp(id, "cat") }, nil,
+					[]models.Seat{seat(1, "ann"), seat(2, "bob"), seat(4, "dan")}},
+				{"stand up again", func() ([]models.Seat, error) { return rs.StandUp(id, "cat") }, ErrNotSeated, nil},
+				{"sit out standing", func() ([]models.Seat, error) { return rs.SitOut(id, "cat", true) }, ErrNotSeated, nil},
+				{"move", func() ([]models.Seat, error) { return rs.TakeSeat(id, "bob", 3) }, nil,
+					[]models.Seat{seat(1, "ann"), seat(3, "bob"), seat(4, "dan")}},
+				{"sit down", func() ([]models.Seat, error) { return rs.TakeSeat(id, "cat", 2) }, nil,
+					[]models.Seat{seat(1, "ann"), seat(2, "cat"), seat(3, "bob"), seat(4, "dan")}},
+				{"sit out", func() ([]models.Seat, error) { return rs.SitOut(id, "dan", true) }, nil,
+					[]models.Seat{seat(1, "ann"), seat(2, "cat"), seat(3, "bob"), {Number: 4, Player: "dan", SittingOut: true}}},
+				{"sit out again", func() ([]models.Seat, error) { return rs.SitOut(id, "dan", true) }, ErrSittingOut, nil},
+				{"sit in", func() ([]models.Seat, error) { return rs.SitOut(id, "dan", false) }, nil,
+					[]models.Seat{seat(1, "ann"), seat(2, "cat"), seat(3, "bob"), seat(4, "dan")}},
+				{"sit in again", func() ([]models.Seat, error) { return rs.SitOut(id, "dan", false) }, ErrNotSittingOut, nil},
+				{"away", func() ([]models.Seat, error) { return rs.SetAway(id, "bob", true) }, nil,
+					[]models.Seat{seat(1, "ann"), seat(2, "cat"), {Number: 3, Player: "bob", Away: true}, seat(4, "dan")}},
+				{"taking the own seat sits back in", func() ([]models.Seat, error) { return rs.TakeSeat(id, "bob", 3) }, nil,
+					[]models.Seat{seat(1, "ann"), seat(2, "cat"), seat(3, "bob"), seat(4, "dan")}},
+				{"swapped by a player", func() ([]models.Seat, error) { return rs.SwapSeats(id, "bob", 1, 2) }, ErrNotHost, nil},
+				{"swap", func() ([]models.Seat, error) { return rs.SwapSeats(id, "ann", 1, 3) }, nil,
+					[]models.Seat{seat(1, "bob"), seat(2, "cat"), seat(3, "ann"), seat(4, "dan")}},
+				{"kick frees the seat", func() ([]models.Seat, error) { return nil, rs.KickUser(id, "ann", "cat", "") }, nil,
+					[]models.Seat{seat(1, "bob"), seat(3, "ann"), seat(4, "dan")}},
+				{"swap with an empty seat", func() ([]models.Seat, error) { return rs.SwapSeats(id, "ann", 4, 2) }, nil,
+					[]models.Seat{seat(1, "bob"), seat(2, "dan"), seat(3, "ann")}},
+				{"the next player gets the free seat", func() ([]models.Seat, error) { return nil, rs.RegisterUserInRoom(id, "eve", "", "") }, nil,
+					[]models.Seat{seat(1, "bob"), seat(2, "dan"), seat(3, "ann"), seat(4, "eve")}},
+			}
+
+			for _, step := range steps {
+
+				seats, err := step.step()
+				if err != step.err {
+					t.Fatalf("%v: got error %v, want %v", step.name, err, step.err)
+				}
+				if step.seats == nil {
+					continue
+				}
+				if seats != nil && !reflect.DeepEqual(seats, step.seats) {
+					t.Errorf("%v: got seats %v, want %v", step.name, seats, step.seats)
+				}
+
+				stored, err := rs.FindRoomByUri(room.Uri)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !reflect.DeepEqual(stored.Seats, step.seats) {
+					t.Errorf("%v: stored seats are %v, want %v", step.name, stored.Seats, step.seats)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,112 @@
+package services
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+)
+
+// sqliteMigrations are applied in order. The index of a migration plus one is the schema
+// version stored in PRAGMA user_version once it has been applied.
+// Never edit a released migration, append a new one instead.
+var sqliteMigrations = []string{
+	`CREATE TABLE rooms (
+		id         TEXT PRIMARY KEY,
+		uri        TEXT NOT NULL UNIQUE,
+		pot        INTEGER NOT NULL DEFAULT 0,
+		version    INTEGER NOT NULL DEFAULT 0,
+		created_at DATETIME NOT NULL,
+		updated_at DATETIME NOT NULL
+	);
+
+	CREATE TABLE records (
+		room_id TEXT NOT NULL REFERENCES rooms(id) ON DELETE CASCADE,
+		name    TEXT NOT NULL,
+		chips   INTEGER NOT NULL,
+		PRIMARY KEY (room_id, name)
+	);
+
+	CREATE TABLE ledger (
+		seq            INTEGER PRIMARY KEY AUTOINCREMENT,
+		id             TEXT NOT NULL UNIQUE,
+		room_id        TEXT NOT NULL REFERENCES rooms(id) ON DELETE CASCADE,
+		uri            TEXT NOT NULL,
+		type           TEXT NOT NULL,
+		actor          TEXT NOT NULL,
+		amount         INTEGER NOT NULL,
+		balance_before INTEGER NOT NULL,
+		balance_after  INTEGER NOT NULL,
+		pot_before     INTEGER NOT NULL,
+		pot_after      INTEGER NOT NULL,
+		created_at     DATETIME NOT NULL
+	);
+
+	CREATE INDEX ledger_room_seq ON ledger (room_id, seq);`,
+
+	// Main pot and side pots as a JSON array of models.Pot
+	`ALTER TABLE rooms ADD COLUMN pots TEXT NOT NULL DEFAULT '[]';`,
+
+	`ALTER TABLE rooms ADD COLUMN small_blind INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE rooms ADD COLUMN big_blind INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE rooms ADD COLUMN ante INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE rooms ADD COLUMN dealer TEXT NOT NULL DEFAULT '';`,
+
+	// The tournament column holds a JSON models.Tournament, or null for cash rooms
+	`ALTER TABLE rooms ADD COLUMN creator TEXT NOT NULL DEFAULT '';
+	ALTER TABLE rooms ADD COLUMN type TEXT NOT NULL DEFAULT 'cash';
+	ALTER TABLE rooms ADD COLUMN tournament TEXT NOT NULL DEFAULT 'null';`,
+
+	// JSON models.RoomSettings, rooms from before get the defaults
+	`ALTER TABLE rooms ADD COLUMN settings TEXT NOT NULL DEFAULT '{}';`,
+
+	// Host role, kicked is a JSON array of names
+	`ALTER TABLE rooms ADD COLUMN host TEXT NOT NULL DEFAULT '';
+	ALTER TABLE rooms ADD COLUMN locked BOOLEAN NOT NULL DEFAULT 0;
+	ALTER TABLE rooms ADD COLUMN closed BOOLEAN NOT NULL DEFAULT 0;
+	ALTER TABLE rooms ADD COLUMN kicked TEXT NOT NULL DEFAULT '[]';
+	ALTER TABLE ledger ADD COLUMN host TEXT NOT NULL DEFAULT '';
+	ALTER TABLE ledger ADD COLUMN reason TEXT NOT NULL DEFAULT '';`,
+
+	// Invites is a JSON array of models.Invite, the token hash included
+	`ALTER TABLE rooms ADD COLUMN password_hash TEXT NOT NULL DEFAULT '';
+	ALTER TABLE rooms ADD COLUMN invites TEXT NOT NULL DEFAULT '[]';`,
+
+	// Seats is a JSON array of models.Seat, null for rooms from before there were seats
+	`ALTER TABLE rooms ADD COLUMN seats TEXT NOT NULL DEFAULT 'null';`,
+}
+
+// MigrateSQLite brings the database schema up to date.
+func MigrateSQLite(db *sql.DB) error {
+
+	var version int
+	if err := db.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		return err
+	}
+
+	for i := version; i < len(sqliteMigrations); i++ {
+
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+
+		if _, err = tx.Exec(sqliteMigrations[i]); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %v failed: %w", i+1, err)
+		}
+
+		// PRAGMA does not accept bound parameters.
+		if _, err = tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", i+1)); err != nil {
+			tx.Rollback()
+			return err
+		}
+
+		if err = tx.Commit(); err != nil {
+			return err
+		}
+
+		log.Printf("SQLite schema migrated to version %v\n", i+1)
+	}
+
+	return nil
+}
@@ -0,0 +1,112 @@
+package services
+
+import (
+	"database/sql"
+	"go-pokerchips/config"
+	"go-pokerchips/models"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func openTestSQLite(t *testing.T) *sql.DB {
+
+	db := config.InitSQLite(config.Config{SQLitePath: filepath.Join(t.TempDir(), "rooms.db")})
+	t.Cleanup(func() { db.Close() })
+
+	return db
+}
+
+func userVersion(t *testing.T, db *sql.DB) int {
+
+	t.Helper()
+
+	var version int
+	if err := db.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		t.Fatal(err)
+	}
+
+	return version
+}
+
+func TestMigrateEmptySQLite(t *testing.T) {
+
+	db := openTestSQLite(t)
+
+	// Migrating again finds nothing left to do
+	for i := 0; i < 2; i++ {
+		if err := MigrateSQLite(db); err != nil {
+			t.Fatal(err)
+		}
+		if version := userVersion(t, db); version != len(sqliteMigrations) {
+			t.Fatalf("schema version %v, want %v", version, len(sqliteMigrations))
+		}
+	}
+
+	ss := NewSQLiteRoomService(db)
+	room, err := ss.CreateRoom(&models.CreateRoomInput{Creator: "ann"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = ss.RegisterUserInRoom(room.Id.Hex(), "bob", "", ""); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestMigrateOldSQLite upgrades a database holding a room from the first schema version.
+func TestMigrateOldSQLite(t *testing.T) {
+
+	db := openTestSQLite(t)
+
+	if _, err := db.Exec(sqliteMigrations[0]); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("PRAGMA user_version = 1"); err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	if _, err := db.Exec("INSERT INTO rooms (id, uri, pot, created_at, updated_at) VALUES (?, ?, ?, ?, ?)",
+		"63245e6f0a1b2c3d4e5f6071", "old01", 20, now, now); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("INSERT INTO records (room_id, name, chips) VALUES (?, ?, ?)",
+		"63245e6f0a1b2c3d4e5f6071", "ann", 480); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := MigrateSQLite(db); err != nil {
+		t.Fatal(err)
+	}
+	if version := userVersion(t, db); version != len(sqliteMigrations) {
+		t.Fatalf("schema version %v, want %v", version, len(sqliteMigrations))
+	}
+
+	ss := NewSQLiteRoomService(db)
+	room, err := ss.FindRoomByUri("old01")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if room.Pot != 20 || room.Record["ann"] != 480 || room.Type != models.RoomTypeCash || room.Tournament != nil {
+		t.Errorf("got pot %v, record %v and type %v, want the room as it was", room.Pot, room.Record, room.Type)
+	}
+
+	// Rooms from before there were settings get the defaults
+	if err = ss.RegisterUserInRoom(room.Id.Hex(), "bob", "", ""); err != nil {
+		t.Fatal(err)
+	}
+	if room, err = ss.FindRoomByUri("old01"); err != nil {
+		t.Fatal(err)
+	}
+	if chips := room.Record["bob"]; chips != models.DefaultStartingStack {
+		t.Errorf("bob joined with %v chips, want %v", chips, models.DefaultStartingStack)
+	}
+
+	entries, err := ss.FindLedgerByUri("old01", 1, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Type != models.LedgerRegister || entries[0].Actor != "bob" {
+		t.Errorf("got ledger %v, want the register of bob", entries)
+	}
+}
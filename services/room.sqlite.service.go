@@ -0,0 +1,608 @@
+package services
+
+import (
+	"database/sql"
+	"encoding/json"
+	"github.com/dchest/uniuri"
+	"go-pokerchips/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"strings"
+	"time"
+)
+
+// SQLiteRoomService stores rooms in an embedded SQLite database.
+// Every mutation loads the room and writes it back inside a single transaction.
+type SQLiteRoomService struct {
+	db *sql.DB
+}
+
+func NewSQLiteRoomService(db *sql.DB) RoomService {
+	return &SQLiteRoomService{db}
+}
+
+// sqlQuerier is implemented by both *sql.DB and *sql.Tx.
+type sqlQuerier interface {
+	QueryRow(query string, args ...any) *sql.Row
+	Query(query string, args ...any) (*sql.Rows, error)
+}
+
+func (ss *SQLiteRoomService) CreateRoom(room *models.CreateRoomInput) (*models.DBRoom, error) {
+
+	if err := prepareRoom(room); err != nil {
+		return nil, err
+	}
+
+	room.Uri = uniuri.NewLen(5)
+	room.CreatedAt = time.Now()
+	room.UpdatedAt = room.CreatedAt
+
+	newRoom := &models.DBRoom{
+		Id:           primitive.NewObjectID(),
+		Uri:          room.Uri,
+		Creator:      room.Creator,
+		Type:         room.Type,
+		Pots:         []models.Pot{},
+		Record:       make(map[string]int),
+		Host:         room.Host,
+		Blinds:       room.Blinds,
+		PasswordHash: room.PasswordHash,
+		Settings:     room.Settings,
+		Tournament:   copyTournament(room.Tournament),
+		Seats:        append([]models.Seat{}, room.Seats...),
+		CreatedAt:    room.CreatedAt,
+		UpdatedAt:    room.UpdatedAt,
+	}
+	for name, chips := range room.Record {
+		newRoom.Record[name] = chips
+	}
+
+	tx, err := ss.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	pots, err := json.Marshal(newRoom.Pots)
+	if err != nil {
+		return nil, err
+	}
+
+	tournament, err := json.Marshal(newRoom.Tournament)
+	if err != nil {
+		return nil, err
+	}
+
+	settings, err := json.Marshal(newRoom.Settings)
+	if err != nil {
+		return nil, err
+	}
+
+	seats, err := json.Marshal(newRoom.Seats)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.Exec(
+		`INSERT INTO rooms (id, uri, creator, host, type, pot, pots, small_blind, big_blind, ante, settings, tournament,
+		password_hash, seats, version, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		newRoom.Id.Hex(), newRoom.Uri, newRoom.Creator, newRoom.Host, newRoom.Type, newRoom.Pot, string(pots),
+		newRoom.Blinds.SmallBlind, newRoom.Blinds.BigBlind, newRoom.Blinds.Ante, string(settings), string(tournament),
+		newRoom.PasswordHash, string(seats), newRoom.Version, newRoom.CreatedAt, newRoom.UpdatedAt,
+	)
+	if err != nil {
+		if strings.Contains(err.Error(), "UNIQUE constraint failed") {
+			return nil, ErrRoomExists
+		}
+		return nil, err
+	}
+
+	if err = ss.saveRecord(tx, newRoom); err != nil {
+		return nil, err
+	}
+
+	if err = ss.writeLedger(tx, registerEntries(newRoom)); err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return newRoom, nil
+}
+
+func (ss *SQLiteRoomService) FindRoomById(id string) (*models.DBRoom, error) {
+
+	objId, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+
+	return ss.loadRoom(ss.db, "id", objId.Hex())
+}
+
+func (ss *SQLiteRoomService) FindRoomByUri(uri string) (*models.DBRoom, error) {
+	return ss.loadRoom(ss.db, "uri", uri)
+}
+
+func (ss *SQLiteRoomService) RegisterUserInRoom(id string, name string, password string, invite string) error {
+
+	if !isValidUserName(name) {
+		return ErrInvalidUserName
+	}
+
+	room, err := ss.FindRoomById(id)
+	if err != nil {
+		return err
+	}
+
+	if err = checkPassword(room, password, invite); err != nil {
+		return err
+	}
+
+	_, err = ss.update(id, func(room *models.DBRoom) ([]*models.LedgerEntry, error) {
+		return applyRegister(room, name, invite)
+	})
+
+	return err
+}
+
+func (ss *SQLiteRoomService) AddPot(id string, name string, chips int) (*models.UpdatePotResponse, error) {
+
+	if chips <= 0 {
+		return nil, ErrInvalidAmount
+	}
+
+	if !isValidUserName(name) {
+		return nil, ErrInvalidUserName
+	}
+
+	room, err := ss.update(id, func(room *models.DBRoom) ([]*models.LedgerEntry, error) {
+		return applyAddPot(room, name, chips)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return potResponse(room, name), nil
+}
+
+func (ss *SQLiteRoomService) TakePot(id string, name string, chips int) (*models.UpdatePotResponse, error) {
+
+	if chips <= 0 {
+		return nil, ErrInvalidAmount
+	}
+
+	if !isValidUserName(name) {
+		return nil, ErrInvalidUserName
+	}
+
+	room, err := ss.update(id, func(room *models.DBRoom) ([]*models.LedgerEntry, error) {
+		return applyTakePot(room, name, chips)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return potResponse(room, name), nil
+}
+
+func (ss *SQLiteRoomService) UpdatePots(id string, pots []models.Pot) error {
+
+	_, err := ss.update(id, func(room *models.DBRoom) ([]*models.LedgerEntry, error) {
+		return applyUpdatePots(room, pots)
+	})
+
+	return err
+}
+
+func (ss *SQLiteRoomService) AwardPot(id string, index int, winners []string) (*models.AwardPotResponse, error) {
+
+	var resp *models.AwardPotResponse
+
+	_, err := ss.update(id, func(room *models.DBRoom) ([]*models.LedgerEntry, error) {
+		entries, r, err := applyAwardPot(room, index, winners)
+		resp = r
+		return entries, err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return resp, nil
+}
+
+func (ss *SQLiteRoomService) UpdateBlinds(id string, blinds models.Blinds) error {
+
+	if !isValidBlinds(blinds) {
+		return ErrInvalidBlinds
+	}
+
+	_, err := ss.update(id, func(room *models.DBRoom) ([]*models.LedgerEntry, error) {
+		room.Blinds = blinds
+		return nil, nil
+	})
+
+	return err
+}
+
+func (ss *SQLiteRoomService) UpdateDealer(id string, dealer string) error {
+
+	_, err := ss.update(id, func(room *models.DBRoom) ([]*models.LedgerEntry, error) {
+		room.Dealer = dealer
+		return nil, nil
+	})
+
+	return err
+}
+
+func (ss *SQLiteRoomService) UpdateTournament(id string, tournament *models.Tournament) error {
+
+	_, err := ss.update(id, func(room *models.DBRoom) ([]*models.LedgerEntry, error) {
+		room.Tournament = copyTournament(tournament)
+		return nil, nil
+	})
+
+	return err
+}
+
+func (ss *SQLiteRoomService) KickUser(id string, host string, name string, reason string) error {
+
+	_, err := ss.update(id, func(room *models.DBRoom) ([]*models.LedgerEntry, error) {
+		return applyKick(room, host, name, reason)
+	})
+
+	return err
+}
+
+func (ss *SQLiteRoomService) TransferHost(id string, host string, name string) error {
+
+	_, err := ss.update(id, func(room *models.DBRoom) ([]*models.LedgerEntry, error) {
+		return applyTransferHost(room, host, name)
+	})
+
+	return err
+}
+
+// AdjustStack corrects the stack of a player and returns their new balance.
+func (ss *SQLiteRoomService) AdjustStack(id string, host string, name string, amount int, reason string) (int, error) {
+
+	room, err := ss.update(id, func(room *models.DBRoom) ([]*models.LedgerEntry, error) {
+		return applyAdjustStack(room, host, name, amount, reason)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return room.Record[name], nil
+}
+
+func (ss *SQLiteRoomService) LockRoom(id string, host string, locked bool) error {
+
+	_, err := ss.update(id, func(room *models.DBRoom) ([]*models.LedgerEntry, error) {
+		return applyLock(room, host, locked)
+	})
+
+	return err
+}
+
+func (ss *SQLiteRoomService) CloseRoom(id string, host string) error {
+
+	_, err := ss.update(id, func(room *models.DBRoom) ([]*models.LedgerEntry, error) {
+		return applyClose(room, host)
+	})
+
+	return err
+}
+
+// CreateInvite returns a new invite token for the room that can be used once before it expires.
+func (ss *SQLiteRoomService) CreateInvite(id string, host string, ttl time.Duration) (string, time.Time, error) {
+
+	if ttl <= 0 {
+		return "", time.Time{}, ErrInvalidAmount
+	}
+
+	token := uniuri.NewLen(24)
+	expiresAt := time.Now().Add(ttl)
+
+	_, err := ss.update(id, func(room *models.DBRoom) ([]*models.LedgerEntry, error) {
+		return applyCreateInvite(room, host, token, expiresAt)
+	})
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	return token, expiresAt, nil
+}
+
+func (ss *SQLiteRoomService) TakeSeat(id string, name string, number int) ([]models.Seat, error) {
+
+	room, err := ss.update(id, func(room *models.DBRoom) ([]*models.LedgerEntry, error) {
+		return applyTakeSeat(room, name, number)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return room.Seats, nil
+}
+
+func (ss *SQLiteRoomService) StandUp(id string, name string) ([]models.Seat, error) {
+
+	room, err := ss.update(id, func(room *models.DBRoom) ([]*models.LedgerEntry, error) {
+		return applyStandUp(room, name)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return room.Seats, nil
+}
+
+func (ss *SQLiteRoomService) SitOut(id string, name string, sittingOut bool) ([]models.Seat, error) {
+
+	room, err := ss.update(id, func(room *models.DBRoom) ([]*models.LedgerEntry, error) {
+		return applySitOut(room, name, sittingOut)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return room.Seats, nil
+}
+
+func (ss *SQLiteRoomService) SetAway(id string, name string, away bool) ([]models.Seat, error) {
+
+	room, err := ss.update(id, func(room *models.DBRoom) ([]*models.LedgerEntry, error) {
+		return applySetAway(room, name, away)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return room.Seats, nil
+}
+
+func (ss *SQLiteRoomService) SwapSeats(id string, host string, first int, second int) ([]models.Seat, error) {
+
+	room, err := ss.update(id, func(room *models.DBRoom) ([]*models.LedgerEntry, error) {
+		return applySwapSeats(room, host, first, second)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return room.Seats, nil
+}
+
+func (ss *SQLiteRoomService) FindLedgerByUri(uri string, page int64, limit int64) ([]*models.LedgerEntry, error) {
+
+	room, err := ss.FindRoomByUri(uri)
+	if err != nil {
+		return nil, err
+	}
+
+	if page < 1 {
+		page = 1
+	}
+
+	rows, err := ss.db.Query(
+		`SELECT id, room_id, uri, type, actor, amount, balance_before, balance_after, pot_before, pot_after, host, reason, created_at
+		FROM ledger WHERE room_id = ? ORDER BY seq LIMIT ? OFFSET ?`,
+		room.Id.Hex(), limit, (page-1)*limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := make([]*models.LedgerEntry, 0)
+	for rows.Next() {
+		var id, roomId string
+		entry := &models.LedgerEntry{}
+
+		err = rows.Scan(&id, &roomId, &entry.Uri, &entry.Type, &entry.Actor, &entry.Amount,
+			&entry.BalanceBefore, &entry.BalanceAfter, &entry.PotBefore, &entry.PotAfter, &entry.Host, &entry.Reason, &entry.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		if entry.Id, err = primitive.ObjectIDFromHex(id); err != nil {
+			return nil, err
+		}
+		if entry.RoomId, err = primitive.ObjectIDFromHex(roomId); err != nil {
+			return nil, err
+		}
+
+		entries = append(entries, entry)
+	}
+
+	return entries, rows.Err()
+}
+
+// update loads the room, applies the mutation and writes the room and its ledger entries back
+// in one transaction. Nothing is written if the mutation returns an error.
+func (ss *SQLiteRoomService) update(id string, mutate roomMutation) (*models.DBRoom, error) {
+
+	objId, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := ss.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	room, err := ss.loadRoom(tx, "id", objId.Hex())
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := mutate(room)
+	if err != nil {
+		return nil, err
+	}
+
+	room.Version++
+	room.UpdatedAt = time.Now()
+
+	pots, err := json.Marshal(room.Pots)
+	if err != nil {
+		return nil, err
+	}
+
+	tournament, err := json.Marshal(room.Tournament)
+	if err != nil {
+		return nil, err
+	}
+
+	kicked, err := json.Marshal(room.Kicked)
+	if err != nil {
+		return nil, err
+	}
+
+	invites, err := json.Marshal(room.Invites)
+	if err != nil {
+		return nil, err
+	}
+
+	seats, err := json.Marshal(room.Seats)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.Exec(
+		`UPDATE rooms SET pot = ?, pots = ?, small_blind = ?, big_blind = ?, ante = ?, dealer = ?, tournament = ?,
+		host = ?, locked = ?, closed = ?, kicked = ?, invites = ?, seats = ?, version = ?, updated_at = ?
+		WHERE id = ?`,
+		room.Pot, string(pots), room.Blinds.SmallBlind, room.Blinds.BigBlind, room.Blinds.Ante, room.Dealer, string(tournament),
+		room.Host, room.Locked, room.Closed, string(kicked), string(invites), string(seats), room.Version, room.UpdatedAt, room.Id.Hex(),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = ss.saveRecord(tx, room); err != nil {
+		return nil, err
+	}
+
+	if err = ss.writeLedger(tx, entries); err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return room, nil
+}
+
+// loadRoom reads a room and its record by one of its unique columns.
+func (ss *SQLiteRoomService) loadRoom(q sqlQuerier, column string, value string) (*models.DBRoom, error) {
+
+	var id, pots, settings, tournament, kicked, invites, seats string
+	room := &models.DBRoom{Record: make(map[string]int)}
+
+	err := q.QueryRow(
+		`SELECT id, uri, creator, host, type, pot, pots, small_blind, big_blind, ante, dealer, settings, tournament,
+		locked, closed, kicked, password_hash, invites, seats, version, created_at, updated_at
+		FROM rooms WHERE `+column+" = ?", value,
+	).Scan(&id, &room.Uri, &room.Creator, &room.Host, &room.Type, &room.Pot, &pots, &room.Blinds.SmallBlind, &room.Blinds.BigBlind,
+		&room.Blinds.Ante, &room.Dealer, &settings, &tournament, &room.Locked, &room.Closed, &kicked,
+		&room.PasswordHash, &invites, &seats, &room.Version, &room.CreatedAt, &room.UpdatedAt)
+
+	if err == sql.ErrNoRows {
+		return nil, ErrRoomNotFound
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if room.Id, err = primitive.ObjectIDFromHex(id); err != nil {
+		return nil, err
+	}
+
+	if err = json.Unmarshal([]byte(pots), &room.Pots); err != nil {
+		return nil, err
+	}
+
+	if err = json.Unmarshal([]byte(settings), &room.Settings); err != nil {
+		return nil, err
+	}
+
+	if err = json.Unmarshal([]byte(tournament), &room.Tournament); err != nil {
+		return nil, err
+	}
+
+	if err = json.Unmarshal([]byte(kicked), &room.Kicked); err != nil {
+		return nil, err
+	}
+
+	if err = json.Unmarshal([]byte(invites), &room.Invites); err != nil {
+		return nil, err
+	}
+
+	if err = json.Unmarshal([]byte(seats), &room.Seats); err != nil {
+		return nil, err
+	}
+
+	rows, err := q.Query("SELECT name, chips FROM records WHERE room_id = ?", id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var name string
+		var chips int
+		if err = rows.Scan(&name, &chips); err != nil {
+			return nil, err
+		}
+		room.Record[name] = chips
+	}
+
+	return room, rows.Err()
+}
+
+// saveRecord replaces the stored record of the room with room.Record.
+func (ss *SQLiteRoomService) saveRecord(tx *sql.Tx, room *models.DBRoom) error {
+
+	if _, err := tx.Exec("DELETE FROM records WHERE room_id = ?", room.Id.Hex()); err != nil {
+		return err
+	}
+
+	for name, chips := range room.Record {
+		_, err := tx.Exec("INSERT INTO records (room_id, name, chips) VALUES (?, ?, ?)", room.Id.Hex(), name, chips)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// writeLedger appends entries to the ledger table.
+func (ss *SQLiteRoomService) writeLedger(tx *sql.Tx, entries []*models.LedgerEntry) error {
+
+	for _, entry := range entries {
+
+		entry.Id = primitive.NewObjectID()
+		entry.CreatedAt = time.Now()
+
+		_, err := tx.Exec(
+			`INSERT INTO ledger (id, room_id, uri, type, actor, amount, balance_before, balance_after, pot_before, pot_after, host, reason, created_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			entry.Id.Hex(), entry.RoomId.Hex(), entry.Uri, entry.Type, entry.Actor, entry.Amount,
+			entry.BalanceBefore, entry.BalanceAfter, entry.PotBefore, entry.PotAfter, entry.Host, entry.Reason, entry.CreatedAt,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,98 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"go-pokerchips/models"
+	"strings"
+	"time"
+)
+
+var ErrInvalidSession = errors.New("invalid session")
+
+// SessionService signs the session cookie so players cannot edit it to act as someone else.
+// A signed session is the base64 encoded JSON session, a dot and the base64 encoded HMAC-SHA256 of it.
+type SessionService struct {
+	secret []byte
+	ttl    time.Duration
+}
+
+// signedSession is what the cookie carries. The times are in Unix seconds and signed with the
+// player, so an old cookie cannot be made to last longer.
+type signedSession struct {
+	User      string `json:"name"`
+	Uri       string `json:"uri"`
+	IssuedAt  int64  `json:"iat"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+// NewSessionService signs sessions that last for the ttl.
+func NewSessionService(secret []byte, ttl time.Duration) *SessionService {
+	return &SessionService{secret, ttl}
+}
+
+// TTL is how long a signed session lasts.
+func (ss *SessionService) TTL() time.Duration {
+	return ss.ttl
+}
+
+func (ss *SessionService) Sign(session *models.JoinRoomInput) (string, error) {
+
+	now := time.Now()
+
+	payload, err := json.Marshal(signedSession{
+		User:      session.User,
+		Uri:       session.Uri,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(ss.ttl).Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+
+	return encoded + "." + base64.RawURLEncoding.EncodeToString(ss.mac(encoded)), nil
+}
+
+// Verify returns the session of a signed cookie value, or ErrInvalidSession if it was tampered with
+// or has expired.
+func (ss *SessionService) Verify(value string) (*models.JoinRoomInput, error) {
+
+	encoded, signature, ok := strings.Cut(value, ".")
+	if !ok {
+		return nil, ErrInvalidSession
+	}
+
+	mac, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil || !hmac.Equal(mac, ss.mac(encoded)) {
+		return nil, ErrInvalidSession
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, ErrInvalidSession
+	}
+
+	var session *signedSession
+	if err = json.Unmarshal(payload, &session); err != nil || session == nil || session.Uri == "" || session.User == "" {
+		return nil, ErrInvalidSession
+	}
+
+	if time.Now().Unix() >= session.ExpiresAt {
+		return nil, ErrInvalidSession
+	}
+
+	return &models.JoinRoomInput{User: session.User, Uri: session.Uri}, nil
+}
+
+func (ss *SessionService) mac(encoded string) []byte {
+
+	h := hmac.New(sha256.New, ss.secret)
+	h.Write([]byte(encoded))
+
+	return h.Sum(nil)
+}
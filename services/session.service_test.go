@@ -0,0 +1,74 @@
+package services
+
+import (
+	"encoding/base64"
+	"go-pokerchips/models"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSessionRoundTrip(t *testing.T) {
+
+	sessionService := NewSessionService([]byte("test secret"), time.Hour)
+
+	value, err := sessionService.Sign(&models.JoinRoomInput{User: "ann", Uri: "room", Password: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	session, err := sessionService.Verify(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *session != (models.JoinRoomInput{User: "ann", Uri: "room"}) {
+		t.Errorf("got session %+v, want ann in room without the password", session)
+	}
+}
+
+func TestSessionRejected(t *testing.T) {
+
+	sessionService := NewSessionService([]byte("test secret"), time.Hour)
+
+	value, err := sessionService.Sign(&models.JoinRoomInput{User: "ann", Uri: "room"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	encoded, signature, _ := strings.Cut(value, ".")
+
+	// Another player with the signature of ann
+	forged := base64.RawURLEncoding.EncodeToString([]byte(`{"name":"bob","uri":"room","iat":0,"exp":99999999999}`))
+
+	// The same session signed with another secret
+	other, err := NewSessionService([]byte("other secret"), time.Hour).Sign(&models.JoinRoomInput{User: "ann", Uri: "room"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A session that was signed to last no time at all
+	expired, err := NewSessionService([]byte("test secret"), -time.Second).Sign(&models.JoinRoomInput{User: "ann", Uri: "room"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		value string
+	}{
+		{"empty", ""},
+		{"no signature", encoded},
+		{"tampered payload", forged + "." + signature},
+		{"tampered mac", encoded + "." + base64.RawURLEncoding.EncodeToString([]byte("not the mac"))},
+		{"mac not base64", encoded + ".!"},
+		{"other secret", other},
+		{"expired", expired},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if session, err := sessionService.Verify(test.value); err != ErrInvalidSession {
+				t.Errorf("got session %+v and error %v, want %v", session, err, ErrInvalidSession)
+			}
+		})
+	}
+}